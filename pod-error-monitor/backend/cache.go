@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"pod-error-monitor/config"
+	"pod-error-monitor/detect"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// recomputeDebounce coalesces bursts of pod events into a single recompute
+const recomputeDebounce = 500 * time.Millisecond
+
+// podCache keeps an informer-backed copy of all pods in the cluster and
+// periodically recomputes the namespace statistics from it, so HTTP requests
+// never have to list pods against the API server. The informers never
+// resync; the recompute ticker alone brings up the errors that depend on
+// time, like pods stuck pending or overdue CronJobs.
+type podCache struct {
+	cluster string
+	factory informers.SharedInformerFactory
+	lister  corelisters.PodLister
+	synced  cache.InformerSynced
+	// Events come from a separate factory limited to pod warnings. They
+	// only enrich the errors, so the cache does not wait for them to sync.
+	eventFactory informers.SharedInformerFactory
+	events       corelisters.EventLister
+	workloads    *workloadListers
+	// ConfigMaps and Secrets check the references of pods that cannot
+	// start. Secrets are watched as metadata only, and not at all without
+	// monitoring.check_secrets. The cache does not wait for either.
+	configFactory informers.SharedInformerFactory
+	secretFactory metadatainformer.SharedInformerFactory
+	configs       *configRefs
+	configsSynced []cache.InformerSynced
+	monitoring    *config.MonitoringConfig
+	detectors     *detect.Registry
+	interval      time.Duration
+	onUpdate      func(stats []NamespaceStats, errors []PodError)
+	changed       chan struct{}
+	stopCh        chan struct{}
+	stopOnce      sync.Once
+
+	mu        sync.RWMutex
+	stats     []NamespaceStats
+	errors    []PodError
+	updatedAt time.Time
+}
+
+// newPodCache creates a cache for the cluster behind clientset and
+// metadataClient. onUpdate, if not nil, is called with the full stats and
+// error list after every recompute.
+func newPodCache(clientset kubernetes.Interface, metadataClient metadata.Interface, cluster string, monitoring *config.MonitoringConfig,
+	detectors *detect.Registry, interval time.Duration,
+	onUpdate func(stats []NamespaceStats, errors []PodError)) *podCache {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithTransform(stripManagedFields))
+	podInformer := factory.Core().V1().Pods()
+
+	eventFactory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithTransform(stripManagedFields),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = detect.PodEventSelector
+		}))
+	eventInformer := eventFactory.Core().V1().Events()
+
+	configFactory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithTransform(stripConfigMapValues))
+	var secretFactory metadatainformer.SharedInformerFactory
+	if monitoring.CheckSecrets {
+		secretFactory = metadatainformer.NewSharedInformerFactoryWithOptions(metadataClient, 0,
+			metadatainformer.WithTransform(stripManagedFields))
+	}
+
+	c := &podCache{
+		cluster:       cluster,
+		factory:       factory,
+		lister:        podInformer.Lister(),
+		synced:        podInformer.Informer().HasSynced,
+		eventFactory:  eventFactory,
+		events:        eventInformer.Lister(),
+		workloads:     newWorkloadListers(factory),
+		configFactory: configFactory,
+		secretFactory: secretFactory,
+		monitoring:    monitoring,
+		detectors:     detectors,
+		interval:      interval,
+		onUpdate:      onUpdate,
+		changed:       make(chan struct{}, 1),
+		stopCh:        make(chan struct{}),
+	}
+
+	podInformer.Informer().SetWatchErrorHandler(func(r *cache.Reflector, err error) {
+		kubernetesAPIErrors.WithLabelValues(cluster).Inc()
+		cache.DefaultWatchErrorHandler(r, err)
+	})
+
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { c.markDirty() },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldPod, ok1 := oldObj.(*v1.Pod)
+			newPod, ok2 := newObj.(*v1.Pod)
+			// Relists deliver unchanged objects; skip those
+			if ok1 && ok2 && oldPod.ResourceVersion == newPod.ResourceVersion {
+				return
+			}
+			c.markDirty()
+		},
+		DeleteFunc: func(obj interface{}) { c.markDirty() },
+	})
+
+	eventInformer.Informer().SetWatchErrorHandler(func(r *cache.Reflector, err error) {
+		kubernetesAPIErrors.WithLabelValues(cluster).Inc()
+		cache.DefaultWatchErrorHandler(r, err)
+	})
+
+	eventInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if event, ok := obj.(*v1.Event); ok && detect.IsTrackedEvent(event.Reason) {
+				c.markDirty()
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldEvent, ok1 := oldObj.(*v1.Event)
+			newEvent, ok2 := newObj.(*v1.Event)
+			if !ok1 || !ok2 || oldEvent.ResourceVersion == newEvent.ResourceVersion || !detect.IsTrackedEvent(newEvent.Reason) {
+				return
+			}
+			c.markDirty()
+		},
+	})
+
+	// Jobs, CronJobs, Deployments and StatefulSets raise errors of their own,
+	// and ReplicaSets tie pods to their Deployment
+	workloadHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { c.markDirty() },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldMeta, ok1 := oldObj.(metav1.Object)
+			newMeta, ok2 := newObj.(metav1.Object)
+			if ok1 && ok2 && oldMeta.GetResourceVersion() == newMeta.GetResourceVersion() {
+				return
+			}
+			c.markDirty()
+		},
+		DeleteFunc: func(obj interface{}) { c.markDirty() },
+	}
+	factory.Batch().V1().Jobs().Informer().AddEventHandler(workloadHandler)
+	factory.Batch().V1().CronJobs().Informer().AddEventHandler(workloadHandler)
+	factory.Apps().V1().Deployments().Informer().AddEventHandler(workloadHandler)
+	factory.Apps().V1().StatefulSets().Informer().AddEventHandler(workloadHandler)
+	factory.Apps().V1().ReplicaSets().Informer().AddEventHandler(workloadHandler)
+
+	c.configs, c.configsSynced = newConfigInformers(configFactory, secretFactory, c.markDirty)
+
+	return c
+}
+
+// Start runs the informers, waits for the initial sync and starts the
+// recompute loop. The cache is stopped again if the sync does not finish
+// before ctx is done.
+func (c *podCache) Start(ctx context.Context) error {
+	c.factory.Start(c.stopCh)
+	c.eventFactory.Start(c.stopCh)
+	c.configFactory.Start(c.stopCh)
+	if c.secretFactory != nil {
+		c.secretFactory.Start(c.stopCh)
+	}
+
+	syncCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-c.stopCh:
+			cancel()
+		case <-syncCtx.Done():
+		}
+	}()
+
+	if !cache.WaitForCacheSync(syncCtx.Done(), c.synced) {
+		c.Stop()
+		return fmt.Errorf("timed out waiting for pod cache to sync")
+	}
+
+	c.recompute()
+	go c.run()
+
+	return nil
+}
+
+// Stop shuts down the informers and the recompute loop
+func (c *podCache) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopCh)
+		c.factory.Shutdown()
+		c.eventFactory.Shutdown()
+		c.configFactory.Shutdown()
+		if c.secretFactory != nil {
+			c.secretFactory.Shutdown()
+		}
+	})
+}
+
+func (c *podCache) run() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-c.changed:
+			select {
+			case <-c.stopCh:
+				return
+			case <-time.After(recomputeDebounce):
+			}
+			c.recompute()
+		case <-ticker.C:
+			// Errors come and go with time alone, so recompute even when
+			// nothing changed
+			c.recompute()
+		}
+	}
+}
+
+func (c *podCache) recompute() {
+	start := time.Now()
+
+	pods, err := c.lister.List(labels.Everything())
+	if err != nil {
+		log.Printf("Error listing pods from cache: %v", err)
+		return
+	}
+	errors := c.podErrors(pods, c.eventIndex(), c.workloadIndex(metav1.NamespaceAll, pods), c.configRefs())
+	stats := calculateNamespaceStats(errors, c.monitoring)
+	for i := range stats {
+		stats[i].Cluster = c.cluster
+	}
+
+	c.mu.Lock()
+	c.stats = stats
+	c.errors = errors
+	c.updatedAt = time.Now()
+	c.mu.Unlock()
+
+	refreshDuration.WithLabelValues(c.cluster).Observe(time.Since(start).Seconds())
+
+	if c.onUpdate != nil {
+		c.onUpdate(stats, errors)
+	}
+}
+
+// markDirty schedules a recompute after the debounce
+func (c *podCache) markDirty() {
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+// NamespaceStats returns the stats computed on the last refresh
+func (c *podCache) NamespaceStats() []NamespaceStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.stats
+}
+
+// Pods returns the cached pods of a namespace
+func (c *podCache) Pods(namespace string) ([]*v1.Pod, error) {
+	return c.lister.Pods(namespace).List(labels.Everything())
+}
+
+// AllPodErrors returns the pod errors computed on the last refresh
+func (c *podCache) AllPodErrors() []PodError {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.errors
+}
+
+// Age returns how long ago the stats were last confirmed to be current
+func (c *podCache) Age() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return time.Since(c.updatedAt)
+}
+
+// PodErrors returns the current pod errors of a namespace
+func (c *podCache) PodErrors(namespace string) ([]PodError, error) {
+	pods, err := c.Pods(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return c.podErrors(pods, c.eventIndex(), c.workloadIndex(namespace, pods), c.configRefs()), nil
+}
+
+func (c *podCache) podErrors(pods []*v1.Pod, events *detect.EventIndex, workloads *detect.Workloads, configs detect.ConfigLookup) []PodError {
+	errors := getPodErrors(pods, events, workloads, configs, c.detectors, c.monitoring)
+	for i := range errors {
+		errors[i].Cluster = c.cluster
+	}
+	return errors
+}
+
+// eventIndex indexes the recent pod warnings, or returns nil while the
+// event informer has not synced
+func (c *podCache) eventIndex() *detect.EventIndex {
+	if !c.eventFactory.Core().V1().Events().Informer().HasSynced() {
+		return nil
+	}
+	return newEventIndex(c.events, eventWindow(c.monitoring), time.Now())
+}
+
+// workloadIndex collects the workloads of namespace and their pods, or
+// returns nil while the workload informers have not synced
+func (c *podCache) workloadIndex(namespace string, pods []*v1.Pod) *detect.Workloads {
+	if !c.workloads.hasSynced() {
+		return nil
+	}
+	return newWorkloadIndex(c.workloads, namespace, pods)
+}
+
+// configRefs returns the ConfigMap and Secret lookups, or nil while their
+// informers have not synced
+func (c *podCache) configRefs() detect.ConfigLookup {
+	for _, synced := range c.configsSynced {
+		if !synced() {
+			return nil
+		}
+	}
+	return c.configs
+}
+
+// stripManagedFields drops the server-side apply bookkeeping before objects
+// are stored, which is a large share of the memory on big clusters.
+func stripManagedFields(obj interface{}) (interface{}, error) {
+	if accessor, err := meta.Accessor(obj); err == nil {
+		accessor.SetManagedFields(nil)
+	}
+	return obj, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestStripManagedFields(t *testing.T) {
+	managed := func() metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: "api", ManagedFields: []metav1.ManagedFieldsEntry{{Manager: "kubectl"}}}
+	}
+
+	tests := []struct {
+		name string
+		obj  runtime.Object
+	}{
+		{name: "pod", obj: &v1.Pod{ObjectMeta: managed()}},
+		{name: "event", obj: &v1.Event{ObjectMeta: managed()}},
+		{name: "deployment", obj: &appsv1.Deployment{ObjectMeta: managed()}},
+		{name: "metadata only", obj: &metav1.PartialObjectMetadata{ObjectMeta: managed()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stripped, err := stripManagedFields(tt.obj)
+			if err != nil {
+				t.Fatalf("stripManagedFields() error = %v", err)
+			}
+			accessor, err := meta.Accessor(stripped)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if accessor.GetManagedFields() != nil {
+				t.Errorf("managed fields = %v, want none", accessor.GetManagedFields())
+			}
+		})
+	}
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"pod-error-monitor/config"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	ClusterConnecting  = "connecting"
+	ClusterConnected   = "connected"
+	ClusterUnreachable = "unreachable"
+)
+
+const (
+	// clusterHealthInterval is how often a connected cluster is probed
+	clusterHealthInterval = 30 * time.Second
+	// clusterHealthTimeout bounds a single probe
+	clusterHealthTimeout = 10 * time.Second
+	// clusterMinBackoff and clusterMaxBackoff bound the retry delay of an
+	// unreachable cluster, which doubles after every failure
+	clusterMinBackoff = 5 * time.Second
+	clusterMaxBackoff = 5 * time.Minute
+	// clusterReadyTimeout is how long a request waits for a context it
+	// selected for the first time
+	clusterReadyTimeout = 30 * time.Second
+	// clusterIdleTimeout stops contexts that were selected per request once
+	// no request has used them for this long
+	clusterIdleTimeout = 30 * time.Minute
+)
+
+// contextCookie stores the context a dashboard session selected
+const contextCookie = "pod-error-monitor-context"
+
+// ClusterSummary is the state and error totals of one monitored cluster
+type ClusterSummary struct {
+	Name                string     `json:"name"`
+	Status              string     `json:"status"`
+	OnDemand            bool       `json:"onDemand"`
+	LastError           string     `json:"lastError,omitempty"`
+	LastContact         *time.Time `json:"lastContact,omitempty"`
+	NextRetry           *time.Time `json:"nextRetry,omitempty"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	Namespaces          int        `json:"namespaces"`
+	TotalErrors         int        `json:"totalErrors"`
+	UniquePods          int        `json:"uniquePods"`
+	CrashLoop           int        `json:"crashLoop"`
+	ImagePull           int        `json:"imagePull"`
+	HighRestarts        int        `json:"highRestarts"`
+	Pending             int        `json:"pending"`
+	Rollout             int        `json:"rollout"`
+	Custom              int        `json:"custom"`
+	Score               float64    `json:"score"`
+}
+
+// FleetSummary is the response of /api/clusters
+type FleetSummary struct {
+	Clusters    []ClusterSummary `json:"clusters"`
+	Connected   int              `json:"connected"`
+	TotalErrors int              `json:"totalErrors"`
+	Score       float64          `json:"score"`
+}
+
+// cluster supervises the pod cache of one monitored cluster. It keeps
+// retrying with an exponential backoff while the cluster is unreachable and
+// probes it periodically once connected.
+type cluster struct {
+	name       string
+	restConfig *rest.Config
+	server     *Server
+	onDemand   bool
+	ready      chan struct{}
+	stopCh     chan struct{}
+	stopOnce   sync.Once
+
+	mu          sync.RWMutex
+	lastUsed    time.Time
+	cache       *podCache
+	status      string
+	lastError   string
+	lastContact time.Time
+	failures    int
+	nextRetry   time.Time
+}
+
+func (s *Server) newCluster(name string, restConfig *rest.Config) *cluster {
+	return &cluster{
+		name:       name,
+		restConfig: restConfig,
+		server:     s,
+		ready:      make(chan struct{}),
+		stopCh:     make(chan struct{}),
+		status:     ClusterConnecting,
+	}
+}
+
+// addCluster adds a permanently monitored cluster to the pool and starts it
+func (s *Server) addCluster(c *cluster) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clusters[c.name] = c
+	s.defaultClusters = append(s.defaultClusters, c.name)
+	go c.run()
+}
+
+// clusterFor returns the pooled cluster for a name, starting to monitor the
+// kubeconfig context of that name if it is not pooled yet. A context with
+// the target of a pooled cluster is served by that cluster.
+func (s *Server) clusterFor(name string) (*cluster, error) {
+	if c := s.pooledCluster(name); c != nil {
+		return c, nil
+	}
+
+	// Reading the kubeconfig may take a while, so it happens unlocked and
+	// the pool is checked again afterwards
+	if s.config == nil {
+		return nil, fmt.Errorf("context %q not found", name)
+	}
+	rawConfig, err := s.config.RawConfig()
+	if err != nil {
+		return nil, err
+	}
+	if _, exists := rawConfig.Contexts[name]; !exists {
+		return nil, fmt.Errorf("context %q not found", name)
+	}
+
+	restConfig, err := buildClusterConfig(config.ClusterConfig{
+		Name:           name,
+		KubeconfigPath: s.appConfig.Kubernetes.KubeconfigPath,
+		Context:        name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c := s.pooledClusterLocked(name); c != nil {
+		return c, nil
+	}
+	target := clusterTarget(restConfig)
+	for _, c := range s.clusters {
+		if clusterTarget(c.restConfig) == target {
+			s.aliases[name] = c.name
+			c.touch()
+			return c, nil
+		}
+	}
+
+	c := s.newCluster(name, restConfig)
+	c.onDemand = true
+	c.touch()
+	s.clusters[name] = c
+	go c.run()
+
+	return c, nil
+}
+
+// pooledCluster returns the pooled cluster serving name, if any
+func (s *Server) pooledCluster(name string) *cluster {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.pooledClusterLocked(name)
+}
+
+func (s *Server) pooledClusterLocked(name string) *cluster {
+	if alias, exists := s.aliases[name]; exists {
+		name = alias
+	}
+	c, exists := s.clusters[name]
+	if !exists {
+		return nil
+	}
+	c.touch()
+	return c
+}
+
+// clusterTarget identifies the API server a config talks to and the
+// credentials it uses, so that two contexts of the same cluster and user
+// share one cache while another user keeps the view its RBAC grants
+func clusterTarget(c *rest.Config) string {
+	target := []string{c.Host, c.Username, c.BearerToken, c.BearerTokenFile,
+		c.CertFile, string(c.CertData), c.Impersonate.UserName}
+	if c.ExecProvider != nil {
+		target = append(target, c.ExecProvider.Command)
+		target = append(target, c.ExecProvider.Args...)
+		for _, env := range c.ExecProvider.Env {
+			target = append(target, env.Name+"="+env.Value)
+		}
+	}
+	if c.AuthProvider != nil {
+		target = append(target, c.AuthProvider.Name)
+	}
+	return strings.Join(target, "\x00")
+}
+
+// selectedContext returns the context selected by the request: the
+// context or cluster query parameter, or else the session cookie
+func (s *Server) selectedContext(r *http.Request) string {
+	query := r.URL.Query()
+	if name := query.Get("context"); name != "" {
+		return name
+	}
+	if name := query.Get("cluster"); name != "" {
+		return name
+	}
+	if cookie, err := r.Cookie(contextCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// requestedCluster returns the name of the cluster serving the context the
+// request selected, which differs from the context when that shares the
+// target of another cluster
+func (s *Server) requestedCluster(r *http.Request) string {
+	name := s.selectedContext(r)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if alias, exists := s.aliases[name]; exists {
+		return alias
+	}
+	return name
+}
+
+// requestClusters returns the clusters a request should be served from.
+// A context selected in the query must exist; a stale cookie falls back to
+// the default clusters.
+func (s *Server) requestClusters(r *http.Request) ([]*cluster, error) {
+	name := s.requestedCluster(r)
+	if name == "" {
+		return s.defaultClusterList(), nil
+	}
+
+	c, err := s.clusterFor(name)
+	if err != nil {
+		query := r.URL.Query()
+		if query.Get("context") == "" && query.Get("cluster") == "" {
+			return s.defaultClusterList(), nil
+		}
+		return nil, err
+	}
+
+	c.waitReady(r.Context(), clusterReadyTimeout)
+	return []*cluster{c}, nil
+}
+
+func (s *Server) defaultClusterList() []*cluster {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*cluster, 0, len(s.defaultClusters))
+	for _, name := range s.defaultClusters {
+		result = append(result, s.clusters[name])
+	}
+	return result
+}
+
+func (s *Server) defaultClusterNames() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]string{}, s.defaultClusters...)
+}
+
+// isDefaultCluster reports whether the cluster is monitored permanently
+func (s *Server) isDefaultCluster(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, defaultName := range s.defaultClusters {
+		if defaultName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// evictIdleClusters stops the on-demand clusters no request has used lately
+func (s *Server) evictIdleClusters() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		var idle []*cluster
+
+		s.mu.Lock()
+		for name, c := range s.clusters {
+			if c.onDemand && c.idleSince() > clusterIdleTimeout {
+				delete(s.clusters, name)
+				idle = append(idle, c)
+			}
+		}
+		for alias, name := range s.aliases {
+			if _, exists := s.clusters[name]; !exists {
+				delete(s.aliases, alias)
+			}
+		}
+		s.mu.Unlock()
+
+		for _, c := range idle {
+			c.stop()
+			s.stream.removeCluster(c.name)
+			s.incidents.removeCluster(c.name, time.Now())
+			if s.history != nil {
+				s.history.removeCluster(c.name)
+			}
+		}
+	}
+}
+
+func (c *cluster) touch() {
+	c.mu.Lock()
+	c.lastUsed = time.Now()
+	c.mu.Unlock()
+}
+
+func (c *cluster) idleSince() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return time.Since(c.lastUsed)
+}
+
+// waitReady blocks until the pod cache has synced, the first connection
+// attempt failed, ctx is done or timeout elapsed
+func (c *cluster) waitReady(ctx context.Context, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-c.ready:
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// run connects to the cluster and keeps checking it until stop is called
+func (c *cluster) run() {
+	clientset, err := kubernetes.NewForConfig(c.restConfig)
+	if err != nil {
+		c.recordFailure(err, 0)
+		close(c.ready)
+		return
+	}
+	// Secrets are only ever read as metadata
+	metadataClient, err := metadata.NewForConfig(c.restConfig)
+	if err != nil {
+		c.recordFailure(err, 0)
+		close(c.ready)
+		return
+	}
+
+	backoff := clusterMinBackoff
+	first := true
+	for {
+		err := c.check(clientset, metadataClient)
+		if first {
+			close(c.ready)
+			first = false
+		}
+
+		wait := clusterHealthInterval
+		if err != nil {
+			wait = backoff
+			c.recordFailure(err, backoff)
+			backoff *= 2
+			if backoff > clusterMaxBackoff {
+				backoff = clusterMaxBackoff
+			}
+		} else {
+			c.recordSuccess()
+			backoff = clusterMinBackoff
+		}
+
+		select {
+		case <-c.stopCh:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// check starts the pod cache if it is not running yet, or probes the API
+// server otherwise. The informers reconnect on their own, so a failing probe
+// only changes the reported status.
+func (c *cluster) check(clientset kubernetes.Interface, metadataClient metadata.Interface) error {
+	if c.podCache() == nil {
+		cache, err := c.server.startPodCache(clientset, metadataClient, c.name)
+		if err != nil {
+			return err
+		}
+
+		c.mu.Lock()
+		select {
+		case <-c.stopCh:
+			c.mu.Unlock()
+			cache.Stop()
+			return nil
+		default:
+		}
+		c.cache = cache
+		c.mu.Unlock()
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), clusterHealthTimeout)
+	defer cancel()
+	_, err := clientset.Discovery().RESTClient().Get().AbsPath("/readyz").DoRaw(ctx)
+	return err
+}
+
+func (c *cluster) recordFailure(err error, backoff time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	kubernetesAPIErrors.WithLabelValues(c.name).Inc()
+
+	c.status = ClusterUnreachable
+	c.lastError = err.Error()
+	c.failures++
+	c.nextRetry = time.Time{}
+	if backoff > 0 {
+		c.nextRetry = time.Now().Add(backoff)
+	}
+}
+
+func (c *cluster) recordSuccess() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.status = ClusterConnected
+	c.lastError = ""
+	c.lastContact = time.Now()
+	c.failures = 0
+	c.nextRetry = time.Time{}
+}
+
+// stop shuts the supervisor and the pod cache down
+func (c *cluster) stop() {
+	c.stopOnce.Do(func() {
+		c.mu.Lock()
+		close(c.stopCh)
+		cache := c.cache
+		c.mu.Unlock()
+
+		if cache != nil {
+			cache.Stop()
+		}
+	})
+}
+
+// podCache returns the cache of the cluster, or nil before the first sync
+func (c *cluster) podCache() *podCache {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cache
+}
+
+func (c *cluster) summary() ClusterSummary {
+	c.mu.RLock()
+	summary := ClusterSummary{
+		Name:                c.name,
+		Status:              c.status,
+		OnDemand:            c.onDemand,
+		LastError:           c.lastError,
+		ConsecutiveFailures: c.failures,
+	}
+	if !c.lastContact.IsZero() {
+		lastContact := c.lastContact
+		summary.LastContact = &lastContact
+	}
+	if !c.nextRetry.IsZero() {
+		nextRetry := c.nextRetry
+		summary.NextRetry = &nextRetry
+	}
+	cache := c.cache
+	c.mu.RUnlock()
+
+	if cache == nil {
+		return summary
+	}
+
+	for _, ns := range cache.NamespaceStats() {
+		summary.Namespaces++
+		summary.TotalErrors += ns.TotalErrors
+		summary.UniquePods += ns.UniquePods
+		summary.CrashLoop += ns.CrashLoop
+		summary.ImagePull += ns.ImagePull
+		summary.HighRestarts += ns.HighRestarts
+		summary.Pending += ns.Pending
+		summary.Rollout += ns.Rollout
+		summary.Custom += ns.Custom
+		summary.Score += ns.Score
+	}
+	return summary
+}
+
+// clusterList returns every pooled cluster in name order
+func (s *Server) clusterList() []*cluster {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*cluster, 0, len(s.clusters))
+	for _, c := range s.clusters {
+		result = append(result, c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].name < result[j].name
+	})
+	return result
+}
+
+func (s *Server) getClusters(w http.ResponseWriter, r *http.Request) {
+	fleet := FleetSummary{Clusters: []ClusterSummary{}}
+	for _, c := range s.clusterList() {
+		summary := c.summary()
+		fleet.Clusters = append(fleet.Clusters, summary)
+		if summary.Status == ClusterConnected {
+			fleet.Connected++
+		}
+		fleet.TotalErrors += summary.TotalErrors
+		fleet.Score += summary.Score
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fleet)
+}
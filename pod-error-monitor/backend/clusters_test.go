@@ -0,0 +1,227 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"pod-error-monitor/config"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: prod
+  cluster:
+    server: https://127.0.0.1:1
+- name: staging
+  cluster:
+    server: https://127.0.0.2:1
+users:
+- name: admin
+  user:
+    token: admin-token
+- name: viewer
+  user:
+    token: viewer-token
+contexts:
+- name: prod
+  context: {cluster: prod, user: admin}
+- name: prod-admin
+  context: {cluster: prod, user: admin, namespace: shop}
+- name: prod-viewer
+  context: {cluster: prod, user: viewer}
+- name: staging
+  context: {cluster: staging, user: admin}
+current-context: prod
+`
+
+// newTestServer returns a server monitoring the prod context of the test
+// kubeconfig, without connecting to it
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(path, []byte(testKubeconfig), 0600); err != nil {
+		t.Fatalf("writing kubeconfig: %v", err)
+	}
+	cfg := &config.Config{}
+	cfg.Kubernetes.KubeconfigPath = path
+
+	s := &Server{
+		config: clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: path}, &clientcmd.ConfigOverrides{}),
+		clusters:  make(map[string]*cluster),
+		aliases:   make(map[string]string),
+		appConfig: cfg,
+	}
+	restConfig, err := buildClusterConfig(config.ClusterConfig{Name: "prod", KubeconfigPath: path, Context: "prod"})
+	if err != nil {
+		t.Fatalf("buildClusterConfig: %v", err)
+	}
+	s.clusters["prod"] = s.newCluster("prod", restConfig)
+	s.defaultClusters = []string{"prod"}
+	return s
+}
+
+func TestClusterForSharesTargets(t *testing.T) {
+	tests := []struct {
+		name        string
+		context     string
+		wantCluster string
+		wantPool    int
+	}{
+		{name: "pooled cluster", context: "prod", wantCluster: "prod", wantPool: 1},
+		{name: "same cluster and user", context: "prod-admin", wantCluster: "prod", wantPool: 1},
+		{name: "same cluster, other user", context: "prod-viewer", wantCluster: "prod-viewer", wantPool: 2},
+		{name: "other cluster", context: "staging", wantCluster: "staging", wantPool: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			c, err := s.clusterFor(tt.context)
+			if err != nil {
+				t.Fatalf("clusterFor: %v", err)
+			}
+			defer func() {
+				if c.onDemand {
+					c.stop()
+				}
+			}()
+			if c.name != tt.wantCluster {
+				t.Errorf("cluster = %s, want %s", c.name, tt.wantCluster)
+			}
+
+			r := httptest.NewRequest("GET", "/api/namespaces?context="+tt.context, nil)
+			if got := s.requestedCluster(r); got != tt.wantCluster {
+				t.Errorf("requestedCluster = %s, want %s", got, tt.wantCluster)
+			}
+			if len(s.clusters) != tt.wantPool {
+				t.Errorf("pool holds %d clusters, want %d", len(s.clusters), tt.wantPool)
+			}
+		})
+	}
+
+	if _, err := newTestServer(t).clusterFor("missing"); err == nil {
+		t.Errorf("clusterFor of a missing context succeeded")
+	}
+}
+
+func TestStreamFilterClusters(t *testing.T) {
+	prod := crashLoop("shop", "api-1")
+	prod.Cluster = "prod"
+	browsed := crashLoop("shop", "web-1")
+	browsed.Cluster = "browsed"
+
+	snapshot := StreamEvent{
+		Type:   "snapshot",
+		Stats:  []NamespaceStats{{Cluster: "prod", Name: "shop"}, {Cluster: "browsed", Name: "shop"}},
+		Errors: []PodError{prod, browsed},
+	}
+
+	tests := []struct {
+		name       string
+		filter     streamFilter
+		event      StreamEvent
+		wantSent   bool
+		wantErrors int
+		wantStats  int
+	}{
+		{
+			name:       "default clusters leave out browsed contexts",
+			filter:     streamFilter{clusters: clusterFilter("prod")},
+			event:      snapshot,
+			wantSent:   true,
+			wantErrors: 1,
+			wantStats:  1,
+		},
+		{
+			name:       "no filter",
+			filter:     streamFilter{},
+			event:      snapshot,
+			wantSent:   true,
+			wantErrors: 2,
+			wantStats:  2,
+		},
+		{
+			name:   "update of a browsed context",
+			filter: streamFilter{clusters: clusterFilter("prod")},
+			event:  StreamEvent{Type: "update", Cluster: "browsed", Added: []PodError{browsed}},
+		},
+		{
+			name:     "update of a default cluster",
+			filter:   streamFilter{clusters: clusterFilter("prod")},
+			event:    StreamEvent{Type: "update", Cluster: "prod", Added: []PodError{prod}},
+			wantSent: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, sent := tt.filter.apply(tt.event)
+			if sent != tt.wantSent {
+				t.Fatalf("sent = %v, want %v", sent, tt.wantSent)
+			}
+			if len(event.Errors) != tt.wantErrors || len(event.Stats) != tt.wantStats {
+				t.Errorf("errors %d, stats %d, want %d, %d", len(event.Errors), len(event.Stats), tt.wantErrors, tt.wantStats)
+			}
+		})
+	}
+}
+
+func TestStreamFilterStats(t *testing.T) {
+	shop := NamespaceStats{Cluster: "prod", Name: "shop", Score: 4}
+	payments := NamespaceStats{Cluster: "prod", Name: "payments", Score: 2}
+	changedShop := shop
+	changedShop.Score = 6
+
+	filter := &streamFilter{namespace: "shop"}
+	steps := []struct {
+		name      string
+		event     StreamEvent
+		wantSent  bool
+		wantStats []NamespaceStats
+	}{
+		{
+			name:      "snapshot",
+			event:     StreamEvent{Type: "snapshot", Stats: []NamespaceStats{shop, payments}},
+			wantSent:  true,
+			wantStats: []NamespaceStats{shop},
+		},
+		{
+			name:  "other namespace changed",
+			event: StreamEvent{Type: "update", Cluster: "prod", Stats: []NamespaceStats{shop, {Cluster: "prod", Name: "payments", Score: 3}}},
+		},
+		{
+			name:      "selected namespace changed",
+			event:     StreamEvent{Type: "update", Cluster: "prod", Stats: []NamespaceStats{changedShop, payments}},
+			wantSent:  true,
+			wantStats: []NamespaceStats{changedShop},
+		},
+		{
+			name:      "selected namespace cleared",
+			event:     StreamEvent{Type: "update", Cluster: "prod", Stats: []NamespaceStats{payments}},
+			wantSent:  true,
+			wantStats: []NamespaceStats{},
+		},
+		{
+			name:  "still cleared",
+			event: StreamEvent{Type: "update", Cluster: "prod", Stats: []NamespaceStats{}},
+		},
+	}
+
+	for _, step := range steps {
+		event, sent := filter.apply(step.event)
+		if sent != step.wantSent {
+			t.Fatalf("%s: sent = %v, want %v", step.name, sent, step.wantSent)
+		}
+		if sent && !reflect.DeepEqual(event.Stats, step.wantStats) {
+			t.Errorf("%s: stats = %+v, want %+v", step.name, event.Stats, step.wantStats)
+		}
+	}
+}
@@ -3,14 +3,20 @@ package config
 import (
 	"fmt"
 	"os"
+	"path"
+
+	"pod-error-monitor/detect"
+	"pod-error-monitor/rules"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Server     ServerConfig     `yaml:"server"`
-	Kubernetes KubernetesConfig `yaml:"kubernetes"`
-	Monitoring MonitoringConfig `yaml:"monitoring"`
+	Server        ServerConfig        `yaml:"server"`
+	Kubernetes    KubernetesConfig    `yaml:"kubernetes"`
+	Monitoring    MonitoringConfig    `yaml:"monitoring"`
+	History       HistoryConfig       `yaml:"history"`
+	Notifications NotificationsConfig `yaml:"notifications"`
 }
 
 type ServerConfig struct {
@@ -25,25 +31,275 @@ type CORSConfig struct {
 }
 
 type KubernetesConfig struct {
-	UseInCluster    bool   `yaml:"use_in_cluster"`
-	KubeconfigPath  string `yaml:"kubeconfig_path"`
-	DefaultContext  string `yaml:"default_context"`
-	RefreshInterval int    `yaml:"refresh_interval"`
+	UseInCluster    bool            `yaml:"use_in_cluster"`
+	KubeconfigPath  string          `yaml:"kubeconfig_path"`
+	DefaultContext  string          `yaml:"default_context"`
+	RefreshInterval int             `yaml:"refresh_interval"`
+	Clusters        []ClusterConfig `yaml:"clusters"`
+}
+
+// ClusterConfig selects one cluster to monitor when watching several at once
+type ClusterConfig struct {
+	Name           string `yaml:"name"`            // defaults to the context name
+	KubeconfigPath string `yaml:"kubeconfig_path"` // defaults to kubernetes.kubeconfig_path
+	Context        string `yaml:"context"`         // defaults to the current context of the file
+}
+
+type HistoryConfig struct {
+	Enabled            bool   `yaml:"enabled"`
+	Path               string `yaml:"path"`
+	Retention          int    `yaml:"retention"`           // hours
+	SnapshotInterval   int    `yaml:"snapshot_interval"`   // seconds
+	CheckpointInterval int    `yaml:"checkpoint_interval"` // minutes
+}
+
+// NotificationsConfig decides when alerts are raised and where they are sent
+type NotificationsConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	ScoreThreshold float64       `yaml:"score_threshold"` // alert when a namespace score reaches this, 0 disables
+	ErrorTypes     []string      `yaml:"error_types"`     // glob patterns of error types that alert when they appear
+	ResendInterval int           `yaml:"resend_interval"` // minutes between reminders of a firing alert
+	Sinks          []SinkConfig  `yaml:"sinks"`
+	Routes         []RouteConfig `yaml:"routes"`
+}
+
+const (
+	SinkWebhook      = "webhook"
+	SinkSlack        = "slack"
+	SinkAlertmanager = "alertmanager"
+)
+
+// SinkConfig is one notification target
+type SinkConfig struct {
+	Name    string            `yaml:"name"`
+	Type    string            `yaml:"type"` // webhook, slack or alertmanager
+	URL     string            `yaml:"url"`  // base URL for alertmanager, the full URL otherwise
+	Headers map[string]string `yaml:"headers"`
+	Timeout int               `yaml:"timeout"` // seconds
+}
+
+// RouteConfig sends the alerts matching all of its non-empty filters to
+// Sinks. Routes are tried in order and the first match wins unless Continue
+// is set. Without routes every alert goes to every sink.
+type RouteConfig struct {
+	Clusters   []string `yaml:"clusters"`    // glob patterns
+	Namespaces []string `yaml:"namespaces"`  // glob patterns
+	ErrorTypes []string `yaml:"error_types"` // glob patterns, never matching score alerts
+	Sinks      []string `yaml:"sinks"`
+	Continue   bool     `yaml:"continue"`
+}
+
+// Matches reports whether the route applies to an alert. Score alerts have
+// no error type.
+func (r *RouteConfig) Matches(cluster, namespace, errorType string) bool {
+	if len(r.ErrorTypes) > 0 && (errorType == "" || !MatchesAny(r.ErrorTypes, errorType)) {
+		return false
+	}
+	if len(r.Clusters) > 0 && !MatchesAny(r.Clusters, cluster) {
+		return false
+	}
+	return len(r.Namespaces) == 0 || MatchesAny(r.Namespaces, namespace)
+}
+
+// MatchesAny reports whether value matches one of the glob patterns
+func MatchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, value); err == nil && matched {
+			return true
+		}
+	}
+	return false
 }
 
 type MonitoringConfig struct {
-	HighRestartThreshold int          `yaml:"high_restart_threshold"`
-	ErrorWeights         ErrorWeights `yaml:"error_weights"`
+	HighRestartThreshold *int                `yaml:"high_restart_threshold"`
+	TerminationWindow    int                 `yaml:"termination_window"` // minutes a crash of a restarted container stays flagged
+	PendingTimeout       int                 `yaml:"pending_timeout"`    // minutes a pod may stay Pending before it is flagged
+	ResolveAfter         int                 `yaml:"resolve_after"`      // minutes an error must stay gone before its incident and alerts resolve
+	RolloutDeadline      int                 `yaml:"rollout_deadline"`   // minutes a StatefulSet may run below its desired replicas
+	ScoreBy              string              `yaml:"score_by"`           // "pod" counts every replica, "workload" each error once per workload
+	CheckSecrets         bool                `yaml:"check_secrets"`      // report missing Secrets, which needs list and watch on secrets; off by default
+	ErrorWeights         ErrorWeights        `yaml:"error_weights"`
+	NamespaceOverrides   []NamespaceOverride `yaml:"namespace_overrides"`
+	Metrics              MetricsConfig       `yaml:"metrics"`
+	Events               EventsConfig        `yaml:"events"`
+	Jobs                 JobsConfig          `yaml:"jobs"`
+	Detectors            DetectorsConfig     `yaml:"detectors"`
+	Rules                []RuleConfig        `yaml:"rules"`
+}
+
+// EventsConfig controls how Kubernetes Events enrich and raise pod errors
+type EventsConfig struct {
+	Window   int `yaml:"window"`    // minutes an event stays relevant after it was last seen
+	MinCount int `yaml:"min_count"` // occurrences before a warning event becomes an error of its own
+}
+
+// JobsConfig controls how Jobs and CronJobs are checked
+type JobsConfig struct {
+	OverdueMultiple float64 `yaml:"overdue_multiple"` // schedule intervals without a successful run before a CronJob is overdue
+}
+
+// DetectorsConfig selects the built-in detectors that run
+type DetectorsConfig struct {
+	Disabled []string `yaml:"disabled"` // names of the detectors to skip, e.g. "events"
+}
+
+// RuleConfig is a custom detection rule. Every pod its expression is true for
+// gets an error whose type is the name of the rule.
+type RuleConfig struct {
+	Name       string   `yaml:"name"`
+	Expression string   `yaml:"expression"` // CEL over pod, e.g. pod.metadata.labels["tier"] == "critical"
+	Severity   string   `yaml:"severity"`   // info, warning or critical
+	Message    string   `yaml:"message"`    // Go template over pod, e.g. "{{.pod.metadata.name}} is not Ready"
+	Weight     *float64 `yaml:"weight"`     // score of each error, defaults to 1
+
+	// Compiled is the rule as LoadConfig compiled it
+	Compiled *rules.Rule `yaml:"-"`
+}
+
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// RuleWeight returns the score of an error raised by the custom rule name
+func (m *MonitoringConfig) RuleWeight(name string) float64 {
+	for _, rule := range m.Rules {
+		if rule.Name == name && rule.Weight != nil {
+			return *rule.Weight
+		}
+	}
+	return 1
+}
+
+const (
+	ScoreByPod      = "pod"
+	ScoreByWorkload = "workload"
+)
+
+// MetricsConfig limits the cardinality of the Prometheus metrics
+type MetricsConfig struct {
+	WorkloadLabel     bool     `yaml:"workload_label"`     // label pod error counts with the owning workload
+	ExcludeNamespaces []string `yaml:"exclude_namespaces"` // glob patterns of namespaces to leave out
+	MaxNamespaces     int      `yaml:"max_namespaces"`     // highest scoring namespaces exported per cluster, 0 for all
+}
+
+// ExportsNamespace reports whether metrics are exported for namespace
+func (m *MetricsConfig) ExportsNamespace(namespace string) bool {
+	return !MatchesAny(m.ExcludeNamespaces, namespace)
 }
 
+// NamespaceOverride replaces the global thresholds and weights for the
+// namespaces matching Namespace, which may be a glob pattern (e.g. "batch-*").
+// Unset fields inherit the global value.
+type NamespaceOverride struct {
+	Namespace            string               `yaml:"namespace"`
+	HighRestartThreshold *int                 `yaml:"high_restart_threshold"`
+	PendingTimeout       *int                 `yaml:"pending_timeout"`
+	ErrorWeights         ErrorWeightOverrides `yaml:"error_weights"`
+}
+
+type ErrorWeightOverrides struct {
+	CrashLoop         *float64 `yaml:"crash_loop"`
+	ImagePull         *float64 `yaml:"image_pull"`
+	HighRestarts      *float64 `yaml:"high_restarts"`
+	Pending           *float64 `yaml:"pending"`
+	Rollout           *float64 `yaml:"rollout"`
+	OtherErrors       *float64 `yaml:"other_errors"`
+	RestartMultiplier *float64 `yaml:"restart_multiplier"`
+}
+
+// NamespaceMonitoring holds the effective thresholds and weights for a single namespace
+type NamespaceMonitoring struct {
+	HighRestartThreshold int
+	PendingTimeout       int
+	ErrorWeights         ErrorWeights
+}
+
+// ErrorWeights are the points each error scores. A weight left out of the
+// configuration keeps its default, so setting one does not zero the others.
+// The fields mirror detect.Weights, which the errors are scored with.
 type ErrorWeights struct {
 	CrashLoop         float64 `yaml:"crash_loop"`
 	ImagePull         float64 `yaml:"image_pull"`
 	HighRestarts      float64 `yaml:"high_restarts"`
+	Pending           float64 `yaml:"pending"`
+	Rollout           float64 `yaml:"rollout"` // stuck rollouts and workloads below their desired replicas
 	OtherErrors       float64 `yaml:"other_errors"`
 	RestartMultiplier float64 `yaml:"restart_multiplier"`
 }
 
+// DefaultErrorWeights are the weights of the errors the configuration leaves out
+var DefaultErrorWeights = ErrorWeights(detect.DefaultWeights)
+
+// DefaultHighRestartThreshold applies when the configuration sets no
+// high_restart_threshold
+const DefaultHighRestartThreshold = 5
+
+// Weights returns the weights to score with
+func (w ErrorWeights) Weights() detect.Weights {
+	return detect.Weights(w)
+}
+
+// ForNamespace resolves the thresholds and weights that apply to namespace.
+// The first matching override wins.
+func (m *MonitoringConfig) ForNamespace(namespace string) NamespaceMonitoring {
+	result := NamespaceMonitoring{
+		HighRestartThreshold: DefaultHighRestartThreshold,
+		PendingTimeout:       m.PendingTimeout,
+		ErrorWeights:         m.ErrorWeights,
+	}
+	if m.HighRestartThreshold != nil {
+		result.HighRestartThreshold = *m.HighRestartThreshold
+	}
+
+	for _, override := range m.NamespaceOverrides {
+		if !override.matches(namespace) {
+			continue
+		}
+		if override.HighRestartThreshold != nil {
+			result.HighRestartThreshold = *override.HighRestartThreshold
+		}
+		if override.PendingTimeout != nil {
+			result.PendingTimeout = *override.PendingTimeout
+		}
+		w := override.ErrorWeights
+		if w.CrashLoop != nil {
+			result.ErrorWeights.CrashLoop = *w.CrashLoop
+		}
+		if w.ImagePull != nil {
+			result.ErrorWeights.ImagePull = *w.ImagePull
+		}
+		if w.HighRestarts != nil {
+			result.ErrorWeights.HighRestarts = *w.HighRestarts
+		}
+		if w.Pending != nil {
+			result.ErrorWeights.Pending = *w.Pending
+		}
+		if w.Rollout != nil {
+			result.ErrorWeights.Rollout = *w.Rollout
+		}
+		if w.OtherErrors != nil {
+			result.ErrorWeights.OtherErrors = *w.OtherErrors
+		}
+		if w.RestartMultiplier != nil {
+			result.ErrorWeights.RestartMultiplier = *w.RestartMultiplier
+		}
+		break
+	}
+
+	return result
+}
+
+func (o NamespaceOverride) matches(namespace string) bool {
+	if o.Namespace == namespace {
+		return true
+	}
+	matched, err := path.Match(o.Namespace, namespace)
+	return err == nil && matched
+}
+
 // LoadConfig loads the configuration from the specified file
 func LoadConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
@@ -51,7 +307,10 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("error reading config file: %v", err)
 	}
 
+	// Weights are defaulted one by one: the decoder keeps the value of every
+	// key the file does not set
 	config := &Config{}
+	config.Monitoring.ErrorWeights = DefaultErrorWeights
 	if err := yaml.Unmarshal(data, config); err != nil {
 		return nil, fmt.Errorf("error parsing config file: %v", err)
 	}
@@ -72,22 +331,299 @@ func LoadConfig(path string) (*Config, error) {
 	if config.Kubernetes.RefreshInterval == 0 {
 		config.Kubernetes.RefreshInterval = 5
 	}
-	if config.Monitoring.HighRestartThreshold == 0 {
-		config.Monitoring.HighRestartThreshold = 5
+	if config.History.Path == "" {
+		config.History.Path = "history.db"
 	}
-	if config.Monitoring.ErrorWeights == (ErrorWeights{}) {
-		config.Monitoring.ErrorWeights = ErrorWeights{
-			CrashLoop:         3.0,
-			ImagePull:         2.0,
-			HighRestarts:      2.0,
-			OtherErrors:       1.0,
-			RestartMultiplier: 0.1,
-		}
+	if config.History.Retention == 0 {
+		config.History.Retention = 168
+	}
+	if config.History.SnapshotInterval == 0 {
+		config.History.SnapshotInterval = 60
+	}
+	if config.History.CheckpointInterval == 0 {
+		config.History.CheckpointInterval = 60
+	}
+	if config.Monitoring.HighRestartThreshold == nil {
+		threshold := DefaultHighRestartThreshold
+		config.Monitoring.HighRestartThreshold = &threshold
+	}
+	if config.Monitoring.TerminationWindow == 0 {
+		config.Monitoring.TerminationWindow = 60
+	}
+	if config.Monitoring.PendingTimeout == 0 {
+		config.Monitoring.PendingTimeout = 10
+	}
+	if config.Monitoring.ResolveAfter == 0 {
+		config.Monitoring.ResolveAfter = 5
+	}
+	if config.Monitoring.RolloutDeadline == 0 {
+		config.Monitoring.RolloutDeadline = 10
+	}
+	if config.Monitoring.ScoreBy == "" {
+		config.Monitoring.ScoreBy = ScoreByPod
+	}
+	if config.Monitoring.Events.Window == 0 {
+		config.Monitoring.Events.Window = 30
+	}
+	if config.Monitoring.Events.MinCount == 0 {
+		config.Monitoring.Events.MinCount = 3
+	}
+	if config.Monitoring.Jobs.OverdueMultiple == 0 {
+		config.Monitoring.Jobs.OverdueMultiple = 2
+	}
+	if err := validateMonitoring(&config.Monitoring); err != nil {
+		return nil, err
+	}
+	if err := validateClusters(&config.Kubernetes); err != nil {
+		return nil, err
+	}
+	if config.Notifications.ResendInterval == 0 {
+		config.Notifications.ResendInterval = 240
+	}
+	if err := validateNotifications(&config.Notifications); err != nil {
+		return nil, err
 	}
 
 	return config, nil
 }
 
+// overrides returns the weights as overrides that set every weight
+func (w ErrorWeights) overrides() ErrorWeightOverrides {
+	return ErrorWeightOverrides{
+		CrashLoop:         &w.CrashLoop,
+		ImagePull:         &w.ImagePull,
+		HighRestarts:      &w.HighRestarts,
+		Pending:           &w.Pending,
+		Rollout:           &w.Rollout,
+		OtherErrors:       &w.OtherErrors,
+		RestartMultiplier: &w.RestartMultiplier,
+	}
+}
+
+// validateWeights rejects the negative weights set under prefix
+func validateWeights(prefix string, w ErrorWeightOverrides) error {
+	weights := []struct {
+		key   string
+		value *float64
+	}{
+		{"crash_loop", w.CrashLoop},
+		{"image_pull", w.ImagePull},
+		{"high_restarts", w.HighRestarts},
+		{"pending", w.Pending},
+		{"rollout", w.Rollout},
+		{"other_errors", w.OtherErrors},
+		{"restart_multiplier", w.RestartMultiplier},
+	}
+	for _, weight := range weights {
+		if weight.value != nil && *weight.value < 0 {
+			return fmt.Errorf("%s.error_weights.%s must not be negative", prefix, weight.key)
+		}
+	}
+	return nil
+}
+
+func validateMonitoring(m *MonitoringConfig) error {
+	for i, override := range m.NamespaceOverrides {
+		if override.Namespace == "" {
+			return fmt.Errorf("monitoring.namespace_overrides[%d]: namespace is required", i)
+		}
+		if _, err := path.Match(override.Namespace, ""); err != nil {
+			return fmt.Errorf("monitoring.namespace_overrides[%d]: invalid namespace pattern %q: %v", i, override.Namespace, err)
+		}
+		if override.HighRestartThreshold != nil && *override.HighRestartThreshold < 0 {
+			return fmt.Errorf("monitoring.namespace_overrides[%d]: high_restart_threshold must not be negative", i)
+		}
+		if override.PendingTimeout != nil && *override.PendingTimeout <= 0 {
+			return fmt.Errorf("monitoring.namespace_overrides[%d]: pending_timeout must be positive", i)
+		}
+		if err := validateWeights(fmt.Sprintf("monitoring.namespace_overrides[%d]", i), override.ErrorWeights); err != nil {
+			return err
+		}
+	}
+	if m.HighRestartThreshold != nil && *m.HighRestartThreshold < 0 {
+		return fmt.Errorf("monitoring.high_restart_threshold must not be negative")
+	}
+	if err := validateWeights("monitoring", m.ErrorWeights.overrides()); err != nil {
+		return err
+	}
+	if m.PendingTimeout < 0 {
+		return fmt.Errorf("monitoring.pending_timeout must be positive")
+	}
+	if m.ResolveAfter < 0 {
+		return fmt.Errorf("monitoring.resolve_after must be positive")
+	}
+	if m.RolloutDeadline < 0 {
+		return fmt.Errorf("monitoring.rollout_deadline must be positive")
+	}
+	for i, pattern := range m.Metrics.ExcludeNamespaces {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("monitoring.metrics.exclude_namespaces[%d]: invalid pattern %q: %v", i, pattern, err)
+		}
+	}
+	if m.ScoreBy != ScoreByPod && m.ScoreBy != ScoreByWorkload {
+		return fmt.Errorf("monitoring.score_by must be %q or %q, got %q", ScoreByPod, ScoreByWorkload, m.ScoreBy)
+	}
+	if m.Events.Window < 0 {
+		return fmt.Errorf("monitoring.events.window must be positive")
+	}
+	if m.Events.MinCount < 0 {
+		return fmt.Errorf("monitoring.events.min_count must be positive")
+	}
+	if m.Jobs.OverdueMultiple < 1 {
+		return fmt.Errorf("monitoring.jobs.overdue_multiple must be at least 1")
+	}
+	if m.Metrics.MaxNamespaces < 0 {
+		return fmt.Errorf("monitoring.metrics.max_namespaces must not be negative")
+	}
+	if err := detect.ValidateNames(m.Detectors.Disabled); err != nil {
+		return fmt.Errorf("monitoring.detectors.disabled: %v", err)
+	}
+	if err := validateRules(m.Rules); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateRules compiles every rule, so a broken expression or message fails
+// at startup rather than going unnoticed
+func validateRules(ruleConfigs []RuleConfig) error {
+	names := make(map[string]bool)
+	for i := range ruleConfigs {
+		rule := &ruleConfigs[i]
+		if rule.Name == "" {
+			return fmt.Errorf("monitoring.rules[%d]: name is required", i)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("monitoring.rules[%d]: duplicate rule name %q", i, rule.Name)
+		}
+		names[rule.Name] = true
+
+		if rule.Severity == "" {
+			rule.Severity = SeverityWarning
+		}
+		switch rule.Severity {
+		case SeverityInfo, SeverityWarning, SeverityCritical:
+		default:
+			return fmt.Errorf("monitoring.rules[%d] %q: severity must be %q, %q or %q, got %q",
+				i, rule.Name, SeverityInfo, SeverityWarning, SeverityCritical, rule.Severity)
+		}
+		if rule.Weight == nil {
+			weight := 1.0
+			rule.Weight = &weight
+		}
+		if *rule.Weight < 0 {
+			return fmt.Errorf("monitoring.rules[%d] %q: weight must not be negative", i, rule.Name)
+		}
+		if rule.Expression == "" {
+			return fmt.Errorf("monitoring.rules[%d] %q: expression is required", i, rule.Name)
+		}
+		compiled, err := rules.Compile(rule.Name, rule.Expression, rule.Severity, rule.Message)
+		if err != nil {
+			return fmt.Errorf("monitoring.rules[%d] %q: %v", i, rule.Name, err)
+		}
+		rule.Compiled = compiled
+	}
+	return nil
+}
+
+func validateClusters(k *KubernetesConfig) error {
+	if len(k.Clusters) > 0 && k.UseInCluster {
+		return fmt.Errorf("kubernetes.clusters cannot be combined with use_in_cluster")
+	}
+
+	names := make(map[string]bool)
+	for i := range k.Clusters {
+		cluster := &k.Clusters[i]
+		if cluster.KubeconfigPath == "" {
+			cluster.KubeconfigPath = k.KubeconfigPath
+		}
+		if cluster.Name == "" {
+			cluster.Name = cluster.Context
+		}
+		if cluster.Name == "" {
+			return fmt.Errorf("kubernetes.clusters[%d]: name or context is required", i)
+		}
+		if names[cluster.Name] {
+			return fmt.Errorf("kubernetes.clusters[%d]: duplicate cluster name %q", i, cluster.Name)
+		}
+		names[cluster.Name] = true
+	}
+
+	return nil
+}
+
+func validateNotifications(n *NotificationsConfig) error {
+	if n.ResendInterval < 0 {
+		return fmt.Errorf("notifications.resend_interval must not be negative")
+	}
+	if n.ScoreThreshold < 0 {
+		return fmt.Errorf("notifications.score_threshold must not be negative")
+	}
+	if err := validatePatterns("notifications.error_types", n.ErrorTypes); err != nil {
+		return err
+	}
+
+	sinks := make(map[string]bool)
+	for i := range n.Sinks {
+		sink := &n.Sinks[i]
+		if sink.Name == "" {
+			return fmt.Errorf("notifications.sinks[%d]: name is required", i)
+		}
+		if sinks[sink.Name] {
+			return fmt.Errorf("notifications.sinks[%d]: duplicate sink name %q", i, sink.Name)
+		}
+		sinks[sink.Name] = true
+
+		switch sink.Type {
+		case SinkWebhook, SinkSlack, SinkAlertmanager:
+		default:
+			return fmt.Errorf("notifications.sinks[%d]: unknown type %q", i, sink.Type)
+		}
+		if sink.URL == "" {
+			return fmt.Errorf("notifications.sinks[%d]: url is required", i)
+		}
+		if sink.Timeout == 0 {
+			sink.Timeout = 10
+		}
+	}
+	if n.Enabled && len(n.Sinks) == 0 {
+		return fmt.Errorf("notifications: at least one sink is required when enabled")
+	}
+
+	for i, route := range n.Routes {
+		field := fmt.Sprintf("notifications.routes[%d]", i)
+		if len(route.Sinks) == 0 {
+			return fmt.Errorf("%s: sinks is required", field)
+		}
+		for _, name := range route.Sinks {
+			if !sinks[name] {
+				return fmt.Errorf("%s: unknown sink %q", field, name)
+			}
+		}
+		for name, patterns := range map[string][]string{
+			"clusters":    route.Clusters,
+			"namespaces":  route.Namespaces,
+			"error_types": route.ErrorTypes,
+		} {
+			if err := validatePatterns(field+"."+name, patterns); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func validatePatterns(field string, patterns []string) error {
+	for i, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%s[%d]: invalid pattern %q: %v", field, i, pattern, err)
+		}
+	}
+	return nil
+}
+
 // GetConfigPath returns the configuration file path based on environment or default
 func GetConfigPath() string {
 	if path := os.Getenv("POD_ERROR_MONITOR_CONFIG"); path != "" {
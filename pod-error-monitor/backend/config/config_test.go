@@ -0,0 +1,192 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadTestConfig loads a configuration file with the given content
+func loadTestConfig(t *testing.T, content string) (*Config, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+	return LoadConfig(path)
+}
+
+func TestLoadConfigErrorWeights(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    ErrorWeights
+	}{
+		{
+			name:    "no monitoring section",
+			content: "server:\n  port: 8080\n",
+			want:    DefaultErrorWeights,
+		},
+		{
+			name:    "empty weights",
+			content: "monitoring:\n  error_weights:\n",
+			want:    DefaultErrorWeights,
+		},
+		{
+			name: "weights predating pending and rollout",
+			content: `monitoring:
+  error_weights:
+    crash_loop: 5
+    image_pull: 2
+    high_restarts: 2
+    other_errors: 1
+    restart_multiplier: 0.1
+`,
+			want: ErrorWeights{CrashLoop: 5, ImagePull: 2, HighRestarts: 2, Pending: 2, Rollout: 3, OtherErrors: 1, RestartMultiplier: 0.1},
+		},
+		{
+			name:    "explicit zero is kept",
+			content: "monitoring:\n  error_weights:\n    restart_multiplier: 0\n",
+			want:    ErrorWeights{CrashLoop: 3, ImagePull: 2, HighRestarts: 2, Pending: 2, Rollout: 3, OtherErrors: 1, RestartMultiplier: 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, tt.content)
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if cfg.Monitoring.ErrorWeights != tt.want {
+				t.Errorf("weights = %+v, want %+v", cfg.Monitoring.ErrorWeights, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigHighRestartThreshold(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    int
+	}{
+		{
+			name:    "default",
+			content: "monitoring:\n  pending_timeout: 10\n",
+			want:    DefaultHighRestartThreshold,
+		},
+		{
+			name:    "zero flags every restart",
+			content: "monitoring:\n  high_restart_threshold: 0\n",
+			want:    0,
+		},
+		{
+			name:    "set",
+			content: "monitoring:\n  high_restart_threshold: 20\n",
+			want:    20,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, tt.content)
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if got := cfg.Monitoring.ForNamespace("shop").HighRestartThreshold; got != tt.want {
+				t.Errorf("threshold = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigCheckSecrets(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{
+			name:    "unchecked by default",
+			content: "monitoring:\n  high_restart_threshold: 5\n",
+			want:    false,
+		},
+		{
+			name:    "turned on",
+			content: "monitoring:\n  check_secrets: true\n",
+			want:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, tt.content)
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if cfg.Monitoring.CheckSecrets != tt.want {
+				t.Errorf("check_secrets = %v, want %v", cfg.Monitoring.CheckSecrets, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "negative resolve_after",
+			content: "monitoring:\n  resolve_after: -1\n",
+			wantErr: "monitoring.resolve_after must be positive",
+		},
+		{
+			name:    "negative rollout_deadline",
+			content: "monitoring:\n  rollout_deadline: -5\n",
+			wantErr: "monitoring.rollout_deadline must be positive",
+		},
+		{
+			name:    "unknown detector",
+			content: "monitoring:\n  detectors:\n    disabled: [nope]\n",
+			wantErr: "monitoring.detectors.disabled",
+		},
+		{
+			name:    "rule named after a built-in error type",
+			content: "monitoring:\n  rules:\n    - name: OOMKilled\n      expression: \"true\"\n",
+			wantErr: `monitoring.rules[0] "OOMKilled": name collides with the built-in error type`,
+		},
+		{
+			name:    "negative high_restart_threshold",
+			content: "monitoring:\n  high_restart_threshold: -1\n",
+			wantErr: "monitoring.high_restart_threshold must not be negative",
+		},
+		{
+			name:    "negative weight",
+			content: "monitoring:\n  error_weights:\n    pending: -2\n",
+			wantErr: "monitoring.error_weights.pending must not be negative",
+		},
+		{
+			name:    "negative override weight",
+			content: "monitoring:\n  namespace_overrides:\n    - namespace: batch\n      error_weights:\n        restart_multiplier: -0.1\n",
+			wantErr: "monitoring.namespace_overrides[0].error_weights.restart_multiplier must not be negative",
+		},
+		{
+			name:    "invalid score_by",
+			content: "monitoring:\n  score_by: cluster\n",
+			wantErr: "monitoring.score_by",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, tt.content)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// secretsResource is watched as metadata only, so the cache never holds
+// secret values
+var secretsResource = v1.SchemeGroupVersion.WithResource("secrets")
+
+// errSecretsUnchecked leaves references to Secrets unchecked when Secrets
+// are not watched
+var errSecretsUnchecked = errors.New("secrets are not watched")
+
+// configRefs looks up the ConfigMaps and Secrets that pods refer to.
+// ConfigMaps are stored with their keys only and Secrets as metadata only,
+// so the keys of a Secret cannot be checked. Without a secrets lister
+// Secrets are not checked at all.
+type configRefs struct {
+	configMaps corelisters.ConfigMapLister
+	secrets    cache.GenericLister
+}
+
+// newConfigInformers sets up the ConfigMap and Secret informers of the
+// references check, calling onChange when an object appears, disappears or
+// changes its keys. Secrets are left out without a secretFactory.
+func newConfigInformers(configFactory informers.SharedInformerFactory, secretFactory metadatainformer.SharedInformerFactory,
+	onChange func()) (*configRefs, []cache.InformerSynced) {
+	configMapInformer := configFactory.Core().V1().ConfigMaps()
+
+	configMapInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { onChange() },
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldConfigMap, ok1 := oldObj.(*v1.ConfigMap)
+			newConfigMap, ok2 := newObj.(*v1.ConfigMap)
+			// Values are stripped, so equal data means equal keys
+			if ok1 && ok2 && sameKeys(oldConfigMap, newConfigMap) {
+				return
+			}
+			onChange()
+		},
+		DeleteFunc: func(obj interface{}) { onChange() },
+	})
+
+	refs := &configRefs{configMaps: configMapInformer.Lister()}
+	synced := []cache.InformerSynced{configMapInformer.Informer().HasSynced}
+	if secretFactory == nil {
+		return refs, synced
+	}
+
+	secretInformer := secretFactory.ForResource(secretsResource)
+	secretInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { onChange() },
+		DeleteFunc: func(obj interface{}) { onChange() },
+	})
+	refs.secrets = secretInformer.Lister()
+	synced = append(synced, secretInformer.Informer().HasSynced)
+	return refs, synced
+}
+
+// stripConfigMapValues keeps only the keys of ConfigMaps, which is all the
+// references check needs
+func stripConfigMapValues(obj interface{}) (interface{}, error) {
+	if configMap, ok := obj.(*v1.ConfigMap); ok {
+		configMap.ManagedFields = nil
+		for key := range configMap.Data {
+			configMap.Data[key] = ""
+		}
+		for key := range configMap.BinaryData {
+			configMap.BinaryData[key] = nil
+		}
+	}
+	return obj, nil
+}
+
+func sameKeys(a, b *v1.ConfigMap) bool {
+	if len(a.Data) != len(b.Data) || len(a.BinaryData) != len(b.BinaryData) {
+		return false
+	}
+	for key := range a.Data {
+		if _, ok := b.Data[key]; !ok {
+			return false
+		}
+	}
+	for key := range a.BinaryData {
+		if _, ok := b.BinaryData[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// ConfigMap returns a ConfigMap from the cache
+func (r *configRefs) ConfigMap(namespace, name string) (*v1.ConfigMap, error) {
+	return r.configMaps.ConfigMaps(namespace).Get(name)
+}
+
+// Secret checks that the cache holds the metadata of a Secret
+func (r *configRefs) Secret(namespace, name string) error {
+	if r.secrets == nil {
+		return errSecretsUnchecked
+	}
+	_, err := r.secrets.ByNamespace(namespace).Get(name)
+	return err
+}
@@ -0,0 +1,201 @@
+package detect
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// ConfigLookup finds the ConfigMaps and Secrets pods refer to. Both return
+// an error satisfying apierrors.IsNotFound for an object that does not
+// exist; other errors leave the reference unchecked.
+type ConfigLookup interface {
+	// ConfigMap returns a ConfigMap, of which only the keys are used
+	ConfigMap(namespace, name string) (*v1.ConfigMap, error)
+	// Secret checks that a Secret exists. Its keys are never looked at, so
+	// it may be read as metadata only.
+	Secret(namespace, name string) error
+}
+
+// configReference is a ConfigMap or Secret, or a key of one, that a pod
+// refers to
+type configReference struct {
+	kind      string
+	name      string
+	key       string
+	container string
+	// source says where the pod refers to it, e.g. "env DB_URL"
+	source   string
+	optional bool
+}
+
+// configRefsDetector reports the ConfigMaps, Secrets and ConfigMap keys a
+// pod refers to that do not exist
+type configRefsDetector struct{}
+
+func (configRefsDetector) Name() string { return "config-refs" }
+
+// Detect groups missing references of the same kind per container, with pod
+// volumes under no container
+func (configRefsDetector) Detect(in *Input) []Finding {
+	pod := in.Pod
+	if in.Configs == nil || !needsConfigCheck(pod) {
+		return nil
+	}
+
+	type group struct {
+		container string
+		errorType string
+	}
+	missing := make(map[group][]string)
+	seen := make(map[string]bool)
+	for _, ref := range podConfigReferences(pod) {
+		if ref.optional {
+			continue
+		}
+		errorType, message, ok := checkReference(in.Configs, pod.Namespace, ref)
+		// The items of a volume repeat the object they come from
+		if !ok || seen[ref.container+"/"+message] {
+			continue
+		}
+		seen[ref.container+"/"+message] = true
+		g := group{container: ref.container, errorType: errorType}
+		missing[g] = append(missing[g], message)
+	}
+
+	findings := make([]Finding, 0, len(missing))
+	for g, messages := range missing {
+		findings = append(findings, Finding{
+			Namespace:     pod.Namespace,
+			PodName:       pod.Name,
+			ErrorType:     g.errorType,
+			ErrorMessage:  strings.Join(messages, "; "),
+			ContainerName: g.container,
+			ContainerKind: ContainerKind(pod, g.container),
+			Owner:         in.Workloads.PodOwner(pod),
+		})
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].ContainerName != findings[j].ContainerName {
+			return findings[i].ContainerName < findings[j].ContainerName
+		}
+		return findings[i].ErrorType < findings[j].ErrorType
+	})
+	return findings
+}
+
+// needsConfigCheck reports whether the references of a pod may explain its
+// state: it is still Pending, or a container cannot be configured
+func needsConfigCheck(pod *v1.Pod) bool {
+	if pod.Status.Phase == v1.PodPending {
+		return true
+	}
+	for _, container := range ContainerStatuses(pod) {
+		if waiting := container.Status.State.Waiting; waiting != nil && waiting.Reason == "CreateContainerConfigError" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkReference returns the error of a reference that cannot be resolved.
+// Lookup failures other than a missing object are not reported.
+func checkReference(configs ConfigLookup, namespace string, ref configReference) (errorType, message string, ok bool) {
+	switch ref.kind {
+	case "ConfigMap":
+		configMap, err := configs.ConfigMap(namespace, ref.name)
+		if apierrors.IsNotFound(err) {
+			return "MissingConfigMap", fmt.Sprintf("ConfigMap %q not found (%s)", ref.name, ref.source), true
+		}
+		if err != nil || ref.key == "" {
+			return "", "", false
+		}
+		if _, found := configMap.Data[ref.key]; found {
+			return "", "", false
+		}
+		if _, found := configMap.BinaryData[ref.key]; found {
+			return "", "", false
+		}
+		return "MissingConfigMapKey", fmt.Sprintf("Key %q not found in ConfigMap %q (%s)", ref.key, ref.name, ref.source), true
+	case "Secret":
+		if err := configs.Secret(namespace, ref.name); apierrors.IsNotFound(err) {
+			return "MissingSecret", fmt.Sprintf("Secret %q not found (%s)", ref.name, ref.source), true
+		}
+	}
+	return "", "", false
+}
+
+// podConfigReferences returns the ConfigMaps and Secrets referenced by the
+// envFrom and env of every container and by the volumes of a pod
+func podConfigReferences(pod *v1.Pod) []configReference {
+	var refs []configReference
+	addContainer := func(name string, envFrom []v1.EnvFromSource, env []v1.EnvVar) {
+		for _, source := range envFrom {
+			if ref := source.ConfigMapRef; ref != nil {
+				refs = append(refs, configReference{kind: "ConfigMap", name: ref.Name, container: name,
+					source: "envFrom", optional: isOptional(ref.Optional)})
+			}
+			if ref := source.SecretRef; ref != nil {
+				refs = append(refs, configReference{kind: "Secret", name: ref.Name, container: name,
+					source: "envFrom", optional: isOptional(ref.Optional)})
+			}
+		}
+		for _, variable := range env {
+			if variable.ValueFrom == nil {
+				continue
+			}
+			if ref := variable.ValueFrom.ConfigMapKeyRef; ref != nil {
+				refs = append(refs, configReference{kind: "ConfigMap", name: ref.Name, key: ref.Key, container: name,
+					source: "env " + variable.Name, optional: isOptional(ref.Optional)})
+			}
+			if ref := variable.ValueFrom.SecretKeyRef; ref != nil {
+				refs = append(refs, configReference{kind: "Secret", name: ref.Name, key: ref.Key, container: name,
+					source: "env " + variable.Name, optional: isOptional(ref.Optional)})
+			}
+		}
+	}
+	for _, container := range pod.Spec.InitContainers {
+		addContainer(container.Name, container.EnvFrom, container.Env)
+	}
+	for _, container := range pod.Spec.Containers {
+		addContainer(container.Name, container.EnvFrom, container.Env)
+	}
+	for _, container := range pod.Spec.EphemeralContainers {
+		addContainer(container.Name, container.EnvFrom, container.Env)
+	}
+
+	addItems := func(kind, name, volume string, items []v1.KeyToPath, optional *bool) {
+		source := "volume " + volume
+		refs = append(refs, configReference{kind: kind, name: name, source: source, optional: isOptional(optional)})
+		for _, item := range items {
+			refs = append(refs, configReference{kind: kind, name: name, key: item.Key, source: source, optional: isOptional(optional)})
+		}
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if source := volume.ConfigMap; source != nil {
+			addItems("ConfigMap", source.Name, volume.Name, source.Items, source.Optional)
+		}
+		if source := volume.Secret; source != nil {
+			addItems("Secret", source.SecretName, volume.Name, source.Items, source.Optional)
+		}
+		if volume.Projected == nil {
+			continue
+		}
+		for _, projection := range volume.Projected.Sources {
+			if source := projection.ConfigMap; source != nil {
+				addItems("ConfigMap", source.Name, volume.Name, source.Items, source.Optional)
+			}
+			if source := projection.Secret; source != nil {
+				addItems("Secret", source.Name, volume.Name, source.Items, source.Optional)
+			}
+		}
+	}
+	return refs
+}
+
+func isOptional(optional *bool) bool {
+	return optional != nil && *optional
+}
@@ -0,0 +1,149 @@
+package detect
+
+import (
+	"errors"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// testConfigs holds the ConfigMaps and Secrets of the shop namespace. A
+// nil secrets map cannot be read.
+type testConfigs struct {
+	configMaps map[string]*v1.ConfigMap
+	secrets    map[string]bool
+}
+
+func (c testConfigs) ConfigMap(namespace, name string) (*v1.ConfigMap, error) {
+	if configMap, ok := c.configMaps[name]; ok && namespace == "shop" {
+		return configMap, nil
+	}
+	return nil, apierrors.NewNotFound(v1.Resource("configmaps"), name)
+}
+
+func (c testConfigs) Secret(namespace, name string) error {
+	if c.secrets == nil {
+		return errors.New("secrets are not watched")
+	}
+	if c.secrets[name] && namespace == "shop" {
+		return nil
+	}
+	return apierrors.NewNotFound(v1.Resource("secrets"), name)
+}
+
+// configPod returns a Pending pod of the shop namespace running app with env
+// and volumes
+func configPod(env []v1.EnvVar, envFrom []v1.EnvFromSource, volumes []v1.Volume) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "api-1"},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "app", Env: env, EnvFrom: envFrom}},
+			Volumes:    volumes,
+		},
+		Status: v1.PodStatus{Phase: v1.PodPending},
+	}
+}
+
+func configMapKeyEnv(name, configMap, key string, optional *bool) v1.EnvVar {
+	return v1.EnvVar{Name: name, ValueFrom: &v1.EnvVarSource{ConfigMapKeyRef: &v1.ConfigMapKeySelector{
+		LocalObjectReference: v1.LocalObjectReference{Name: configMap}, Key: key, Optional: optional,
+	}}}
+}
+
+func TestConfigRefsDetector(t *testing.T) {
+	configs := testConfigs{
+		configMaps: map[string]*v1.ConfigMap{
+			"settings": {Data: map[string]string{"log_level": "info"}, BinaryData: map[string][]byte{"ca.crt": nil}},
+		},
+		secrets: map[string]bool{"db": true},
+	}
+	secretEnv := []v1.EnvFromSource{{SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "api-keys"}}}}
+	running := configPod(nil, secretEnv, nil)
+	running.Status.Phase = v1.PodRunning
+
+	type finding struct {
+		container string
+		errorType string
+		message   string
+	}
+	tests := []struct {
+		name    string
+		pod     *v1.Pod
+		configs ConfigLookup
+		want    []finding
+	}{
+		{
+			name: "all references resolve",
+			pod: configPod([]v1.EnvVar{
+				configMapKeyEnv("LOG_LEVEL", "settings", "log_level", nil),
+				configMapKeyEnv("CA", "settings", "ca.crt", nil),
+			}, nil, []v1.Volume{{Name: "db", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "db"}}}}),
+			configs: configs,
+		},
+		{
+			name: "missing keys of one container are grouped",
+			pod: configPod([]v1.EnvVar{
+				configMapKeyEnv("LOG_LEVEL", "settings", "level", nil),
+				configMapKeyEnv("REGION", "settings", "region", nil),
+			}, nil, nil),
+			configs: configs,
+			want: []finding{{
+				container: "app",
+				errorType: "MissingConfigMapKey",
+				message:   `Key "level" not found in ConfigMap "settings" (env LOG_LEVEL); Key "region" not found in ConfigMap "settings" (env REGION)`,
+			}},
+		},
+		{
+			name:    "optional reference",
+			pod:     configPod([]v1.EnvVar{configMapKeyEnv("FEATURES", "features", "flags", boolPtr(true))}, nil, nil),
+			configs: configs,
+		},
+		{
+			name:    "missing Secret",
+			pod:     configPod(nil, secretEnv, nil),
+			configs: configs,
+			want:    []finding{{container: "app", errorType: "MissingSecret", message: `Secret "api-keys" not found (envFrom)`}},
+		},
+		{
+			name:    "Secrets not watched",
+			pod:     configPod(nil, secretEnv, nil),
+			configs: testConfigs{},
+		},
+		{
+			name: "volume items repeat their ConfigMap",
+			pod: configPod(nil, nil, []v1.Volume{{Name: "config", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{
+				LocalObjectReference: v1.LocalObjectReference{Name: "nginx"},
+				Items:                []v1.KeyToPath{{Key: "nginx.conf", Path: "nginx.conf"}, {Key: "mime.types", Path: "mime.types"}},
+			}}}}),
+			configs: configs,
+			want:    []finding{{errorType: "MissingConfigMap", message: `ConfigMap "nginx" not found (volume config)`}},
+		},
+		{
+			name:    "running pod",
+			pod:     running,
+			configs: configs,
+		},
+		{
+			name:    "references are not looked up without a lookup",
+			pod:     configPod(nil, secretEnv, nil),
+			configs: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings := configRefsDetector{}.Detect(&Input{Pod: tt.pod, Configs: tt.configs})
+			if len(findings) != len(tt.want) {
+				t.Fatalf("findings = %+v, want %+v", findings, tt.want)
+			}
+			for i, f := range findings {
+				got := finding{container: f.ContainerName, errorType: f.ErrorType, message: f.ErrorMessage}
+				if got != tt.want[i] {
+					t.Errorf("finding %d = %+v, want %+v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package detect
+
+import (
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Kinds of containers
+const (
+	ContainerKindRegular   = "container"
+	ContainerKindInit      = "init"
+	ContainerKindSidecar   = "sidecar"
+	ContainerKindEphemeral = "ephemeral"
+)
+
+// errorStates are the waiting reasons that are errors
+var errorStates = map[string]bool{
+	"ImagePullBackOff":     true,
+	"CrashLoopBackOff":     true,
+	"ErrImagePull":         true,
+	"CreateContainerError": true,
+	"InvalidImageName":     true,
+	"ImageInspectError":    true,
+	"ErrImageNeverPull":    true,
+	// A missing ConfigMap or Secret, or a failing command or hook
+	"CreateContainerConfigError": true,
+	"RunContainerError":          true,
+	"PostStartHookError":         true,
+	"PreStopHookError":           true,
+}
+
+// IsErrorState reports whether a waiting reason is an error
+func IsErrorState(reason string) bool {
+	return errorStates[reason]
+}
+
+// Container is the status of a container and the kind of the container
+type Container struct {
+	Status v1.ContainerStatus
+	Kind   string
+}
+
+// LongRunning reports whether the container runs for the lifetime of the
+// pod. Restarts of run-to-completion containers are retries that already
+// surface as CrashLoopBackOff, and ephemeral containers never restart.
+func (c Container) LongRunning() bool {
+	return c.Kind == ContainerKindRegular || c.Kind == ContainerKindSidecar
+}
+
+// ContainerStatuses returns the statuses of the init, regular and ephemeral
+// containers of a pod. Init containers that always restart are native
+// sidecars.
+func ContainerStatuses(pod *v1.Pod) []Container {
+	sidecars := make(map[string]bool)
+	for _, container := range pod.Spec.InitContainers {
+		if container.RestartPolicy != nil && *container.RestartPolicy == v1.ContainerRestartPolicyAlways {
+			sidecars[container.Name] = true
+		}
+	}
+
+	statuses := make([]Container, 0, len(pod.Status.InitContainerStatuses)+
+		len(pod.Status.ContainerStatuses)+len(pod.Status.EphemeralContainerStatuses))
+	for _, status := range pod.Status.InitContainerStatuses {
+		kind := ContainerKindInit
+		if sidecars[status.Name] {
+			kind = ContainerKindSidecar
+		}
+		statuses = append(statuses, Container{Status: status, Kind: kind})
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		statuses = append(statuses, Container{Status: status, Kind: ContainerKindRegular})
+	}
+	for _, status := range pod.Status.EphemeralContainerStatuses {
+		statuses = append(statuses, Container{Status: status, Kind: ContainerKindEphemeral})
+	}
+	return statuses
+}
+
+// ContainerKind returns the kind of the named container of pod, taken from
+// the spec so that containers without a status yet are known too
+func ContainerKind(pod *v1.Pod, name string) string {
+	if name == "" {
+		return ""
+	}
+	for _, container := range pod.Spec.InitContainers {
+		if container.Name != name {
+			continue
+		}
+		if container.RestartPolicy != nil && *container.RestartPolicy == v1.ContainerRestartPolicyAlways {
+			return ContainerKindSidecar
+		}
+		return ContainerKindInit
+	}
+	for _, container := range pod.Spec.EphemeralContainers {
+		if container.Name == name {
+			return ContainerKindEphemeral
+		}
+	}
+	return ContainerKindRegular
+}
+
+// containerFinding is an error of a container of the pod of in
+func containerFinding(in *Input, container Container, errorType, message string) Finding {
+	pod := in.Pod
+	return Finding{
+		Namespace:     pod.Namespace,
+		PodName:       pod.Name,
+		ErrorType:     errorType,
+		ErrorMessage:  message,
+		ContainerName: container.Status.Name,
+		ContainerKind: container.Kind,
+		RestartCount:  container.Status.RestartCount,
+		Owner:         in.Workloads.PodOwner(pod),
+	}
+}
+
+// restartsDetector reports long running containers that restarted more
+// often than the restart threshold
+type restartsDetector struct{}
+
+func (restartsDetector) Name() string { return "restarts" }
+
+func (restartsDetector) Detect(in *Input) []Finding {
+	var findings []Finding
+	for _, container := range ContainerStatuses(in.Pod) {
+		if container.LongRunning() && container.Status.RestartCount > in.Settings.RestartThreshold {
+			findings = append(findings, containerFinding(in, container, "HighRestartCount",
+				"Container has restarted multiple times"))
+		}
+	}
+	return findings
+}
+
+// waitingDetector reports containers waiting in an error state, such as a
+// crash loop or an image that cannot be pulled
+type waitingDetector struct{}
+
+func (waitingDetector) Name() string { return "container-waiting" }
+
+func (waitingDetector) Detect(in *Input) []Finding {
+	var findings []Finding
+	for _, container := range ContainerStatuses(in.Pod) {
+		waiting := container.Status.State.Waiting
+		if waiting == nil || !IsErrorState(waiting.Reason) {
+			continue
+		}
+
+		e := containerFinding(in, container, waiting.Reason, waiting.Message)
+		// A crash loop carries the termination that caused it
+		if t, ok := containerTermination(container, in.Settings.TerminationWindow, in.Now); ok {
+			if e.ErrorMessage != "" {
+				e.ErrorMessage += ". "
+			}
+			e.ErrorMessage += "Last exit: " + t.message
+			e.ExitCode, e.Signal, e.FinishedAt = t.exitCode, t.signal, t.finishedAt
+		}
+		findings = append(findings, e)
+	}
+	return findings
+}
+
+// exitDetector reports containers that were OOM killed, exited with a
+// non-zero code or could not be run. The termination of a container waiting
+// in an error state explains that error instead of counting on its own, and
+// the failed attempts of a Job are reported on the Job.
+type exitDetector struct{}
+
+func (exitDetector) Name() string { return "container-exit" }
+
+func (exitDetector) Detect(in *Input) []Finding {
+	if in.Workloads.ControlsPod(in.Pod) {
+		return nil
+	}
+
+	var findings []Finding
+	for _, container := range ContainerStatuses(in.Pod) {
+		if waiting := container.Status.State.Waiting; waiting != nil && IsErrorState(waiting.Reason) {
+			continue
+		}
+		t, ok := containerTermination(container, in.Settings.TerminationWindow, in.Now)
+		if !ok {
+			continue
+		}
+
+		e := containerFinding(in, container, t.errorType, t.message)
+		e.ExitCode, e.Signal, e.FinishedAt = t.exitCode, t.signal, t.finishedAt
+		findings = append(findings, e)
+	}
+	return findings
+}
+
+// exitCodeExplanations describes the exit codes that usually point at the
+// cause of a crash
+var exitCodeExplanations = map[int32]string{
+	1:   "application error",
+	126: "command cannot be invoked, check the permissions of the entrypoint",
+	127: "command not found, check the command and entrypoint of the image",
+	137: "killed by SIGKILL, usually the OOM killer or a probe failure past the grace period",
+	139: "segmentation fault (SIGSEGV)",
+	143: "terminated by SIGTERM, usually a failed liveness probe or a shutdown",
+}
+
+// signalNames names the signals a container is commonly killed with
+var signalNames = map[int32]string{
+	1:  "SIGHUP",
+	2:  "SIGINT",
+	6:  "SIGABRT",
+	9:  "SIGKILL",
+	11: "SIGSEGV",
+	15: "SIGTERM",
+}
+
+// termination is an abnormal exit of a container
+type termination struct {
+	errorType  string
+	message    string
+	exitCode   int32
+	signal     string
+	finishedAt string
+}
+
+// containerTermination analyzes the current and the last termination of a
+// container. A current termination is always reported; the last one only
+// when it finished within window, so a single crash long ago does not stay
+// flagged while the container runs fine. Ephemeral debug containers exit
+// however the user leaves them and are never reported.
+func containerTermination(container Container, window time.Duration, now time.Time) (termination, bool) {
+	if container.Kind == ContainerKindEphemeral {
+		return termination{}, false
+	}
+
+	status := container.Status
+	if terminated := status.State.Terminated; terminated != nil {
+		return analyzeTermination(terminated)
+	}
+
+	terminated := status.LastTerminationState.Terminated
+	if terminated == nil || now.Sub(terminated.FinishedAt.Time) > window {
+		return termination{}, false
+	}
+	return analyzeTermination(terminated)
+}
+
+func analyzeTermination(terminated *v1.ContainerStateTerminated) (termination, bool) {
+	t := termination{
+		exitCode: terminated.ExitCode,
+		signal:   signalName(terminated),
+	}
+	if !terminated.FinishedAt.IsZero() {
+		t.finishedAt = terminated.FinishedAt.UTC().Format(time.RFC3339)
+	}
+
+	switch {
+	case terminated.Reason == "OOMKilled":
+		t.errorType = "OOMKilled"
+		t.message = "Container was killed for exceeding its memory limit"
+	case terminated.Reason == "ContainerCannotRun":
+		t.errorType = "ContainerCannotRun"
+		t.message = terminated.Message
+	case terminated.ExitCode != 0:
+		t.errorType = "Error"
+		t.message = fmt.Sprintf("Container exited with code %d", terminated.ExitCode)
+		if explanation, ok := exitCodeExplanations[terminated.ExitCode]; ok {
+			t.message += ": " + explanation
+		}
+		if terminated.Message != "" {
+			t.message += ". " + terminated.Message
+		}
+	default:
+		return termination{}, false
+	}
+	return t, true
+}
+
+// signalName returns the signal that killed the container, taken from the
+// status or derived from an exit code above 128
+func signalName(terminated *v1.ContainerStateTerminated) string {
+	signal := terminated.Signal
+	if signal == 0 && terminated.ExitCode > 128 {
+		signal = terminated.ExitCode - 128
+	}
+	if signal == 0 {
+		return ""
+	}
+	if name, ok := signalNames[signal]; ok {
+		return name
+	}
+	return fmt.Sprintf("signal %d", signal)
+}
@@ -0,0 +1,160 @@
+package detect
+
+import (
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// containerPod returns a pod of the shop namespace with a single container
+func containerPod(status v1.ContainerStatus, owners []metav1.OwnerReference) *v1.Pod {
+	status.Name = "app"
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "api-1", OwnerReferences: owners},
+		Status:     v1.PodStatus{ContainerStatuses: []v1.ContainerStatus{status}},
+	}
+}
+
+func terminatedAt(reason string, exitCode int32, finishedAt time.Time) *v1.ContainerStateTerminated {
+	return &v1.ContainerStateTerminated{Reason: reason, ExitCode: exitCode, FinishedAt: metav1.NewTime(finishedAt)}
+}
+
+func TestExitDetector(t *testing.T) {
+	jobs := []*batchv1.Job{{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "migrate"}}}
+	workloads := NewWorkloads(jobs, nil, nil, nil, nil, nil)
+	failed := v1.ContainerState{Terminated: terminatedAt("Error", 1, testNow.Add(-time.Minute))}
+
+	tests := []struct {
+		name         string
+		status       v1.ContainerStatus
+		owners       []metav1.OwnerReference
+		wantType     string
+		wantExitCode int32
+		wantSignal   string
+	}{
+		{
+			name:         "bare pod exited with an error",
+			status:       v1.ContainerStatus{State: failed},
+			wantType:     "Error",
+			wantExitCode: 1,
+		},
+		{
+			name:   "attempt of a known Job",
+			status: v1.ContainerStatus{State: failed},
+			owners: controlledBy("Job", "migrate"),
+		},
+		{
+			name:         "pod of an unknown Job",
+			status:       v1.ContainerStatus{State: failed},
+			owners:       controlledBy("Job", "backfill"),
+			wantType:     "Error",
+			wantExitCode: 1,
+		},
+		{
+			name: "recent OOM kill of a running container",
+			status: v1.ContainerStatus{
+				State:                v1.ContainerState{Running: &v1.ContainerStateRunning{}},
+				LastTerminationState: v1.ContainerState{Terminated: terminatedAt("OOMKilled", 137, testNow.Add(-10*time.Minute))},
+			},
+			owners:       controlledBy("ReplicaSet", "api-6d9f"),
+			wantType:     "OOMKilled",
+			wantExitCode: 137,
+			wantSignal:   "SIGKILL",
+		},
+		{
+			name: "crash outside the termination window",
+			status: v1.ContainerStatus{
+				State:                v1.ContainerState{Running: &v1.ContainerStateRunning{}},
+				LastTerminationState: v1.ContainerState{Terminated: terminatedAt("Error", 1, testNow.Add(-2*time.Hour))},
+			},
+		},
+		{
+			name: "crash explaining a crash loop",
+			status: v1.ContainerStatus{
+				State:                v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+				LastTerminationState: failed,
+			},
+		},
+		{
+			name:   "completed",
+			status: v1.ContainerStatus{State: v1.ContainerState{Terminated: terminatedAt("Completed", 0, testNow)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := &Input{
+				Pod:       containerPod(tt.status, tt.owners),
+				Workloads: workloads,
+				Settings:  DefaultSettings,
+				Now:       testNow,
+			}
+			findings := exitDetector{}.Detect(in)
+			if tt.wantType == "" {
+				if len(findings) != 0 {
+					t.Errorf("findings = %+v, want none", findings)
+				}
+				return
+			}
+			if len(findings) != 1 {
+				t.Fatalf("findings = %+v, want one %s", findings, tt.wantType)
+			}
+			f := findings[0]
+			if f.ErrorType != tt.wantType || f.ExitCode != tt.wantExitCode || f.Signal != tt.wantSignal {
+				t.Errorf("finding = %s exit %d %q, want %s exit %d %q",
+					f.ErrorType, f.ExitCode, f.Signal, tt.wantType, tt.wantExitCode, tt.wantSignal)
+			}
+		})
+	}
+}
+
+func TestWaitingDetector(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      v1.ContainerStatus
+		wantType    string
+		wantMessage string
+	}{
+		{
+			name: "crash loop carries its last exit",
+			status: v1.ContainerStatus{
+				State:                v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff", Message: "back-off 5m0s"}},
+				LastTerminationState: v1.ContainerState{Terminated: terminatedAt("Error", 127, testNow.Add(-time.Minute))},
+			},
+			wantType:    "CrashLoopBackOff",
+			wantMessage: "back-off 5m0s. Last exit: Container exited with code 127: command not found, check the command and entrypoint of the image",
+		},
+		{
+			name:        "missing ConfigMap",
+			status:      v1.ContainerStatus{State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "CreateContainerConfigError", Message: `configmap "settings" not found`}}},
+			wantType:    "CreateContainerConfigError",
+			wantMessage: `configmap "settings" not found`,
+		},
+		{
+			name:   "still creating",
+			status: v1.ContainerStatus{State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ContainerCreating"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := &Input{Pod: containerPod(tt.status, nil), Settings: DefaultSettings, Now: testNow}
+			findings := waitingDetector{}.Detect(in)
+			if tt.wantType == "" {
+				if len(findings) != 0 {
+					t.Errorf("findings = %+v, want none", findings)
+				}
+				return
+			}
+			if len(findings) != 1 {
+				t.Fatalf("findings = %+v, want one %s", findings, tt.wantType)
+			}
+			if f := findings[0]; f.ErrorType != tt.wantType || f.ErrorMessage != tt.wantMessage {
+				t.Errorf("finding = %s %q, want %s %q", f.ErrorType, f.ErrorMessage, tt.wantType, tt.wantMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+// Package detect finds the errors of pods and workloads. The backend and the
+// CLI run the same detectors, so both report the same findings.
+package detect
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Finding is an error found by a detector. Its JSON is the pod error of the
+// backend API and of the json and yaml outputs of the CLI.
+type Finding struct {
+	Cluster       string `json:"cluster"`
+	Namespace     string `json:"namespace"`
+	PodName       string `json:"podName"`
+	ErrorType     string `json:"errorType"`
+	ErrorMessage  string `json:"errorMessage"`
+	ContainerName string `json:"containerName"`
+	ContainerKind string `json:"containerKind,omitempty"`
+	RestartCount  int32  `json:"restartCount"`
+	Owner         string `json:"owner"`
+	ExitCode      int32  `json:"exitCode,omitempty"`
+	Signal        string `json:"signal,omitempty"`
+	FinishedAt    string `json:"finishedAt,omitempty"`
+	// Events are the recent warnings about the pod or the container
+	Events []PodEvent `json:"events,omitempty"`
+	// Replicas is set on the rollout errors of Deployments and StatefulSets
+	Replicas *ReplicaStatus `json:"replicas,omitempty"`
+	// Rule is the custom rule that raised the error, empty for the errors
+	// of the built-in detectors
+	Rule string `json:"rule,omitempty"`
+	// Severity is set on the errors of custom rules only
+	Severity string `json:"severity,omitempty"`
+}
+
+// Settings tune the built-in detectors
+type Settings struct {
+	// RestartThreshold is how often a container may restart before it is
+	// flagged
+	RestartThreshold int32
+	// PendingTimeout is how long a pod may stay Pending
+	PendingTimeout time.Duration
+	// TerminationWindow is how long the crash of a container that has
+	// since restarted stays flagged
+	TerminationWindow time.Duration
+	// EventMinCount is how often a warning must repeat before it becomes
+	// an error of its own
+	EventMinCount int32
+	// OverdueMultiple is how many schedule intervals a CronJob may go
+	// without a successful run
+	OverdueMultiple float64
+	// RolloutDeadline is how long a StatefulSet may run below its desired
+	// replicas, the counterpart of the progress deadline of a Deployment
+	RolloutDeadline time.Duration
+}
+
+// DefaultSettings match the defaults of the backend configuration
+var DefaultSettings = Settings{
+	RestartThreshold:  5,
+	PendingTimeout:    10 * time.Minute,
+	TerminationWindow: time.Hour,
+	EventMinCount:     3,
+	OverdueMultiple:   2,
+	RolloutDeadline:   10 * time.Minute,
+}
+
+// Input is a pod and the objects related to it
+type Input struct {
+	Pod *v1.Pod
+	// Events are the recent tracked warnings about the pod, newest first
+	Events []Event
+	// Configs looks up the ConfigMaps and Secrets the pod refers to. Without
+	// it references are not checked.
+	Configs ConfigLookup
+	// Workloads are the workloads of the pod's namespace, if known
+	Workloads *Workloads
+	Settings  Settings
+	Now       time.Time
+
+	object map[string]interface{}
+}
+
+// Object returns the pod as its JSON fields, converted once per input
+func (in *Input) Object() (map[string]interface{}, error) {
+	if in.object != nil {
+		return in.object, nil
+	}
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(in.Pod)
+	if err != nil {
+		return nil, err
+	}
+	in.object = object
+	return object, nil
+}
+
+// Detector finds the errors of a pod
+type Detector interface {
+	// Name identifies the detector in the configuration
+	Name() string
+	Detect(in *Input) []Finding
+}
+
+// WorkloadDetector finds the errors of workloads themselves, which are not
+// about a pod
+type WorkloadDetector interface {
+	Name() string
+	DetectWorkloads(workloads *Workloads, settings Settings, now time.Time) []Finding
+}
+
+// builtinDetectors and builtinWorkloadDetectors run in this order, so the
+// errors of a pod keep the same order between runs
+var (
+	builtinDetectors = []Detector{
+		podFailedDetector{},
+		pendingDetector{},
+		restartsDetector{},
+		waitingDetector{},
+		exitDetector{},
+		configRefsDetector{},
+		eventsDetector{},
+	}
+	builtinWorkloadDetectors = []WorkloadDetector{
+		jobsDetector{},
+		rolloutsDetector{},
+	}
+)
+
+// Names returns the names of the built-in detectors
+func Names() []string {
+	names := make([]string, 0, len(builtinDetectors)+len(builtinWorkloadDetectors))
+	for _, d := range builtinDetectors {
+		names = append(names, d.Name())
+	}
+	for _, d := range builtinWorkloadDetectors {
+		names = append(names, d.Name())
+	}
+	return names
+}
+
+// ValidateNames checks that names are all built-in detectors
+func ValidateNames(names []string) error {
+	known := make(map[string]bool)
+	for _, name := range Names() {
+		known[name] = true
+	}
+	for _, name := range names {
+		if !known[name] {
+			return fmt.Errorf("unknown detector %q, expected one of %s", name, strings.Join(Names(), ", "))
+		}
+	}
+	return nil
+}
+
+// Registry holds the detectors to run
+type Registry struct {
+	detectors         []Detector
+	workloadDetectors []WorkloadDetector
+}
+
+// NewRegistry returns a registry of the built-in detectors except those
+// named in disabled
+func NewRegistry(disabled []string) (*Registry, error) {
+	if err := ValidateNames(disabled); err != nil {
+		return nil, err
+	}
+	skip := make(map[string]bool, len(disabled))
+	for _, name := range disabled {
+		skip[name] = true
+	}
+
+	r := &Registry{}
+	for _, d := range builtinDetectors {
+		if !skip[d.Name()] {
+			r.Register(d)
+		}
+	}
+	for _, d := range builtinWorkloadDetectors {
+		if !skip[d.Name()] {
+			r.RegisterWorkloads(d)
+		}
+	}
+	return r, nil
+}
+
+// Register adds a pod detector, which runs after the ones before it
+func (r *Registry) Register(d Detector) {
+	r.detectors = append(r.detectors, d)
+}
+
+// RegisterWorkloads adds a workload detector
+func (r *Registry) RegisterWorkloads(d WorkloadDetector) {
+	r.workloadDetectors = append(r.workloadDetectors, d)
+}
+
+// Enabled returns the names of the registered detectors, sorted
+func (r *Registry) Enabled() []string {
+	var names []string
+	for _, d := range r.detectors {
+		names = append(names, d.Name())
+	}
+	for _, d := range r.workloadDetectors {
+		names = append(names, d.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Pod runs the pod detectors. Findings that do not bring their own events
+// carry the events of their pod and container.
+func (r *Registry) Pod(in *Input) []Finding {
+	var findings []Finding
+	for _, d := range r.detectors {
+		findings = append(findings, d.Detect(in)...)
+	}
+	for i := range findings {
+		if findings[i].Events == nil {
+			findings[i].Events = eventsFor(in.Events, findings[i].ContainerName)
+		}
+	}
+	return findings
+}
+
+// Workloads runs the workload detectors. A nil workloads has no findings.
+func (r *Registry) Workloads(workloads *Workloads, settings Settings, now time.Time) []Finding {
+	if workloads == nil {
+		return nil
+	}
+	var findings []Finding
+	for _, d := range r.workloadDetectors {
+		findings = append(findings, d.DetectWorkloads(workloads, settings, now)...)
+	}
+	return findings
+}
+
+// builtinErrorTypes are the error types the built-in detectors raise
+// besides the waiting reasons of errorStates
+var builtinErrorTypes = map[string]bool{
+	"HighRestartCount":         true,
+	"OOMKilled":                true,
+	"ContainerCannotRun":       true,
+	"Error":                    true,
+	"PodFailed":                true,
+	"Unschedulable":            true,
+	"StuckPending":             true,
+	"JobFailed":                true,
+	"BackoffLimitExceeded":     true,
+	"DeadlineExceeded":         true,
+	"CronJobSuspended":         true,
+	"CronJobOverdue":           true,
+	"ProgressDeadlineExceeded": true,
+	"UnavailableReplicas":      true,
+	"FailedMount":              true,
+	"FailedAttachVolume":       true,
+	"FailedCreatePodSandBox":   true,
+	"LivenessProbeFailed":      true,
+	"ReadinessProbeFailed":     true,
+	"StartupProbeFailed":       true,
+	"ProbeFailed":              true,
+	"MissingConfigMap":         true,
+	"MissingConfigMapKey":      true,
+	"MissingSecret":            true,
+}
+
+// IsBuiltinErrorType reports whether a built-in detector raises errors of
+// type errorType, so a custom rule of that name would be mistaken for it
+func IsBuiltinErrorType(errorType string) bool {
+	return errorStates[errorType] || builtinErrorTypes[errorType]
+}
@@ -0,0 +1,216 @@
+package detect
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// PodEventSelector limits event lists and informers to warnings about pods
+const PodEventSelector = "involvedObject.kind=Pod,type=Warning"
+
+// trackedEventReasons are the warning events attached to findings
+var trackedEventReasons = map[string]bool{
+	"FailedMount":            true,
+	"FailedAttachVolume":     true,
+	"FailedScheduling":       true,
+	"Unhealthy":              true,
+	"BackOff":                true,
+	"FailedCreatePodSandBox": true,
+	"Evicted":                true,
+	"FailedPostStartHook":    true,
+	"FailedPreStopHook":      true,
+}
+
+// eventErrorReasons are the warning events that are errors of their own
+// once they repeat, even when the pod shows nothing wrong otherwise
+var eventErrorReasons = map[string]bool{
+	"FailedMount":            true,
+	"FailedAttachVolume":     true,
+	"Unhealthy":              true,
+	"FailedCreatePodSandBox": true,
+	"FailedPostStartHook":    true,
+	"FailedPreStopHook":      true,
+}
+
+// hookErrorTypes name the errors of failing lifecycle hooks like the
+// waiting reasons of the kubelet
+var hookErrorTypes = map[string]string{
+	"FailedPostStartHook": "PostStartHookError",
+	"FailedPreStopHook":   "PreStopHookError",
+}
+
+// IsTrackedEvent reports whether warnings with reason are attached to
+// findings, so a change of them may change the findings
+func IsTrackedEvent(reason string) bool {
+	return trackedEventReasons[reason]
+}
+
+// PodEvent is a Kubernetes Event related to a finding
+type PodEvent struct {
+	Reason   string `json:"reason"`
+	Message  string `json:"message"`
+	Count    int32  `json:"count"`
+	LastSeen string `json:"lastSeen"`
+}
+
+// Event is a tracked event of a pod, with the container it refers to
+type Event struct {
+	PodEvent
+	Container string
+	Seen      time.Time
+}
+
+// EventIndex holds the recent tracked events of each pod. A nil index has
+// no events.
+type EventIndex struct {
+	byPod map[string][]Event
+}
+
+// NewEventIndex indexes the tracked events seen within window. Events that
+// belong to an earlier pod of the same name are told apart by the UID when
+// the pods are looked up.
+func NewEventIndex(events []*v1.Event, window time.Duration, now time.Time) *EventIndex {
+	index := &EventIndex{byPod: make(map[string][]Event)}
+	for _, event := range events {
+		if !trackedEventReasons[event.Reason] {
+			continue
+		}
+		seen := EventTime(event)
+		if now.Sub(seen) > window {
+			continue
+		}
+
+		key := event.InvolvedObject.Namespace + "/" + event.InvolvedObject.Name + "/" + string(event.InvolvedObject.UID)
+		index.byPod[key] = append(index.byPod[key], Event{
+			PodEvent: PodEvent{
+				Reason:   event.Reason,
+				Message:  event.Message,
+				Count:    EventCount(event),
+				LastSeen: seen.UTC().Format(time.RFC3339),
+			},
+			Container: fieldPathContainer(event.InvolvedObject.FieldPath),
+			Seen:      seen,
+		})
+	}
+
+	// Newest first, so the most relevant event leads
+	for _, events := range index.byPod {
+		sort.Slice(events, func(i, j int) bool {
+			return events[i].Seen.After(events[j].Seen)
+		})
+	}
+	return index
+}
+
+// ForPod returns the events of pod
+func (idx *EventIndex) ForPod(pod *v1.Pod) []Event {
+	if idx == nil {
+		return nil
+	}
+	return idx.byPod[pod.Namespace+"/"+pod.Name+"/"+string(pod.UID)]
+}
+
+// eventsFor returns the events explaining a finding: those of its container
+// and those about the pod as a whole
+func eventsFor(events []Event, container string) []PodEvent {
+	var related []PodEvent
+	for _, event := range events {
+		if container == "" || event.Container == "" || event.Container == container {
+			related = append(related, event.PodEvent)
+		}
+	}
+	return related
+}
+
+// eventsDetector reports repeated warning events that leave no other
+// trace, such as a liveness probe that keeps failing
+type eventsDetector struct{}
+
+func (eventsDetector) Name() string { return "events" }
+
+func (eventsDetector) Detect(in *Input) []Finding {
+	var findings []Finding
+	seen := make(map[string]bool)
+	for _, event := range in.Events {
+		if !eventErrorReasons[event.Reason] || event.Count < in.Settings.EventMinCount {
+			continue
+		}
+
+		errorType := event.Reason
+		if event.Reason == "Unhealthy" {
+			errorType = probeErrorType(event.Message)
+		} else if hookErrorType, ok := hookErrorTypes[event.Reason]; ok {
+			errorType = hookErrorType
+		}
+		key := event.Container + "/" + errorType
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		findings = append(findings, Finding{
+			Namespace:     in.Pod.Namespace,
+			PodName:       in.Pod.Name,
+			ErrorType:     errorType,
+			ErrorMessage:  event.Message,
+			ContainerName: event.Container,
+			ContainerKind: ContainerKind(in.Pod, event.Container),
+			Owner:         in.Workloads.PodOwner(in.Pod),
+			Events:        []PodEvent{event.PodEvent},
+		})
+	}
+	return findings
+}
+
+// probeErrorType names the probe an Unhealthy event is about from its
+// message, e.g. "Liveness probe failed: ..."
+func probeErrorType(message string) string {
+	switch {
+	case strings.HasPrefix(message, "Liveness"):
+		return "LivenessProbeFailed"
+	case strings.HasPrefix(message, "Readiness"):
+		return "ReadinessProbeFailed"
+	case strings.HasPrefix(message, "Startup"):
+		return "StartupProbeFailed"
+	}
+	return "ProbeFailed"
+}
+
+// fieldPathContainer extracts the container name from an event field path
+// such as "spec.containers{web}"
+func fieldPathContainer(fieldPath string) string {
+	start := strings.Index(fieldPath, "{")
+	end := strings.LastIndex(fieldPath, "}")
+	if start < 0 || end < start {
+		return ""
+	}
+	return fieldPath[start+1 : end]
+}
+
+// EventTime returns when an event was last seen. Events recorded through
+// the events.k8s.io API only set the series or the event time.
+func EventTime(event *v1.Event) time.Time {
+	switch {
+	case event.Series != nil && !event.Series.LastObservedTime.IsZero():
+		return event.Series.LastObservedTime.Time
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
+
+// EventCount returns how often an event was seen, which is at least once
+func EventCount(event *v1.Event) int32 {
+	if event.Series != nil && event.Series.Count > event.Count {
+		return event.Series.Count
+	}
+	if event.Count == 0 {
+		return 1
+	}
+	return event.Count
+}
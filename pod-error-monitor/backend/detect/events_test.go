@@ -0,0 +1,145 @@
+package detect
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// podWarning returns a warning about the app container of the pod api-1
+func podWarning(reason, message string, count int32, seen time.Time) *v1.Event {
+	return &v1.Event{
+		InvolvedObject: v1.ObjectReference{
+			Kind: "Pod", Namespace: "shop", Name: "api-1", UID: "pod-uid", FieldPath: "spec.containers{app}",
+		},
+		Type:          v1.EventTypeWarning,
+		Reason:        reason,
+		Message:       message,
+		Count:         count,
+		LastTimestamp: metav1.NewTime(seen),
+	}
+}
+
+func TestNewEventIndex(t *testing.T) {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "api-1", UID: "pod-uid"}}
+	earlier := podWarning("BackOff", "back-off restarting failed container", 4, testNow.Add(-time.Minute))
+	earlier.InvolvedObject.UID = "earlier-pod-uid"
+	series := podWarning("Unhealthy", "Liveness probe failed", 1, time.Time{})
+	series.Series = &v1.EventSeries{Count: 7, LastObservedTime: metav1.NewMicroTime(testNow.Add(-2 * time.Minute))}
+
+	tests := []struct {
+		name       string
+		event      *v1.Event
+		wantCount  int32
+		wantSeen   time.Time
+		wantListed bool
+	}{
+		{
+			name:       "tracked warning",
+			event:      podWarning("BackOff", "back-off restarting failed container", 4, testNow.Add(-time.Minute)),
+			wantCount:  4,
+			wantSeen:   testNow.Add(-time.Minute),
+			wantListed: true,
+		},
+		{
+			name:       "series of the events API",
+			event:      series,
+			wantCount:  7,
+			wantSeen:   testNow.Add(-2 * time.Minute),
+			wantListed: true,
+		},
+		{
+			name:  "untracked reason",
+			event: podWarning("DNSConfigForming", "nameserver limits exceeded", 10, testNow),
+		},
+		{
+			name:  "outside the window",
+			event: podWarning("BackOff", "back-off restarting failed container", 4, testNow.Add(-time.Hour)),
+		},
+		{
+			name:  "earlier pod of the same name",
+			event: earlier,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := NewEventIndex([]*v1.Event{tt.event}, 30*time.Minute, testNow).ForPod(pod)
+			if !tt.wantListed {
+				if len(events) != 0 {
+					t.Errorf("events = %+v, want none", events)
+				}
+				return
+			}
+			if len(events) != 1 {
+				t.Fatalf("events = %+v, want one", events)
+			}
+			if e := events[0]; e.Count != tt.wantCount || !e.Seen.Equal(tt.wantSeen) || e.Container != "app" {
+				t.Errorf("event = %+v, want count %d seen %s of app", e, tt.wantCount, tt.wantSeen)
+			}
+		})
+	}
+}
+
+func TestEventsDetector(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "api-1", UID: "pod-uid"},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "app"}}},
+	}
+
+	tests := []struct {
+		name      string
+		events    []*v1.Event
+		wantTypes []string
+	}{
+		{
+			name:      "repeated liveness failures",
+			events:    []*v1.Event{podWarning("Unhealthy", "Liveness probe failed: HTTP probe failed with statuscode: 500", 5, testNow)},
+			wantTypes: []string{"LivenessProbeFailed"},
+		},
+		{
+			name:   "below the minimum count",
+			events: []*v1.Event{podWarning("Unhealthy", "Readiness probe failed: connection refused", 2, testNow)},
+		},
+		{
+			name:      "failing hook",
+			events:    []*v1.Event{podWarning("FailedPostStartHook", "PostStartHook failed", 3, testNow)},
+			wantTypes: []string{"PostStartHookError"},
+		},
+		{
+			name:   "only attached to other errors",
+			events: []*v1.Event{podWarning("BackOff", "back-off restarting failed container", 9, testNow)},
+		},
+		{
+			name: "one error per probe and container",
+			events: []*v1.Event{
+				podWarning("Unhealthy", "Readiness probe failed: connection refused", 4, testNow),
+				podWarning("Unhealthy", "Readiness probe failed: timeout", 3, testNow.Add(-time.Minute)),
+				podWarning("Unhealthy", "Startup probe failed: timeout", 3, testNow.Add(-time.Minute)),
+			},
+			wantTypes: []string{"ReadinessProbeFailed", "StartupProbeFailed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := &Input{
+				Pod:      pod,
+				Events:   NewEventIndex(tt.events, 30*time.Minute, testNow).ForPod(pod),
+				Settings: DefaultSettings,
+				Now:      testNow,
+			}
+			findings := eventsDetector{}.Detect(in)
+			if len(findings) != len(tt.wantTypes) {
+				t.Fatalf("findings = %+v, want %v", findings, tt.wantTypes)
+			}
+			for i, f := range findings {
+				if f.ErrorType != tt.wantTypes[i] || f.ContainerName != "app" || len(f.Events) != 1 {
+					t.Errorf("finding %d = %+v, want %s of app with its event", i, f, tt.wantTypes[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package detect
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// defaultBackoffLimit is the backoff limit of a Job that does not set one
+const defaultBackoffLimit = 6
+
+// jobsDetector reports failed Jobs and CronJobs that are suspended or have
+// not run successfully for too long
+type jobsDetector struct{}
+
+func (jobsDetector) Name() string { return "jobs" }
+
+func (jobsDetector) DetectWorkloads(workloads *Workloads, settings Settings, now time.Time) []Finding {
+	var errors []Finding
+	lastSuccess := make(map[string]time.Time)
+	for _, cronJob := range workloads.cronJobs {
+		if cronJob.Status.LastSuccessfulTime != nil {
+			lastSuccess[cronJob.Namespace+"/"+cronJob.Name] = cronJob.Status.LastSuccessfulTime.Time
+		}
+		if e, ok := cronJobError(cronJob, settings.OverdueMultiple, now); ok {
+			errors = append(errors, e)
+		}
+	}
+
+	for _, job := range workloads.jobs {
+		e, failedAt, ok := jobError(job)
+		if !ok {
+			continue
+		}
+		// A later successful run of the CronJob supersedes the failure
+		if ref := metav1.GetControllerOf(job); ref != nil && ref.Kind == "CronJob" {
+			if lastSuccess[job.Namespace+"/"+ref.Name].After(failedAt) {
+				continue
+			}
+			e.ErrorMessage = fmt.Sprintf("Run of CronJob %s failed: %s", ref.Name, e.ErrorMessage)
+		}
+		errors = append(errors, e)
+	}
+	return errors
+}
+
+// jobError returns the error of a Job that has failed, along with when it
+// failed. Running out of retries or time get their own error types.
+func jobError(job *batchv1.Job) (Finding, time.Time, bool) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type != batchv1.JobFailed || condition.Status != v1.ConditionTrue {
+			continue
+		}
+
+		errorType := "JobFailed"
+		switch condition.Reason {
+		case batchv1.JobReasonBackoffLimitExceeded, batchv1.JobReasonDeadlineExceeded:
+			errorType = condition.Reason
+		}
+
+		backoffLimit := int32(defaultBackoffLimit)
+		if job.Spec.BackoffLimit != nil {
+			backoffLimit = *job.Spec.BackoffLimit
+		}
+		message := condition.Message
+		if message == "" {
+			message = "Job has failed"
+		}
+		message += fmt.Sprintf(" (%d failed attempts, backoff limit %d)", job.Status.Failed, backoffLimit)
+
+		return Finding{
+			Namespace:    job.Namespace,
+			ErrorType:    errorType,
+			ErrorMessage: message,
+			Owner:        "Job/" + job.Name,
+			FinishedAt:   condition.LastTransitionTime.UTC().Format(time.RFC3339),
+		}, condition.LastTransitionTime.Time, true
+	}
+	return Finding{}, time.Time{}, false
+}
+
+// cronJobError returns the error of a CronJob that is suspended, or whose
+// last successful run is older than multiple intervals of its schedule. A
+// CronJob that never succeeded is measured from its creation.
+func cronJobError(cronJob *batchv1.CronJob, multiple float64, now time.Time) (Finding, bool) {
+	e := Finding{
+		Namespace: cronJob.Namespace,
+		Owner:     "CronJob/" + cronJob.Name,
+	}
+
+	if cronJob.Spec.Suspend != nil && *cronJob.Spec.Suspend {
+		e.ErrorType = "CronJobSuspended"
+		e.ErrorMessage = fmt.Sprintf("CronJob is suspended (schedule %q)", cronJob.Spec.Schedule)
+		return e, true
+	}
+
+	schedule, err := parseSchedule(cronJob)
+	if err != nil {
+		// The API server validates schedules, so this is a format the
+		// parser does not know rather than a broken CronJob
+		return Finding{}, false
+	}
+
+	last := cronJob.CreationTimestamp.Time
+	if cronJob.Status.LastSuccessfulTime != nil {
+		last = cronJob.Status.LastSuccessfulTime.Time
+	}
+
+	// The interval is measured after the first missed run, so a schedule
+	// with uneven gaps such as weekdays only does not flag over a weekend
+	next := schedule.Next(last)
+	interval := schedule.Next(next).Sub(next)
+	deadline := next.Add(time.Duration((multiple - 1) * float64(interval)))
+	if !now.After(deadline) {
+		return Finding{}, false
+	}
+
+	e.ErrorType = "CronJobOverdue"
+	if cronJob.Status.LastSuccessfulTime != nil {
+		e.ErrorMessage = fmt.Sprintf("Last successful run at %s, next run was due at %s (schedule %q)",
+			last.UTC().Format(time.RFC3339), next.UTC().Format(time.RFC3339), cronJob.Spec.Schedule)
+	} else {
+		e.ErrorMessage = fmt.Sprintf("No successful run since the CronJob was created at %s (schedule %q)",
+			last.UTC().Format(time.RFC3339), cronJob.Spec.Schedule)
+	}
+	return e, true
+}
+
+// parseSchedule parses the schedule of a CronJob in its time zone
+func parseSchedule(cronJob *batchv1.CronJob) (cron.Schedule, error) {
+	spec := cronJob.Spec.Schedule
+	if cronJob.Spec.TimeZone != nil && *cronJob.Spec.TimeZone != "" {
+		spec = "CRON_TZ=" + *cronJob.Spec.TimeZone + " " + spec
+	}
+	return cron.ParseStandard(spec)
+}
@@ -0,0 +1,201 @@
+package detect
+
+import (
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// testCronJob returns the CronJob report of the shop namespace, created a
+// week before testNow, that last succeeded at lastSuccess unless zero
+func testCronJob(schedule string, lastSuccess time.Time) *batchv1.CronJob {
+	cronJob := &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "shop",
+			Name:              "report",
+			CreationTimestamp: metav1.NewTime(testNow.AddDate(0, 0, -7)),
+		},
+		Spec: batchv1.CronJobSpec{Schedule: schedule},
+	}
+	if !lastSuccess.IsZero() {
+		cronJob.Status.LastSuccessfulTime = &metav1.Time{Time: lastSuccess}
+	}
+	return cronJob
+}
+
+func TestCronJobError(t *testing.T) {
+	suspended := testCronJob("0 * * * *", testNow.Add(-time.Minute))
+	suspended.Spec.Suspend = boolPtr(true)
+	recent := testCronJob("0 * * * *", time.Time{})
+	recent.CreationTimestamp = metav1.NewTime(testNow.Add(-30 * time.Minute))
+	// testNow is a Thursday; the Monday after at 08:00 follows a weekend
+	monday := time.Date(2026, 1, 5, 8, 0, 0, 0, time.UTC)
+	friday := time.Date(2026, 1, 2, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		cronJob     *batchv1.CronJob
+		now         time.Time
+		wantType    string
+		wantMessage string
+	}{
+		{
+			name:        "suspended",
+			cronJob:     suspended,
+			now:         testNow,
+			wantType:    "CronJobSuspended",
+			wantMessage: `CronJob is suspended (schedule "0 * * * *")`,
+		},
+		{
+			name:    "missed a single run",
+			cronJob: testCronJob("0 * * * *", testNow.Add(-90*time.Minute)),
+			now:     testNow,
+		},
+		{
+			name:        "missed two runs",
+			cronJob:     testCronJob("0 * * * *", testNow.Add(-150*time.Minute)),
+			now:         testNow,
+			wantType:    "CronJobOverdue",
+			wantMessage: `Last successful run at 2026-01-01T09:30:00Z, next run was due at 2026-01-01T10:00:00Z (schedule "0 * * * *")`,
+		},
+		{
+			name:    "created recently without a run",
+			cronJob: recent,
+			now:     testNow,
+		},
+		{
+			name:        "never succeeded",
+			cronJob:     testCronJob("0 * * * *", time.Time{}),
+			now:         testNow,
+			wantType:    "CronJobOverdue",
+			wantMessage: `No successful run since the CronJob was created at 2025-12-25T12:00:00Z (schedule "0 * * * *")`,
+		},
+		{
+			name:    "weekdays only over a weekend",
+			cronJob: testCronJob("0 9 * * 1-5", friday),
+			now:     monday,
+		},
+		{
+			name:        "daily over a weekend",
+			cronJob:     testCronJob("0 9 * * *", friday),
+			now:         monday,
+			wantType:    "CronJobOverdue",
+			wantMessage: `Last successful run at 2026-01-02T09:00:00Z, next run was due at 2026-01-03T09:00:00Z (schedule "0 9 * * *")`,
+		},
+		{
+			name:    "schedule the parser does not know",
+			cronJob: testCronJob("every day", time.Time{}),
+			now:     testNow,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, ok := cronJobError(tt.cronJob, DefaultSettings.OverdueMultiple, tt.now)
+			if ok != (tt.wantType != "") {
+				t.Fatalf("ok = %v, want %v (%+v)", ok, tt.wantType != "", e)
+			}
+			if e.ErrorType != tt.wantType || e.ErrorMessage != tt.wantMessage {
+				t.Errorf("error = %s %q, want %s %q", e.ErrorType, e.ErrorMessage, tt.wantType, tt.wantMessage)
+			}
+			if ok && e.Owner != "CronJob/report" {
+				t.Errorf("owner = %s, want CronJob/report", e.Owner)
+			}
+		})
+	}
+}
+
+// failedJob returns a Job of the shop namespace that failed at failedAt
+// for reason, run by cronJob unless empty
+func failedJob(name, reason, cronJob string, failedAt time.Time) *batchv1.Job {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: name},
+		Spec:       batchv1.JobSpec{BackoffLimit: int32Ptr(3)},
+		Status: batchv1.JobStatus{
+			Failed: 4,
+			Conditions: []batchv1.JobCondition{{
+				Type:               batchv1.JobFailed,
+				Status:             v1.ConditionTrue,
+				Reason:             reason,
+				Message:            "Job has reached the specified backoff limit",
+				LastTransitionTime: metav1.NewTime(failedAt),
+			}},
+		},
+	}
+	if cronJob != "" {
+		job.OwnerReferences = controlledBy("CronJob", cronJob)
+	}
+	return job
+}
+
+func TestJobsDetector(t *testing.T) {
+	running := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "migrate"},
+		Status:     batchv1.JobStatus{Active: 1, Failed: 1},
+	}
+
+	tests := []struct {
+		name        string
+		jobs        []*batchv1.Job
+		cronJobs    []*batchv1.CronJob
+		wantType    string
+		wantMessage string
+		wantOwner   string
+	}{
+		{
+			name:        "out of retries",
+			jobs:        []*batchv1.Job{failedJob("migrate", batchv1.JobReasonBackoffLimitExceeded, "", testNow)},
+			wantType:    "BackoffLimitExceeded",
+			wantMessage: "Job has reached the specified backoff limit (4 failed attempts, backoff limit 3)",
+			wantOwner:   "Job/migrate",
+		},
+		{
+			name:        "failed for another reason",
+			jobs:        []*batchv1.Job{failedJob("migrate", "PodFailurePolicy", "", testNow)},
+			wantType:    "JobFailed",
+			wantMessage: "Job has reached the specified backoff limit (4 failed attempts, backoff limit 3)",
+			wantOwner:   "Job/migrate",
+		},
+		{
+			name: "still retrying",
+			jobs: []*batchv1.Job{running},
+		},
+		{
+			name:        "failed run of a CronJob",
+			jobs:        []*batchv1.Job{failedJob("report-28912", batchv1.JobReasonDeadlineExceeded, "report", testNow.Add(-time.Minute))},
+			cronJobs:    []*batchv1.CronJob{testCronJob("0 * * * *", testNow.Add(-30*time.Minute))},
+			wantType:    "DeadlineExceeded",
+			wantMessage: "Run of CronJob report failed: Job has reached the specified backoff limit (4 failed attempts, backoff limit 3)",
+			wantOwner:   "Job/report-28912",
+		},
+		{
+			name:     "failed run superseded by a later success",
+			jobs:     []*batchv1.Job{failedJob("report-28911", batchv1.JobReasonBackoffLimitExceeded, "report", testNow.Add(-time.Hour))},
+			cronJobs: []*batchv1.CronJob{testCronJob("0 * * * *", testNow.Add(-time.Minute))},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewWorkloads(tt.jobs, tt.cronJobs, nil, nil, nil, nil)
+			findings := jobsDetector{}.DetectWorkloads(w, DefaultSettings, testNow)
+			if tt.wantType == "" {
+				if len(findings) != 0 {
+					t.Errorf("findings = %+v, want none", findings)
+				}
+				return
+			}
+			if len(findings) != 1 {
+				t.Fatalf("findings = %+v, want one %s", findings, tt.wantType)
+			}
+			f := findings[0]
+			if f.ErrorType != tt.wantType || f.ErrorMessage != tt.wantMessage || f.Owner != tt.wantOwner {
+				t.Errorf("finding = %s %q of %s, want %s %q of %s",
+					f.ErrorType, f.ErrorMessage, f.Owner, tt.wantType, tt.wantMessage, tt.wantOwner)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package detect
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// podFailedDetector reports pods in the Failed phase. A failed Job pod is
+// reported on its Job instead.
+type podFailedDetector struct{}
+
+func (podFailedDetector) Name() string { return "pod-failed" }
+
+func (podFailedDetector) Detect(in *Input) []Finding {
+	if in.Pod.Status.Phase != v1.PodFailed || in.Workloads.ControlsPod(in.Pod) {
+		return nil
+	}
+	return []Finding{podFinding(in, "PodFailed", "Pod is in Failed phase")}
+}
+
+// pendingDetector reports pods that are not making progress towards running
+type pendingDetector struct{}
+
+func (pendingDetector) Name() string { return "pending" }
+
+func (pendingDetector) Detect(in *Input) []Finding {
+	errorType, message, ok := pendingError(in.Pod, in.Settings.PendingTimeout, in.Now)
+	if !ok {
+		return nil
+	}
+	return []Finding{podFinding(in, errorType, message)}
+}
+
+// pendingError returns the error of a pod that is not making progress
+// towards running. A pod the scheduler rejected is Unschedulable right
+// away; any other pod Pending for longer than timeout is StuckPending,
+// unless one of its containers already reports an error that explains it.
+func pendingError(pod *v1.Pod, timeout time.Duration, now time.Time) (errorType, message string, ok bool) {
+	if pod.Status.Phase != v1.PodPending {
+		return "", "", false
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodScheduled && condition.Status == v1.ConditionFalse &&
+			condition.Reason == v1.PodReasonUnschedulable {
+			message := condition.Message
+			if message == "" {
+				message = "Pod cannot be scheduled"
+			}
+			return "Unschedulable", message, true
+		}
+	}
+
+	if now.Sub(pod.CreationTimestamp.Time) <= timeout {
+		return "", "", false
+	}
+
+	var waiting []string
+	for _, container := range ContainerStatuses(pod) {
+		if container.Status.State.Waiting == nil {
+			continue
+		}
+		reason := container.Status.State.Waiting.Reason
+		if IsErrorState(reason) {
+			return "", "", false
+		}
+		if reason != "" {
+			waiting = append(waiting, container.Status.Name+": "+reason)
+		}
+	}
+
+	// The start rather than the duration keeps the message stable between
+	// recomputes
+	message = fmt.Sprintf("Pod has been Pending since %s", pod.CreationTimestamp.UTC().Format(time.RFC3339))
+	if len(waiting) > 0 {
+		message += " (" + strings.Join(waiting, ", ") + ")"
+	}
+	return "StuckPending", message, true
+}
+
+// podFinding is an error of the pod of in as a whole
+func podFinding(in *Input, errorType, message string) Finding {
+	return Finding{
+		Namespace:    in.Pod.Namespace,
+		PodName:      in.Pod.Name,
+		ErrorType:    errorType,
+		ErrorMessage: message,
+		Owner:        in.Workloads.PodOwner(in.Pod),
+	}
+}
@@ -0,0 +1,88 @@
+package detect
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// pendingPod returns a Pending pod created age before testNow
+func pendingPod(age time.Duration, conditions []v1.PodCondition, statuses []v1.ContainerStatus) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "api-1", CreationTimestamp: metav1.NewTime(testNow.Add(-age))},
+		Status: v1.PodStatus{
+			Phase:             v1.PodPending,
+			Conditions:        conditions,
+			ContainerStatuses: statuses,
+		},
+	}
+}
+
+func TestPendingError(t *testing.T) {
+	unschedulable := v1.PodCondition{
+		Type:    v1.PodScheduled,
+		Status:  v1.ConditionFalse,
+		Reason:  v1.PodReasonUnschedulable,
+		Message: "0/3 nodes are available: 3 Insufficient memory.",
+	}
+	waitingFor := func(reason string) []v1.ContainerStatus {
+		return []v1.ContainerStatus{{Name: "app", State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: reason}}}}
+	}
+
+	tests := []struct {
+		name        string
+		pod         *v1.Pod
+		wantType    string
+		wantMessage string
+	}{
+		{
+			name:        "rejected by the scheduler right away",
+			pod:         pendingPod(time.Second, []v1.PodCondition{unschedulable}, nil),
+			wantType:    "Unschedulable",
+			wantMessage: "0/3 nodes are available: 3 Insufficient memory.",
+		},
+		{
+			name: "unschedulable without a message",
+			pod: pendingPod(time.Second, []v1.PodCondition{{
+				Type: v1.PodScheduled, Status: v1.ConditionFalse, Reason: v1.PodReasonUnschedulable,
+			}}, nil),
+			wantType:    "Unschedulable",
+			wantMessage: "Pod cannot be scheduled",
+		},
+		{
+			name: "pending within the timeout",
+			pod:  pendingPod(5*time.Minute, nil, waitingFor("ContainerCreating")),
+		},
+		{
+			name:        "stuck creating containers",
+			pod:         pendingPod(time.Hour, nil, waitingFor("ContainerCreating")),
+			wantType:    "StuckPending",
+			wantMessage: "Pod has been Pending since 2026-01-01T11:00:00Z (app: ContainerCreating)",
+		},
+		{
+			name: "stuck behind a container error",
+			pod:  pendingPod(time.Hour, nil, waitingFor("ImagePullBackOff")),
+		},
+		{
+			name: "running",
+			pod: &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(testNow.Add(-time.Hour))},
+				Status:     v1.PodStatus{Phase: v1.PodRunning},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errorType, message, ok := pendingError(tt.pod, DefaultSettings.PendingTimeout, testNow)
+			if ok != (tt.wantType != "") {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantType != "")
+			}
+			if errorType != tt.wantType || message != tt.wantMessage {
+				t.Errorf("error = %s %q, want %s %q", errorType, message, tt.wantType, tt.wantMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package detect
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// revisionAnnotation holds the rollout revision of a Deployment's ReplicaSet
+const revisionAnnotation = "deployment.kubernetes.io/revision"
+
+// ReplicaStatus is the replica status of a Deployment or StatefulSet whose
+// rollout is stuck or that runs below its desired replicas
+type ReplicaStatus struct {
+	Desired   int32 `json:"desired"`
+	Ready     int32 `json:"ready"`
+	Available int32 `json:"available"`
+	Updated   int32 `json:"updated"`
+	// Revision is the revision being rolled out and PreviousRevision the one
+	// before it: the ReplicaSets of a Deployment or the ControllerRevisions
+	// of a StatefulSet
+	Revision         string `json:"revision,omitempty"`
+	PreviousRevision string `json:"previousRevision,omitempty"`
+}
+
+// rolloutsDetector reports Deployments and StatefulSets with a stuck
+// rollout or fewer available replicas than desired
+type rolloutsDetector struct{}
+
+func (rolloutsDetector) Name() string { return "rollouts" }
+
+func (rolloutsDetector) DetectWorkloads(workloads *Workloads, settings Settings, now time.Time) []Finding {
+	var errors []Finding
+	for _, deployment := range workloads.deployments {
+		if e, ok := deploymentError(deployment, workloads.replicaSets[deployment.UID]); ok {
+			errors = append(errors, e)
+		}
+	}
+	for _, statefulSet := range workloads.statefulSets {
+		if e, ok := statefulSetError(statefulSet, workloads.statefulSetPods[statefulSet.UID], settings.RolloutDeadline, now); ok {
+			errors = append(errors, e)
+		}
+	}
+	return errors
+}
+
+// deploymentError returns the error of a Deployment whose rollout exceeded
+// its progress deadline, or that has fewer available replicas than desired
+// while no rollout is under way. A rollout in progress takes replicas down
+// on purpose and is only flagged once its deadline passes.
+func deploymentError(deployment *appsv1.Deployment, replicaSets []*appsv1.ReplicaSet) (Finding, bool) {
+	desired := ReplicaCount(deployment.Spec.Replicas)
+	status := &ReplicaStatus{
+		Desired:   desired,
+		Ready:     deployment.Status.ReadyReplicas,
+		Available: deployment.Status.AvailableReplicas,
+		Updated:   deployment.Status.UpdatedReplicas,
+	}
+	revision, previous := deploymentRevisions(replicaSets)
+	status.Revision, status.PreviousRevision = revision.name, previous.name
+
+	var progressing *appsv1.DeploymentCondition
+	for i, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing {
+			progressing = &deployment.Status.Conditions[i]
+		}
+	}
+	rollingOut := deployment.Status.ObservedGeneration < deployment.Generation ||
+		(progressing != nil && progressing.Status == v1.ConditionTrue && progressing.Reason != "NewReplicaSetAvailable")
+
+	e := Finding{
+		Namespace: deployment.Namespace,
+		Owner:     "Deployment/" + deployment.Name,
+		Replicas:  status,
+	}
+	switch {
+	case progressing != nil && progressing.Status == v1.ConditionFalse && progressing.Reason == "ProgressDeadlineExceeded":
+		e.ErrorType = "ProgressDeadlineExceeded"
+		e.ErrorMessage = progressing.Message
+		if e.ErrorMessage == "" {
+			e.ErrorMessage = "Rollout has exceeded its progress deadline"
+		}
+	case !rollingOut && status.Available < desired:
+		e.ErrorType = "UnavailableReplicas"
+		e.ErrorMessage = fmt.Sprintf("Deployment has %d of %d desired replicas available", status.Available, desired)
+	default:
+		return Finding{}, false
+	}
+
+	e.ErrorMessage += fmt.Sprintf(" (ready %d/%d, available %d/%d, updated %d/%d",
+		status.Ready, desired, status.Available, desired, status.Updated, desired)
+	if revision.name != "" {
+		e.ErrorMessage += fmt.Sprintf(", revision %d (%s)", revision.number, revision.name)
+	}
+	if previous.name != "" {
+		e.ErrorMessage += fmt.Sprintf(", previous revision %d (%s)", previous.number, previous.name)
+	}
+	e.ErrorMessage += ")"
+	return e, true
+}
+
+// statefulSetError returns the error of a StatefulSet that has run below its
+// desired available replicas for longer than deadline. StatefulSets have no
+// progress deadline of their own, so the time is taken from the pods: the
+// earliest of them to become unready, or to be created without ever being
+// ready. Past the deadline a rolling update is stuck and reported as
+// ProgressDeadlineExceeded, like a Deployment; otherwise the StatefulSet is
+// short of replicas. With the OnDelete strategy a pending revision is not a
+// rollout, as pods only move to it when they are deleted.
+func statefulSetError(statefulSet *appsv1.StatefulSet, pods []*v1.Pod, deadline time.Duration, now time.Time) (Finding, bool) {
+	desired := ReplicaCount(statefulSet.Spec.Replicas)
+	status := &ReplicaStatus{
+		Desired:   desired,
+		Ready:     statefulSet.Status.ReadyReplicas,
+		Available: statefulSet.Status.AvailableReplicas,
+		Updated:   statefulSet.Status.UpdatedReplicas,
+		Revision:  statefulSet.Status.UpdateRevision,
+	}
+	if statefulSet.Status.CurrentRevision != statefulSet.Status.UpdateRevision {
+		status.PreviousRevision = statefulSet.Status.CurrentRevision
+	}
+	if status.Available >= desired {
+		return Finding{}, false
+	}
+
+	rollingOut := statefulSet.Status.ObservedGeneration < statefulSet.Generation ||
+		(status.PreviousRevision != "" && statefulSet.Spec.UpdateStrategy.Type != appsv1.OnDeleteStatefulSetStrategyType)
+
+	since, known := unavailableSince(pods)
+	switch {
+	case known && now.Sub(since) < deadline:
+		return Finding{}, false
+	case !known && rollingOut:
+		// The controller is replacing a pod it just deleted
+		return Finding{}, false
+	}
+
+	e := Finding{
+		Namespace: statefulSet.Namespace,
+		Owner:     "StatefulSet/" + statefulSet.Name,
+		Replicas:  status,
+	}
+	if rollingOut {
+		e.ErrorType = "ProgressDeadlineExceeded"
+		e.ErrorMessage = fmt.Sprintf("Rollout has not made progress for %s", now.Sub(since).Truncate(time.Second))
+	} else {
+		e.ErrorType = "UnavailableReplicas"
+		e.ErrorMessage = fmt.Sprintf("StatefulSet has %d of %d desired replicas available", status.Available, desired)
+		if known {
+			e.ErrorMessage += fmt.Sprintf(" for %s", now.Sub(since).Truncate(time.Second))
+		}
+	}
+
+	e.ErrorMessage += fmt.Sprintf(" (ready %d/%d, available %d/%d, updated %d/%d",
+		status.Ready, desired, status.Available, desired, status.Updated, desired)
+	if status.Revision != "" {
+		e.ErrorMessage += ", revision " + status.Revision
+	}
+	if status.PreviousRevision != "" {
+		e.ErrorMessage += ", previous revision " + status.PreviousRevision
+	}
+	e.ErrorMessage += ")"
+	return e, true
+}
+
+// unavailableSince returns when the earliest of the unready pods became
+// unready, or was created if it never was ready. It reports false when all
+// pods are ready, e.g. because the missing replicas have no pod yet.
+func unavailableSince(pods []*v1.Pod) (time.Time, bool) {
+	var since time.Time
+	for _, pod := range pods {
+		t := pod.CreationTimestamp.Time
+		ready := false
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type != v1.PodReady {
+				continue
+			}
+			ready = condition.Status == v1.ConditionTrue
+			if !condition.LastTransitionTime.IsZero() {
+				t = condition.LastTransitionTime.Time
+			}
+		}
+		if ready {
+			continue
+		}
+		if since.IsZero() || t.Before(since) {
+			since = t
+		}
+	}
+	return since, !since.IsZero()
+}
+
+// replicaSetRevision is a ReplicaSet of a Deployment and its revision
+type replicaSetRevision struct {
+	name   string
+	number int64
+}
+
+// deploymentRevisions returns the current and the previous ReplicaSet of a
+// Deployment. The current one always has the highest revision.
+func deploymentRevisions(replicaSets []*appsv1.ReplicaSet) (current, previous replicaSetRevision) {
+	revisions := make([]replicaSetRevision, 0, len(replicaSets))
+	for _, replicaSet := range replicaSets {
+		number, err := strconv.ParseInt(replicaSet.Annotations[revisionAnnotation], 10, 64)
+		if err != nil {
+			continue
+		}
+		revisions = append(revisions, replicaSetRevision{name: replicaSet.Name, number: number})
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].number > revisions[j].number
+	})
+
+	if len(revisions) > 0 {
+		current = revisions[0]
+	}
+	if len(revisions) > 1 {
+		previous = revisions[1]
+	}
+	return current, previous
+}
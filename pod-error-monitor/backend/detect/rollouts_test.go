@@ -0,0 +1,212 @@
+package detect
+
+import (
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var testNow = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func int32Ptr(i int32) *int32 { return &i }
+
+func testDeployment(progressing *appsv1.DeploymentCondition, available int32) *appsv1.Deployment {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "api", UID: "deploy-uid", Generation: 2},
+		Spec:       appsv1.DeploymentSpec{Replicas: int32Ptr(3)},
+		Status: appsv1.DeploymentStatus{
+			ObservedGeneration: 2,
+			ReadyReplicas:      available,
+			AvailableReplicas:  available,
+			UpdatedReplicas:    3,
+		},
+	}
+	if progressing != nil {
+		deployment.Status.Conditions = []appsv1.DeploymentCondition{*progressing}
+	}
+	return deployment
+}
+
+func testReplicaSet(name, revision string) *appsv1.ReplicaSet {
+	return &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "shop",
+			Name:        name,
+			Annotations: map[string]string{revisionAnnotation: revision},
+			OwnerReferences: []metav1.OwnerReference{{
+				Kind: "Deployment", Name: "api", UID: "deploy-uid", Controller: boolPtr(true),
+			}},
+		},
+	}
+}
+
+func boolPtr(b bool) *bool { return &b }
+
+func TestDeploymentError(t *testing.T) {
+	replicaSets := []*appsv1.ReplicaSet{testReplicaSet("api-6d9f", "3"), testReplicaSet("api-5c8b", "2"), testReplicaSet("api-4b7a", "1")}
+
+	tests := []struct {
+		name        string
+		deployment  *appsv1.Deployment
+		wantType    string
+		wantMessage string
+	}{
+		{
+			name:       "healthy",
+			deployment: testDeployment(&appsv1.DeploymentCondition{Type: appsv1.DeploymentProgressing, Status: v1.ConditionTrue, Reason: "NewReplicaSetAvailable"}, 3),
+		},
+		{
+			name: "progress deadline exceeded",
+			deployment: testDeployment(&appsv1.DeploymentCondition{
+				Type: appsv1.DeploymentProgressing, Status: v1.ConditionFalse, Reason: "ProgressDeadlineExceeded",
+				Message: `ReplicaSet "api-6d9f" has timed out progressing.`,
+			}, 2),
+			wantType:    "ProgressDeadlineExceeded",
+			wantMessage: `ReplicaSet "api-6d9f" has timed out progressing. (ready 2/3, available 2/3, updated 3/3, revision 3 (api-6d9f), previous revision 2 (api-5c8b))`,
+		},
+		{
+			name:       "rollout in progress takes replicas down on purpose",
+			deployment: testDeployment(&appsv1.DeploymentCondition{Type: appsv1.DeploymentProgressing, Status: v1.ConditionTrue, Reason: "ReplicaSetUpdated"}, 2),
+		},
+		{
+			name:        "unavailable without a rollout",
+			deployment:  testDeployment(&appsv1.DeploymentCondition{Type: appsv1.DeploymentProgressing, Status: v1.ConditionTrue, Reason: "NewReplicaSetAvailable"}, 1),
+			wantType:    "UnavailableReplicas",
+			wantMessage: "Deployment has 1 of 3 desired replicas available (ready 1/3, available 1/3, updated 3/3, revision 3 (api-6d9f), previous revision 2 (api-5c8b))",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, ok := deploymentError(tt.deployment, replicaSets)
+			if ok != (tt.wantType != "") {
+				t.Fatalf("flagged = %v, want %v (%+v)", ok, tt.wantType != "", e)
+			}
+			if !ok {
+				return
+			}
+			if e.ErrorType != tt.wantType || e.ErrorMessage != tt.wantMessage {
+				t.Errorf("got %s %q, want %s %q", e.ErrorType, e.ErrorMessage, tt.wantType, tt.wantMessage)
+			}
+			if e.Owner != "Deployment/api" || e.Replicas == nil || e.Replicas.Revision != "api-6d9f" {
+				t.Errorf("owner %s, replicas %+v", e.Owner, e.Replicas)
+			}
+		})
+	}
+}
+
+func testStatefulSet(strategy appsv1.StatefulSetUpdateStrategyType, currentRevision string, available int32) *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "db", UID: "sts-uid", Generation: 4},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:       int32Ptr(3),
+			UpdateStrategy: appsv1.StatefulSetUpdateStrategy{Type: strategy},
+		},
+		Status: appsv1.StatefulSetStatus{
+			ObservedGeneration: 4,
+			ReadyReplicas:      available,
+			AvailableReplicas:  available,
+			UpdatedReplicas:    1,
+			CurrentRevision:    currentRevision,
+			UpdateRevision:     "db-7f8c",
+		},
+	}
+}
+
+// statefulSetPod returns a pod of the db StatefulSet that has been unready
+// for unready, or ready when unready is zero
+func statefulSetPod(name string, unready time.Duration) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "shop",
+			Name:              name,
+			CreationTimestamp: metav1.NewTime(testNow.Add(-24 * time.Hour)),
+			OwnerReferences: []metav1.OwnerReference{{
+				Kind: "StatefulSet", Name: "db", UID: types.UID("sts-uid"), Controller: boolPtr(true),
+			}},
+		},
+	}
+	condition := v1.PodCondition{Type: v1.PodReady, Status: v1.ConditionTrue, LastTransitionTime: metav1.NewTime(testNow.Add(-23 * time.Hour))}
+	if unready > 0 {
+		condition.Status = v1.ConditionFalse
+		condition.LastTransitionTime = metav1.NewTime(testNow.Add(-unready))
+	}
+	pod.Status.Conditions = []v1.PodCondition{condition}
+	return pod
+}
+
+func TestStatefulSetError(t *testing.T) {
+	tests := []struct {
+		name        string
+		statefulSet *appsv1.StatefulSet
+		pods        []*v1.Pod
+		wantType    string
+		wantMessage string
+	}{
+		{
+			name:        "all replicas available",
+			statefulSet: testStatefulSet(appsv1.RollingUpdateStatefulSetStrategyType, "db-7f8c", 3),
+			pods:        []*v1.Pod{statefulSetPod("db-0", 0), statefulSetPod("db-1", 0), statefulSetPod("db-2", 0)},
+		},
+		{
+			name:        "rolling update within the deadline",
+			statefulSet: testStatefulSet(appsv1.RollingUpdateStatefulSetStrategyType, "db-6b5d", 2),
+			pods:        []*v1.Pod{statefulSetPod("db-0", 0), statefulSetPod("db-1", 0), statefulSetPod("db-2", 2*time.Minute)},
+		},
+		{
+			name:        "rolling update stuck past the deadline",
+			statefulSet: testStatefulSet(appsv1.RollingUpdateStatefulSetStrategyType, "db-6b5d", 2),
+			pods:        []*v1.Pod{statefulSetPod("db-0", 0), statefulSetPod("db-1", 0), statefulSetPod("db-2", 25*time.Minute)},
+			wantType:    "ProgressDeadlineExceeded",
+			wantMessage: "Rollout has not made progress for 25m0s (ready 2/3, available 2/3, updated 1/3, revision db-7f8c, previous revision db-6b5d)",
+		},
+		{
+			name:        "rolling update replacing a deleted pod",
+			statefulSet: testStatefulSet(appsv1.RollingUpdateStatefulSetStrategyType, "db-6b5d", 2),
+			pods:        []*v1.Pod{statefulSetPod("db-0", 0), statefulSetPod("db-1", 0)},
+		},
+		{
+			name:        "OnDelete revision pending with an unready pod",
+			statefulSet: testStatefulSet(appsv1.OnDeleteStatefulSetStrategyType, "db-6b5d", 2),
+			pods:        []*v1.Pod{statefulSetPod("db-0", 0), statefulSetPod("db-1", 0), statefulSetPod("db-2", time.Hour)},
+			wantType:    "UnavailableReplicas",
+			wantMessage: "StatefulSet has 2 of 3 desired replicas available for 1h0m0s (ready 2/3, available 2/3, updated 1/3, revision db-7f8c, previous revision db-6b5d)",
+		},
+		{
+			name:        "pod flapping within the deadline",
+			statefulSet: testStatefulSet(appsv1.RollingUpdateStatefulSetStrategyType, "db-7f8c", 2),
+			pods:        []*v1.Pod{statefulSetPod("db-0", 0), statefulSetPod("db-1", 0), statefulSetPod("db-2", 30*time.Second)},
+		},
+		{
+			name:        "missing pod without a rollout",
+			statefulSet: testStatefulSet(appsv1.RollingUpdateStatefulSetStrategyType, "db-7f8c", 2),
+			pods:        []*v1.Pod{statefulSetPod("db-0", 0), statefulSetPod("db-1", 0)},
+			wantType:    "UnavailableReplicas",
+			wantMessage: "StatefulSet has 2 of 3 desired replicas available (ready 2/3, available 2/3, updated 1/3, revision db-7f8c)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewWorkloads(nil, nil, nil, []*appsv1.StatefulSet{tt.statefulSet}, nil, tt.pods)
+			findings := rolloutsDetector{}.DetectWorkloads(w, DefaultSettings, testNow)
+			if len(findings) != 0 != (tt.wantType != "") {
+				t.Fatalf("findings = %+v, want type %q", findings, tt.wantType)
+			}
+			if len(findings) == 0 {
+				return
+			}
+			e := findings[0]
+			if e.ErrorType != tt.wantType || e.ErrorMessage != tt.wantMessage {
+				t.Errorf("got %s %q, want %s %q", e.ErrorType, e.ErrorMessage, tt.wantType, tt.wantMessage)
+			}
+			if e.Owner != "StatefulSet/db" {
+				t.Errorf("owner = %s", e.Owner)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package detect
+
+// Categories the findings are scored in
+const (
+	CategoryCrashLoop    = "crashLoop"
+	CategoryImagePull    = "imagePull"
+	CategoryHighRestarts = "highRestarts"
+	CategoryPending      = "pending"
+	CategoryRollout      = "rollout"
+	CategoryOther        = "other"
+	// CategoryCustom holds the errors of custom rules, each scored with the
+	// weight of its rule. Category never returns it, ScoreCategory does.
+	CategoryCustom = "custom"
+)
+
+// Category maps an error type to the category it is scored in
+func Category(errorType string) string {
+	switch errorType {
+	case "CrashLoopBackOff":
+		return CategoryCrashLoop
+	case "ImagePullBackOff", "ErrImagePull":
+		return CategoryImagePull
+	case "HighRestartCount":
+		return CategoryHighRestarts
+	case "Unschedulable", "StuckPending":
+		return CategoryPending
+	case "ProgressDeadlineExceeded", "UnavailableReplicas":
+		return CategoryRollout
+	}
+	return CategoryOther
+}
+
+// ScoreCategory returns the category a finding is scored in, which is
+// CategoryCustom for the errors of custom rules
+func ScoreCategory(e Finding) string {
+	if e.Rule != "" {
+		return CategoryCustom
+	}
+	return Category(e.ErrorType)
+}
+
+// Weights are the points an error of each category scores
+type Weights struct {
+	CrashLoop    float64
+	ImagePull    float64
+	HighRestarts float64
+	Pending      float64
+	Rollout      float64
+	OtherErrors  float64
+	// RestartMultiplier is scored for each restart of a container above
+	// the restart threshold
+	RestartMultiplier float64
+}
+
+// DefaultWeights are the weights the backend scores with unless configured
+// otherwise, and the weights of the CLI
+var DefaultWeights = Weights{
+	CrashLoop:         3.0,
+	ImagePull:         2.0,
+	HighRestarts:      2.0,
+	Pending:           2.0,
+	Rollout:           3.0,
+	OtherErrors:       1.0,
+	RestartMultiplier: 0.1,
+}
+
+// Counts are the errors of a namespace or workload by category
+type Counts struct {
+	Total        int
+	CrashLoop    int
+	ImagePull    int
+	HighRestarts int
+	Pending      int
+	Rollout      int
+	Custom       int
+	// Restarts are those of the containers above the restart threshold.
+	// The restarts of a crash looping container add up in its own category.
+	Restarts int32
+	// CustomScore is the summed weight of the errors of custom rules
+	CustomScore float64
+}
+
+// add counts a finding. An error of a custom rule scores ruleWeight.
+func (c *Counts) add(e Finding, ruleWeight float64) {
+	c.Total++
+	if e.ErrorType == "HighRestartCount" {
+		c.Restarts += e.RestartCount
+	}
+	switch ScoreCategory(e) {
+	case CategoryCrashLoop:
+		c.CrashLoop++
+	case CategoryImagePull:
+		c.ImagePull++
+	case CategoryHighRestarts:
+		c.HighRestarts++
+	case CategoryPending:
+		c.Pending++
+	case CategoryRollout:
+		c.Rollout++
+	case CategoryCustom:
+		c.Custom++
+		c.CustomScore += ruleWeight
+	}
+}
+
+// Other is the number of errors outside the weighted categories
+func (c Counts) Other() int {
+	return c.Total - c.CrashLoop - c.ImagePull - c.HighRestarts - c.Pending - c.Rollout - c.Custom
+}
+
+// Score weighs the counts
+func (c Counts) Score(w Weights) float64 {
+	return float64(c.CrashLoop)*w.CrashLoop +
+		float64(c.ImagePull)*w.ImagePull +
+		float64(c.HighRestarts)*w.HighRestarts +
+		float64(c.Pending)*w.Pending +
+		float64(c.Rollout)*w.Rollout +
+		float64(c.Other())*w.OtherErrors +
+		c.CustomScore +
+		float64(c.Restarts)*w.RestartMultiplier
+}
+
+// ScoreOptions tune how findings are tallied
+type ScoreOptions struct {
+	// ByWorkload scores each category once per workload, however many of
+	// its replicas have an error of it
+	ByWorkload bool
+	// RuleWeight returns the score of an error of the custom rule name.
+	// Without it every such error scores 1.
+	RuleWeight func(name string) float64
+}
+
+// Tally is the outcome of tallying the findings of a namespace or workload
+type Tally struct {
+	// Counts hold every finding
+	Counts Counts
+	// Scored hold the findings the score is calculated from
+	Scored Counts
+	// Pods are the pods with at least one error. Errors of a workload
+	// itself are not about a pod.
+	Pods int
+}
+
+// Score weighs the scored counts
+func (t Tally) Score(w Weights) float64 {
+	return t.Scored.Score(w)
+}
+
+// TallyFindings counts findings, which usually are those of one namespace
+// or workload
+func TallyFindings(findings []Finding, opts ScoreOptions) Tally {
+	var tally Tally
+	pods := make(map[string]bool)
+	scoredKeys := make(map[string]bool)
+
+	for _, e := range findings {
+		weight := 1.0
+		if e.Rule != "" && opts.RuleWeight != nil {
+			weight = opts.RuleWeight(e.Rule)
+		}
+
+		tally.Counts.add(e, weight)
+		if opts.ByWorkload {
+			key := e.Namespace + "/" + e.Owner + "/" + ScoreCategory(e)
+			if !scoredKeys[key] {
+				scoredKeys[key] = true
+				tally.Scored.add(e, weight)
+			}
+		} else {
+			tally.Scored.add(e, weight)
+		}
+
+		if e.PodName != "" {
+			pods[e.Namespace+"/"+e.PodName] = true
+		}
+	}
+
+	tally.Pods = len(pods)
+	return tally
+}
@@ -0,0 +1,94 @@
+package detect
+
+import "testing"
+
+func TestTallyFindings(t *testing.T) {
+	crashLoop := func(pod string, restarts int32) Finding {
+		return Finding{Namespace: "shop", PodName: pod, ErrorType: "CrashLoopBackOff", RestartCount: restarts, Owner: "Deployment/api"}
+	}
+	highRestarts := func(pod string, restarts int32) Finding {
+		return Finding{Namespace: "shop", PodName: pod, ErrorType: "HighRestartCount", RestartCount: restarts, Owner: "Deployment/api"}
+	}
+
+	tests := []struct {
+		name       string
+		findings   []Finding
+		options    ScoreOptions
+		wantCounts Counts
+		wantPods   int
+		wantScore  float64
+	}{
+		{
+			name:       "restarts of a crash loop are not scored twice",
+			findings:   []Finding{crashLoop("api-1", 40)},
+			wantCounts: Counts{Total: 1, CrashLoop: 1},
+			wantPods:   1,
+			wantScore:  3,
+		},
+		{
+			name:       "restarts above the threshold",
+			findings:   []Finding{highRestarts("api-1", 10), crashLoop("api-1", 10)},
+			wantCounts: Counts{Total: 2, CrashLoop: 1, HighRestarts: 1, Restarts: 10},
+			wantPods:   1,
+			wantScore:  3 + 2 + 1,
+		},
+		{
+			name: "every category",
+			findings: []Finding{
+				{Namespace: "shop", PodName: "web-1", ErrorType: "ErrImagePull"},
+				{Namespace: "shop", PodName: "web-2", ErrorType: "Unschedulable"},
+				{Namespace: "shop", ErrorType: "UnavailableReplicas", Owner: "StatefulSet/db"},
+				{Namespace: "shop", PodName: "web-3", ErrorType: "OOMKilled"},
+			},
+			wantCounts: Counts{Total: 4, ImagePull: 1, Pending: 1, Rollout: 1},
+			wantPods:   3,
+			wantScore:  2 + 2 + 3 + 1,
+		},
+		{
+			name: "custom rules score their weight",
+			findings: []Finding{
+				{Namespace: "shop", PodName: "api-1", ErrorType: "CriticalNotReady", Rule: "CriticalNotReady"},
+				{Namespace: "shop", PodName: "api-2", ErrorType: "Labelled", Rule: "Labelled"},
+			},
+			options: ScoreOptions{RuleWeight: func(name string) float64 {
+				if name == "CriticalNotReady" {
+					return 5
+				}
+				return 0.5
+			}},
+			wantCounts: Counts{Total: 2, Custom: 2, CustomScore: 5.5},
+			wantPods:   2,
+			wantScore:  5.5,
+		},
+		{
+			name:       "custom rules without weights score 1",
+			findings:   []Finding{{Namespace: "shop", PodName: "api-1", ErrorType: "CriticalNotReady", Rule: "CriticalNotReady"}},
+			wantCounts: Counts{Total: 1, Custom: 1, CustomScore: 1},
+			wantPods:   1,
+			wantScore:  1,
+		},
+		{
+			name:       "by workload scores each category once",
+			findings:   []Finding{crashLoop("api-1", 3), crashLoop("api-2", 3), highRestarts("api-1", 8), highRestarts("api-2", 6)},
+			options:    ScoreOptions{ByWorkload: true},
+			wantCounts: Counts{Total: 4, CrashLoop: 2, HighRestarts: 2, Restarts: 14},
+			wantPods:   2,
+			wantScore:  3 + 2 + 0.8,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tally := TallyFindings(tt.findings, tt.options)
+			if tally.Counts != tt.wantCounts {
+				t.Errorf("counts = %+v, want %+v", tally.Counts, tt.wantCounts)
+			}
+			if tally.Pods != tt.wantPods {
+				t.Errorf("pods = %d, want %d", tally.Pods, tt.wantPods)
+			}
+			if score := tally.Score(DefaultWeights); score < tt.wantScore-1e-9 || score > tt.wantScore+1e-9 {
+				t.Errorf("score = %g, want %g", score, tt.wantScore)
+			}
+		})
+	}
+}
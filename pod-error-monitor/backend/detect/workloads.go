@@ -0,0 +1,97 @@
+package detect
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Workloads holds the workloads of a namespace, or of all namespaces, whose
+// own state raises errors. A nil Workloads has none, which leaves failed Job
+// pods to the pod detectors.
+type Workloads struct {
+	jobs         []*batchv1.Job
+	cronJobs     []*batchv1.CronJob
+	deployments  []*appsv1.Deployment
+	statefulSets []*appsv1.StatefulSet
+	// replicaSets are the ReplicaSets of each Deployment, by its UID
+	replicaSets map[types.UID][]*appsv1.ReplicaSet
+	// statefulSetPods are the pods of each StatefulSet, by its UID
+	statefulSetPods map[types.UID][]*v1.Pod
+	// replicaSetOwners are the controllers of all ReplicaSets, by namespace
+	// and name; nil for a bare ReplicaSet
+	replicaSetOwners map[string]*metav1.OwnerReference
+	knownJobs        map[string]bool
+}
+
+// NewWorkloads indexes listed workloads and the pods they control. Any of
+// them may be nil when they cannot be listed.
+func NewWorkloads(jobs []*batchv1.Job, cronJobs []*batchv1.CronJob, deployments []*appsv1.Deployment,
+	statefulSets []*appsv1.StatefulSet, replicaSets []*appsv1.ReplicaSet, pods []*v1.Pod) *Workloads {
+	w := &Workloads{
+		jobs:             jobs,
+		cronJobs:         cronJobs,
+		deployments:      deployments,
+		statefulSets:     statefulSets,
+		replicaSets:      make(map[types.UID][]*appsv1.ReplicaSet),
+		statefulSetPods:  make(map[types.UID][]*v1.Pod),
+		replicaSetOwners: make(map[string]*metav1.OwnerReference, len(replicaSets)),
+		knownJobs:        make(map[string]bool, len(jobs)),
+	}
+	for _, job := range jobs {
+		w.knownJobs[job.Namespace+"/"+job.Name] = true
+	}
+	for _, replicaSet := range replicaSets {
+		ref := metav1.GetControllerOf(replicaSet)
+		w.replicaSetOwners[replicaSet.Namespace+"/"+replicaSet.Name] = ref
+		if ref != nil && ref.Kind == "Deployment" {
+			w.replicaSets[ref.UID] = append(w.replicaSets[ref.UID], replicaSet)
+		}
+	}
+	for _, pod := range pods {
+		if ref := metav1.GetControllerOf(pod); ref != nil && ref.Kind == "StatefulSet" {
+			w.statefulSetPods[ref.UID] = append(w.statefulSetPods[ref.UID], pod)
+		}
+	}
+	return w
+}
+
+// ControlsPod reports whether pod belongs to a known Job. The failure of
+// such a pod is reported once on its Job rather than on every attempt.
+func (w *Workloads) ControlsPod(pod *v1.Pod) bool {
+	if w == nil {
+		return false
+	}
+	ref := metav1.GetControllerOf(pod)
+	return ref != nil && ref.Kind == "Job" && w.knownJobs[pod.Namespace+"/"+ref.Name]
+}
+
+// PodOwner returns the controlling workload of a pod as "Kind/name". Pods of
+// a ReplicaSet controlled by a Deployment are attributed to the Deployment,
+// so errors keep their identity across rollouts. A bare ReplicaSet, or one
+// that is not known (e.g. on a nil Workloads), owns its pods itself.
+func (w *Workloads) PodOwner(pod *v1.Pod) string {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil {
+		return "Pod/" + pod.Name
+	}
+
+	if ref.Kind == "ReplicaSet" && w != nil {
+		if owner := w.replicaSetOwners[pod.Namespace+"/"+ref.Name]; owner != nil && owner.Kind == "Deployment" {
+			return "Deployment/" + owner.Name
+		}
+	}
+
+	return ref.Kind + "/" + ref.Name
+}
+
+// ReplicaCount returns the replicas a workload spec asks for, which default
+// to one
+func ReplicaCount(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
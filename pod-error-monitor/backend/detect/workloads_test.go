@@ -0,0 +1,89 @@
+package detect
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func controlledBy(kind, name string) []metav1.OwnerReference {
+	return []metav1.OwnerReference{{Kind: kind, Name: name, UID: types.UID(kind + "-" + name), Controller: boolPtr(true)}}
+}
+
+func TestPodOwner(t *testing.T) {
+	replicaSets := []*appsv1.ReplicaSet{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "api-6d9f", OwnerReferences: controlledBy("Deployment", "api")}},
+		// A bare ReplicaSet whose name happens to end in its template hash
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "worker-5c8b"}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "rollout-7b9c", OwnerReferences: controlledBy("Rollout", "rollout")}},
+	}
+	workloads := NewWorkloads(nil, nil, nil, nil, replicaSets, nil)
+
+	tests := []struct {
+		name      string
+		owners    []metav1.OwnerReference
+		labels    map[string]string
+		workloads *Workloads
+		want      string
+	}{
+		{
+			name: "bare pod",
+			want: "Pod/api-6d9f-x2k4q",
+		},
+		{
+			name:      "ReplicaSet of a Deployment",
+			owners:    controlledBy("ReplicaSet", "api-6d9f"),
+			labels:    map[string]string{"pod-template-hash": "6d9f"},
+			workloads: workloads,
+			want:      "Deployment/api",
+		},
+		{
+			name:      "bare ReplicaSet stays a ReplicaSet",
+			owners:    controlledBy("ReplicaSet", "worker-5c8b"),
+			labels:    map[string]string{"pod-template-hash": "5c8b"},
+			workloads: workloads,
+			want:      "ReplicaSet/worker-5c8b",
+		},
+		{
+			name:      "ReplicaSet of another controller",
+			owners:    controlledBy("ReplicaSet", "rollout-7b9c"),
+			workloads: workloads,
+			want:      "ReplicaSet/rollout-7b9c",
+		},
+		{
+			name:   "unknown ReplicaSet",
+			owners: controlledBy("ReplicaSet", "api-6d9f"),
+			labels: map[string]string{"pod-template-hash": "6d9f"},
+			want:   "ReplicaSet/api-6d9f",
+		},
+		{
+			name:      "StatefulSet",
+			owners:    controlledBy("StatefulSet", "db"),
+			workloads: workloads,
+			want:      "StatefulSet/db",
+		},
+		{
+			name:      "owner that is not the controller",
+			owners:    []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "api-6d9f"}},
+			workloads: workloads,
+			want:      "Pod/api-6d9f-x2k4q",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Namespace:       "shop",
+				Name:            "api-6d9f-x2k4q",
+				Labels:          tt.labels,
+				OwnerReferences: tt.owners,
+			}}
+			if got := tt.workloads.PodOwner(pod); got != tt.want {
+				t.Errorf("PodOwner = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"time"
+
+	"pod-error-monitor/config"
+	"pod-error-monitor/detect"
+
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// newEventIndex indexes the tracked events of lister seen within window
+func newEventIndex(lister corelisters.EventLister, window time.Duration, now time.Time) *detect.EventIndex {
+	events, err := lister.List(labels.Everything())
+	if err != nil {
+		return nil
+	}
+	return detect.NewEventIndex(events, window, now)
+}
+
+// eventWindow is how long an event stays relevant after it was last seen
+func eventWindow(monitoring *config.MonitoringConfig) time.Duration {
+	return time.Duration(monitoring.Events.Window) * time.Minute
+}
@@ -0,0 +1,606 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"pod-error-monitor/config"
+
+	"github.com/gorilla/mux"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// statsBucket holds the namespace stats per cluster, written every snapshot interval
+	statsBucket = []byte("stats")
+	// checkpointsBucket holds the complete state per cluster, written every checkpoint interval
+	checkpointsBucket = []byte("checkpoints")
+	// eventsBucket holds every pod error that was added, resolved or changed
+	eventsBucket = []byte("events")
+)
+
+// historyPruneInterval is how often records past the retention are deleted
+const historyPruneInterval = time.Hour
+
+// HistoryEvent records a pod error transition
+type HistoryEvent struct {
+	Time  time.Time `json:"time"`
+	Type  string    `json:"type"`
+	Error PodError  `json:"error"`
+}
+
+// StatsPoint is the stats of a namespace at a point in time
+type StatsPoint struct {
+	Time  time.Time      `json:"time"`
+	Stats NamespaceStats `json:"stats"`
+}
+
+// NamespaceHistory is the response of the namespace time-range query
+type NamespaceHistory struct {
+	Namespace string         `json:"namespace"`
+	From      time.Time      `json:"from"`
+	To        time.Time      `json:"to"`
+	Stats     []StatsPoint   `json:"stats"`
+	Events    []HistoryEvent `json:"events"`
+}
+
+// HistoryState is the reconstructed cluster state at a point in time
+type HistoryState struct {
+	Time   time.Time        `json:"time"`
+	Stats  []NamespaceStats `json:"stats"`
+	Errors []PodError       `json:"errors"`
+}
+
+type statsRecord struct {
+	Time    time.Time        `json:"time"`
+	Cluster string           `json:"cluster"`
+	Stats   []NamespaceStats `json:"stats"`
+}
+
+type checkpointRecord struct {
+	Time    time.Time        `json:"time"`
+	Cluster string           `json:"cluster"`
+	Stats   []NamespaceStats `json:"stats"`
+	Errors  []PodError       `json:"errors"`
+	// Removed marks the end of a cluster that is no longer monitored
+	Removed bool `json:"removed,omitempty"`
+}
+
+// historyStore persists the states published by the pod cache in a bbolt
+// database. Stats are sampled, error transitions are stored as they happen
+// and periodic checkpoints bound the replay needed for point-in-time queries.
+type historyStore struct {
+	db                 *bolt.DB
+	retention          time.Duration
+	snapshotInterval   time.Duration
+	checkpointInterval time.Duration
+	stopCh             chan struct{}
+
+	mu             sync.Mutex
+	errors         map[string]map[string]PodError
+	lastSnapshot   map[string]time.Time
+	lastCheckpoint map[string]time.Time
+}
+
+func openHistoryStore(cfg *config.HistoryConfig) (*historyStore, error) {
+	db, err := bolt.Open(cfg.Path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening history database: %v", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{statsBucket, checkpointsBucket, eventsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error initializing history database: %v", err)
+	}
+
+	h := &historyStore{
+		db:                 db,
+		retention:          time.Duration(cfg.Retention) * time.Hour,
+		snapshotInterval:   time.Duration(cfg.SnapshotInterval) * time.Second,
+		checkpointInterval: time.Duration(cfg.CheckpointInterval) * time.Minute,
+		stopCh:             make(chan struct{}),
+		errors:             make(map[string]map[string]PodError),
+		lastSnapshot:       make(map[string]time.Time),
+		lastCheckpoint:     make(map[string]time.Time),
+	}
+	go h.pruneLoop()
+
+	return h, nil
+}
+
+// Close stops the pruning loop and closes the database
+func (h *historyStore) Close() error {
+	close(h.stopCh)
+	return h.db.Close()
+}
+
+// record stores the error transitions of cluster since the previous call
+// and, when due, a stats snapshot and an error checkpoint. The state only
+// moves on once it is written, so the transitions of a failed write are
+// stored with the next call.
+func (h *historyStore) record(cluster string, stats []NamespaceStats, errors []PodError) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	previous, seen := h.errors[cluster]
+	first := !seen
+	added, resolved, changed, current := diffPodErrors(previous, errors, sameErrorState)
+	snapshotDue := first || now.Sub(h.lastSnapshot[cluster]) >= h.snapshotInterval
+	checkpointDue := first || now.Sub(h.lastCheckpoint[cluster]) >= h.checkpointInterval
+	if len(added) == 0 && len(resolved) == 0 && len(changed) == 0 && !snapshotDue && !checkpointDue {
+		return
+	}
+
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		// The first state after a start has no reference, so it only gets a
+		// checkpoint instead of reporting every existing error as new
+		if !first {
+			events := tx.Bucket(eventsBucket)
+			for _, group := range []struct {
+				eventType string
+				errors    []PodError
+			}{{"added", added}, {"resolved", resolved}, {"changed", changed}} {
+				for _, e := range group.errors {
+					seq, err := events.NextSequence()
+					if err != nil {
+						return err
+					}
+					if err := putJSON(events, eventKey(now, seq), HistoryEvent{Time: now, Type: group.eventType, Error: e}); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		if snapshotDue {
+			bucket := tx.Bucket(statsBucket)
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			if err := putJSON(bucket, eventKey(now, seq), statsRecord{Time: now, Cluster: cluster, Stats: stats}); err != nil {
+				return err
+			}
+		}
+
+		if checkpointDue {
+			checkpoints := tx.Bucket(checkpointsBucket)
+			seq, err := checkpoints.NextSequence()
+			if err != nil {
+				return err
+			}
+			record := checkpointRecord{Time: now, Cluster: cluster, Stats: stats, Errors: errors}
+			if err := putJSON(checkpoints, eventKey(now, seq), record); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error recording history: %v", err)
+		return
+	}
+
+	h.errors[cluster] = current
+	if snapshotDue {
+		h.lastSnapshot[cluster] = now
+	}
+	if checkpointDue {
+		h.lastCheckpoint[cluster] = now
+	}
+}
+
+// sameErrorState reports whether two versions of an error describe the same
+// state. The events, restart count and last crash of a crash loop move with
+// every restart, which would fill the history with changes of nothing.
+func sameErrorState(a, b PodError) bool {
+	a.Events, b.Events = nil, nil
+	a.RestartCount, b.RestartCount = 0, 0
+	a.FinishedAt, b.FinishedAt = "", ""
+	return reflect.DeepEqual(a, b)
+}
+
+// removeCluster ends the history of a cluster that is no longer monitored,
+// so states after now leave it out. A cluster monitored again starts over
+// with a checkpoint.
+func (h *historyStore) removeCluster(cluster string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, seen := h.errors[cluster]; !seen {
+		return
+	}
+	delete(h.errors, cluster)
+	delete(h.lastSnapshot, cluster)
+	delete(h.lastCheckpoint, cluster)
+
+	if err := h.putRemoved(cluster, time.Now()); err != nil {
+		log.Printf("Error recording history: %v", err)
+	}
+}
+
+// retainClusters ends the history of the clusters recorded before a restart
+// that are not among names, such as contexts a dashboard session browsed to
+func (h *historyStore) retainClusters(names []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	retained := make(map[string]bool, len(names))
+	for _, name := range names {
+		retained[name] = true
+	}
+
+	// The last checkpoint of every cluster tells whether it already ended
+	latest := make(map[string]bool)
+	err := h.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(checkpointsBucket).ForEach(func(k, data []byte) error {
+			var checkpoint checkpointRecord
+			if err := json.Unmarshal(data, &checkpoint); err != nil {
+				return err
+			}
+			latest[checkpoint.Cluster] = checkpoint.Removed
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("Error reading history: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for cluster, removed := range latest {
+		if removed || retained[cluster] {
+			continue
+		}
+		if err := h.putRemoved(cluster, now); err != nil {
+			log.Printf("Error recording history: %v", err)
+		}
+	}
+}
+
+// putRemoved writes the checkpoint that ends the history of cluster
+func (h *historyStore) putRemoved(cluster string, now time.Time) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		checkpoints := tx.Bucket(checkpointsBucket)
+		seq, err := checkpoints.NextSequence()
+		if err != nil {
+			return err
+		}
+		return putJSON(checkpoints, eventKey(now, seq), checkpointRecord{Time: now, Cluster: cluster, Removed: true})
+	})
+}
+
+// namespaceHistory returns the stats samples and error events of a namespace
+// between from and to, optionally limited to one cluster
+func (h *historyStore) namespaceHistory(cluster, namespace string, from, to time.Time) (*NamespaceHistory, error) {
+	result := &NamespaceHistory{
+		Namespace: namespace,
+		From:      from,
+		To:        to,
+		Stats:     []StatsPoint{},
+		Events:    []HistoryEvent{},
+	}
+
+	err := h.db.View(func(tx *bolt.Tx) error {
+		err := scanRange(tx.Bucket(statsBucket), from, to, func(data []byte) error {
+			var record statsRecord
+			if err := json.Unmarshal(data, &record); err != nil {
+				return err
+			}
+			if cluster != "" && record.Cluster != cluster {
+				return nil
+			}
+			for _, ns := range record.Stats {
+				if ns.Name == namespace {
+					result.Stats = append(result.Stats, StatsPoint{Time: record.Time, Stats: ns})
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return scanRange(tx.Bucket(eventsBucket), from, to, func(data []byte) error {
+			var event HistoryEvent
+			if err := json.Unmarshal(data, &event); err != nil {
+				return err
+			}
+			if event.Error.Namespace == namespace && (cluster == "" || event.Error.Cluster == cluster) {
+				result.Events = append(result.Events, event)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// stateAt reconstructs the state at t. For every cluster it starts from the
+// last checkpoint before t and applies the stats and events recorded since;
+// clusters whose last checkpoint removed them are left out. It returns nil
+// when no checkpoint precedes t.
+func (h *historyStore) stateAt(t time.Time) (*HistoryState, error) {
+	var state *HistoryState
+
+	err := h.db.View(func(tx *bolt.Tx) error {
+		checkpoints := make(map[string]checkpointRecord)
+		err := scanRange(tx.Bucket(checkpointsBucket), time.Unix(0, 0), t, func(data []byte) error {
+			var checkpoint checkpointRecord
+			if err := json.Unmarshal(data, &checkpoint); err != nil {
+				return err
+			}
+			checkpoints[checkpoint.Cluster] = checkpoint
+			return nil
+		})
+		if err != nil || len(checkpoints) == 0 {
+			return err
+		}
+		for cluster, checkpoint := range checkpoints {
+			if checkpoint.Removed {
+				delete(checkpoints, cluster)
+			}
+		}
+
+		start := t
+		stats := make(map[string][]NamespaceStats)
+		errors := make(map[string]PodError)
+		for cluster, checkpoint := range checkpoints {
+			if checkpoint.Time.Before(start) {
+				start = checkpoint.Time
+			}
+			stats[cluster] = checkpoint.Stats
+			for _, e := range checkpoint.Errors {
+				errors[podErrorKey(e)] = e
+			}
+		}
+
+		// Records at the checkpoint time are already part of the checkpoint
+		after := func(cluster string, recorded time.Time) bool {
+			checkpoint, exists := checkpoints[cluster]
+			return exists && recorded.After(checkpoint.Time)
+		}
+
+		err = scanRange(tx.Bucket(statsBucket), start, t, func(data []byte) error {
+			var record statsRecord
+			if err := json.Unmarshal(data, &record); err != nil {
+				return err
+			}
+			if after(record.Cluster, record.Time) {
+				stats[record.Cluster] = record.Stats
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = scanRange(tx.Bucket(eventsBucket), start, t, func(data []byte) error {
+			var event HistoryEvent
+			if err := json.Unmarshal(data, &event); err != nil {
+				return err
+			}
+			if !after(event.Error.Cluster, event.Time) {
+				return nil
+			}
+			if event.Type == "resolved" {
+				delete(errors, podErrorKey(event.Error))
+			} else {
+				errors[podErrorKey(event.Error)] = event.Error
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		state = &HistoryState{
+			Time:   t,
+			Stats:  []NamespaceStats{},
+			Errors: make([]PodError, 0, len(errors)),
+		}
+		for _, clusterStats := range stats {
+			state.Stats = append(state.Stats, clusterStats...)
+		}
+		sort.Slice(state.Stats, func(i, j int) bool {
+			return state.Stats[i].Score > state.Stats[j].Score
+		})
+		for _, e := range errors {
+			state.Errors = append(state.Errors, e)
+		}
+		sort.Slice(state.Errors, func(i, j int) bool {
+			return podErrorKey(state.Errors[i]) < podErrorKey(state.Errors[j])
+		})
+		return nil
+	})
+
+	return state, err
+}
+
+func (h *historyStore) pruneLoop() {
+	ticker := time.NewTicker(historyPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		h.prune()
+		select {
+		case <-h.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// prune deletes every record older than the retention
+func (h *historyStore) prune() {
+	cutoff := timeKey(time.Now().Add(-h.retention))
+
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{statsBucket, checkpointsBucket, eventsBucket} {
+			c := tx.Bucket(name).Cursor()
+			for k, _ := c.First(); k != nil && string(k[:8]) < string(cutoff); k, _ = c.First() {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error pruning history: %v", err)
+	}
+}
+
+// timeKey encodes t so that keys sort chronologically
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return key
+}
+
+// eventKey appends a sequence to the time so events recorded together stay unique
+func eventKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+func putJSON(bucket *bolt.Bucket, key []byte, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, data)
+}
+
+// scanRange calls fn for every record between from and to, inclusive
+func scanRange(bucket *bolt.Bucket, from, to time.Time, fn func(data []byte) error) error {
+	end := timeKey(to)
+	c := bucket.Cursor()
+	for k, v := c.Seek(timeKey(from)); k != nil && string(k[:8]) <= string(end); k, v = c.Next() {
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseTimeParam accepts RFC 3339 timestamps or Unix seconds
+func parseTimeParam(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: use RFC 3339 or Unix seconds", value)
+	}
+	return t, nil
+}
+
+func (s *Server) getNamespaceHistory(w http.ResponseWriter, r *http.Request) {
+	if s.history == nil {
+		http.Error(w, "History is disabled", http.StatusNotFound)
+		return
+	}
+
+	vars := mux.Vars(r)
+	namespace := vars["namespace"]
+
+	now := time.Now()
+	to, err := parseTimeParam(r.URL.Query().Get("to"), now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, err := parseTimeParam(r.URL.Query().Get("from"), to.Add(-24*time.Hour))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if from.After(to) {
+		http.Error(w, "from must not be after to", http.StatusBadRequest)
+		return
+	}
+
+	history, err := s.history.namespaceHistory(s.requestedCluster(r), namespace, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
+
+func (s *Server) getHistoryState(w http.ResponseWriter, r *http.Request) {
+	if s.history == nil {
+		http.Error(w, "History is disabled", http.StatusNotFound)
+		return
+	}
+
+	at, err := parseTimeParam(r.URL.Query().Get("at"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	state, err := s.history.stateAt(at)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if state == nil {
+		http.Error(w, "No history recorded before the requested time", http.StatusNotFound)
+		return
+	}
+
+	// Like the live endpoints, report on the selected context or else on the
+	// default clusters
+	clusters := s.defaultClusterNames()
+	if name := s.requestedCluster(r); name != "" {
+		clusters = []string{name}
+	}
+	filter := streamFilter{
+		clusters:  clusterFilter(clusters...),
+		namespace: r.URL.Query().Get("namespace"),
+	}
+	stats := []NamespaceStats{}
+	for _, ns := range state.Stats {
+		if filter.includesCluster(ns.Cluster) && (filter.namespace == "" || ns.Name == filter.namespace) {
+			stats = append(stats, ns)
+		}
+	}
+	state.Stats = stats
+	state.Errors = filter.filterErrors(state.Errors)
+	if state.Errors == nil {
+		state.Errors = []PodError{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pod-error-monitor/detect"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestDiffPodErrors(t *testing.T) {
+	base := crashLoop("shop", "api-1")
+	base.Cluster = "prod"
+	base.RestartCount = 4
+	base.FinishedAt = "2026-01-01T12:00:00Z"
+	base.Events = []detect.PodEvent{{Reason: "BackOff", Count: 3, LastSeen: "2026-01-01T12:00:00Z"}}
+
+	bumped := base
+	bumped.RestartCount = 5
+	bumped.FinishedAt = "2026-01-01T12:05:00Z"
+	bumped.Events = []detect.PodEvent{{Reason: "BackOff", Count: 4, LastSeen: "2026-01-01T12:05:00Z"}}
+
+	reworded := base
+	reworded.ErrorMessage = "back-off 5m0s restarting failed container"
+
+	other := crashLoop("shop", "api-2")
+	other.Cluster = "prod"
+
+	tests := []struct {
+		name         string
+		previous     []PodError
+		errors       []PodError
+		same         func(a, b PodError) bool
+		wantAdded    int
+		wantResolved int
+		wantChanged  int
+	}{
+		{
+			name:     "unchanged",
+			previous: []PodError{base},
+			errors:   []PodError{base},
+			same:     sameErrorState,
+		},
+		{
+			name:     "events and restarts move in the history",
+			previous: []PodError{base},
+			errors:   []PodError{bumped},
+			same:     sameErrorState,
+		},
+		{
+			name:        "events and restarts move in the stream",
+			previous:    []PodError{base},
+			errors:      []PodError{bumped},
+			same:        samePodError,
+			wantChanged: 1,
+		},
+		{
+			name:        "message changes",
+			previous:    []PodError{base},
+			errors:      []PodError{reworded},
+			same:        sameErrorState,
+			wantChanged: 1,
+		},
+		{
+			name:         "one resolved, one added",
+			previous:     []PodError{base},
+			errors:       []PodError{other},
+			same:         sameErrorState,
+			wantAdded:    1,
+			wantResolved: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := make(map[string]PodError)
+			for _, e := range tt.previous {
+				previous[podErrorKey(e)] = e
+			}
+			added, resolved, changed, current := diffPodErrors(previous, tt.errors, tt.same)
+			if len(added) != tt.wantAdded || len(resolved) != tt.wantResolved || len(changed) != tt.wantChanged {
+				t.Errorf("added %d, resolved %d, changed %d, want %d, %d, %d",
+					len(added), len(resolved), len(changed), tt.wantAdded, tt.wantResolved, tt.wantChanged)
+			}
+			if len(current) != len(tt.errors) {
+				t.Errorf("current holds %d errors, want %d", len(current), len(tt.errors))
+			}
+		})
+	}
+}
+
+// historyError returns the crash loop of a pod in cluster with a message
+func historyError(cluster, pod, message string) PodError {
+	e := crashLoop("shop", pod)
+	e.Cluster = cluster
+	e.ErrorMessage = message
+	return e
+}
+
+func TestHistoryStateAt(t *testing.T) {
+	history := newTestHistory(t)
+	before := time.Now()
+
+	// Each step records the states of the clusters, after which the replayed
+	// state must hold the wanted messages by pod
+	steps := []struct {
+		name   string
+		record map[string][]PodError
+		remove string
+		want   map[string]string
+	}{
+		{
+			name: "checkpoint",
+			record: map[string][]PodError{
+				"prod":    {historyError("prod", "api-1", "crashed")},
+				"staging": {historyError("staging", "web-1", "crashed")},
+			},
+			want: map[string]string{"prod/api-1": "crashed", "staging/web-1": "crashed"},
+		},
+		{
+			name:   "added",
+			record: map[string][]PodError{"prod": {historyError("prod", "api-1", "crashed"), historyError("prod", "api-2", "crashed")}},
+			want:   map[string]string{"prod/api-1": "crashed", "prod/api-2": "crashed", "staging/web-1": "crashed"},
+		},
+		{
+			name:   "changed and resolved",
+			record: map[string][]PodError{"prod": {historyError("prod", "api-2", "crashed again")}},
+			want:   map[string]string{"prod/api-2": "crashed again", "staging/web-1": "crashed"},
+		},
+		{
+			name:   "evicted cluster",
+			remove: "staging",
+			want:   map[string]string{"prod/api-2": "crashed again"},
+		},
+		{
+			name:   "cluster monitored again",
+			record: map[string][]PodError{"staging": {historyError("staging", "web-2", "crashed")}},
+			want:   map[string]string{"prod/api-2": "crashed again", "staging/web-2": "crashed"},
+		},
+	}
+
+	var times []time.Time
+	for _, step := range steps {
+		for cluster, errors := range step.record {
+			history.record(cluster, nil, errors)
+		}
+		if step.remove != "" {
+			history.removeCluster(step.remove)
+		}
+		times = append(times, time.Now())
+	}
+
+	if state, err := history.stateAt(before); err != nil || state != nil {
+		t.Errorf("state before the first checkpoint = %+v, %v, want none", state, err)
+	}
+
+	// Every step replays from the first checkpoint
+	for i, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			state, err := history.stateAt(times[i])
+			if err != nil || state == nil {
+				t.Fatalf("stateAt: %+v, %v", state, err)
+			}
+			got := make(map[string]string)
+			for _, e := range state.Errors {
+				got[e.Cluster+"/"+e.PodName] = e.ErrorMessage
+			}
+			if len(got) != len(step.want) {
+				t.Fatalf("errors = %v, want %v", got, step.want)
+			}
+			for key, message := range step.want {
+				if got[key] != message {
+					t.Errorf("errors = %v, want %v", got, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHistoryRetainClusters(t *testing.T) {
+	history := newTestHistory(t)
+	history.record("prod", nil, []PodError{historyError("prod", "api-1", "crashed")})
+	history.record("browsed", nil, []PodError{historyError("browsed", "web-1", "crashed")})
+
+	// After a restart only prod is monitored
+	history.retainClusters([]string{"prod"})
+
+	state, err := history.stateAt(time.Now())
+	if err != nil || state == nil {
+		t.Fatalf("stateAt: %+v, %v", state, err)
+	}
+	if len(state.Errors) != 1 || state.Errors[0].Cluster != "prod" {
+		t.Errorf("errors = %+v, want the one of prod", state.Errors)
+	}
+}
+
+// lastTxID returns the ID of the last committed write transaction
+func lastTxID(t *testing.T, db *bolt.DB) int {
+	t.Helper()
+
+	var id int
+	if err := db.View(func(tx *bolt.Tx) error {
+		id = tx.ID()
+		return nil
+	}); err != nil {
+		t.Fatalf("View: %v", err)
+	}
+	return id
+}
+
+func TestHistoryRecordWrites(t *testing.T) {
+	history := newTestHistory(t)
+	before := time.Now()
+	history.record("prod", nil, []PodError{historyError("prod", "api-1", "crashed")})
+
+	// Nothing changed and nothing is due, so nothing is written
+	txID := lastTxID(t, history.db)
+	history.record("prod", nil, []PodError{historyError("prod", "api-1", "crashed")})
+	if got := lastTxID(t, history.db); got != txID {
+		t.Errorf("unchanged state wrote transaction %d after %d", got, txID)
+	}
+
+	// A failed write keeps its transitions for the next call. The copy
+	// writes to a closed database and leaves the pruning loop alone.
+	closed, err := bolt.Open(filepath.Join(t.TempDir(), "closed.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open: %v", err)
+	}
+	closed.Close()
+	failing := &historyStore{
+		db:                 closed,
+		snapshotInterval:   history.snapshotInterval,
+		checkpointInterval: history.checkpointInterval,
+		errors:             history.errors,
+		lastSnapshot:       history.lastSnapshot,
+		lastCheckpoint:     history.lastCheckpoint,
+	}
+	added := []PodError{historyError("prod", "api-1", "crashed"), historyError("prod", "api-2", "crashed")}
+	failing.record("prod", nil, added)
+	history.record("prod", nil, added)
+
+	result, err := history.namespaceHistory("prod", "shop", before, time.Now())
+	if err != nil {
+		t.Fatalf("namespaceHistory: %v", err)
+	}
+	if len(result.Events) != 1 || result.Events[0].Type != "added" || result.Events[0].Error.PodName != "api-2" {
+		t.Errorf("events = %+v, want api-2 added", result.Events)
+	}
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// incidentsBucket holds every incident keyed by its ID
+var incidentsBucket = []byte("incidents")
+
+const (
+	// incidentPruneInterval is how often resolved incidents past the retention are dropped
+	incidentPruneInterval = time.Hour
+	// incidentPersistInterval is how often an incident that only got older is
+	// written back to refresh its LastSeen
+	incidentPersistInterval = 5 * time.Minute
+	// incidentSweepInterval is how often incidents whose error is gone are
+	// checked for the end of their grace period
+	incidentSweepInterval = time.Minute
+)
+
+const (
+	IncidentOpen     = "open"
+	IncidentOngoing  = "ongoing"
+	IncidentResolved = "resolved"
+)
+
+// Incident is a pod error tracked over its lifetime. Errors with the same
+// fingerprint (cluster, namespace, owner, container and error type) belong
+// to one incident, so all failing replicas of a workload share it.
+type Incident struct {
+	ID          string     `json:"id"`
+	Fingerprint string     `json:"fingerprint"`
+	Cluster     string     `json:"cluster"`
+	Namespace   string     `json:"namespace"`
+	Owner       string     `json:"owner"`
+	Container   string     `json:"containerName"`
+	ErrorType   string     `json:"errorType"`
+	State       string     `json:"state"`
+	FirstSeen   time.Time  `json:"firstSeen"`
+	LastSeen    time.Time  `json:"lastSeen"`
+	ResolvedAt  *time.Time `json:"resolvedAt,omitempty"`
+	Occurrence  int        `json:"occurrence"`
+	Pods        []string   `json:"pods"`
+	LastMessage string     `json:"lastMessage"`
+
+	// missingSince is when the error was last found gone, zero while present
+	missingSince time.Time
+	// persistedAt is when the incident was last written to the database
+	persistedAt time.Time
+}
+
+// NamespaceReliability summarizes the incidents of a namespace in a cluster
+type NamespaceReliability struct {
+	Cluster     string  `json:"cluster"`
+	Namespace   string  `json:"namespace"`
+	Incidents   int     `json:"incidents"`
+	Open        int     `json:"open"`
+	Resolved    int     `json:"resolved"`
+	Recurrences int     `json:"recurrences"`
+	MTTRSeconds float64 `json:"mttrSeconds"`
+}
+
+// IncidentReport is the response of /api/incidents
+type IncidentReport struct {
+	From       time.Time              `json:"from"`
+	To         time.Time              `json:"to"`
+	Incidents  []Incident             `json:"incidents"`
+	Namespaces []NamespaceReliability `json:"namespaces"`
+}
+
+// incidentTracker turns the published error lists into incidents. An
+// incident resolves once its error has been gone for the grace period, so a
+// container that crash-loops between restarts keeps one incident. Incidents
+// are persisted in the history database when one is attached.
+type incidentTracker struct {
+	mu          sync.Mutex
+	retention   time.Duration
+	grace       time.Duration
+	db          *bolt.DB
+	incidents   map[string]*Incident
+	active      map[string]*Incident
+	occurrences map[string]int
+	seq         uint64
+	lastPrune   time.Time
+}
+
+func newIncidentTracker(retention, grace time.Duration) *incidentTracker {
+	return &incidentTracker{
+		retention:   retention,
+		grace:       grace,
+		incidents:   make(map[string]*Incident),
+		active:      make(map[string]*Incident),
+		occurrences: make(map[string]int),
+	}
+}
+
+// incidentFingerprint identifies the failure an error belongs to independent
+// of the pod that reports it
+func incidentFingerprint(cluster string, e PodError) string {
+	return cluster + "/" + e.Namespace + "/" + e.Owner + "/" + e.ContainerName + "/" + e.ErrorType
+}
+
+// attach persists incidents in the history database and restores the ones
+// recorded before a restart
+func (t *incidentTracker) attach(history *historyStore) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.db = history.db
+	return t.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(incidentsBucket)
+		if err != nil {
+			return err
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			var incident Incident
+			if err := json.Unmarshal(v, &incident); err != nil {
+				return err
+			}
+			t.incidents[incident.ID] = &incident
+			if incident.State != IncidentResolved {
+				t.active[incident.Fingerprint] = &incident
+			}
+			if incident.Occurrence > t.occurrences[incident.Fingerprint] {
+				t.occurrences[incident.Fingerprint] = incident.Occurrence
+			}
+			return nil
+		})
+	})
+}
+
+// update opens and continues the incidents of cluster based on the errors
+// currently present and resolves the ones gone for the grace period
+func (t *incidentTracker) update(cluster string, errors []PodError, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current := make(map[string][]PodError)
+	for _, e := range errors {
+		fingerprint := incidentFingerprint(cluster, e)
+		current[fingerprint] = append(current[fingerprint], e)
+	}
+
+	var changed []*Incident
+	for fingerprint, group := range current {
+		pods := make([]string, 0, len(group))
+		for _, e := range group {
+			if e.PodName != "" {
+				pods = append(pods, e.PodName)
+			}
+		}
+		sort.Strings(pods)
+
+		incident, exists := t.active[fingerprint]
+		if !exists {
+			t.seq++
+			t.occurrences[fingerprint]++
+			incident = &Incident{
+				ID:          hex.EncodeToString(eventKey(now, t.seq)),
+				Fingerprint: fingerprint,
+				Cluster:     cluster,
+				Namespace:   group[0].Namespace,
+				Owner:       group[0].Owner,
+				Container:   group[0].ContainerName,
+				ErrorType:   group[0].ErrorType,
+				State:       IncidentOpen,
+				FirstSeen:   now,
+				Occurrence:  t.occurrences[fingerprint],
+			}
+			t.incidents[incident.ID] = incident
+			t.active[fingerprint] = incident
+		}
+
+		state := IncidentOpen
+		if exists {
+			state = IncidentOngoing
+		}
+
+		// Only a change of state, pods or message is worth a write; LastSeen
+		// is refreshed on disk every persist interval
+		modified := !exists || incident.State != state ||
+			incident.LastMessage != group[0].ErrorMessage || !equalStrings(incident.Pods, pods)
+		incident.State = state
+		incident.missingSince = time.Time{}
+		incident.LastSeen = now
+		incident.LastMessage = group[0].ErrorMessage
+		incident.Pods = pods
+		if modified || now.Sub(incident.persistedAt) >= incidentPersistInterval {
+			changed = append(changed, incident)
+		}
+	}
+
+	// Only resolve incidents of this cluster; others are owned by their own cache
+	for fingerprint, incident := range t.active {
+		if incident.Cluster == cluster && incident.missingSince.IsZero() {
+			if _, exists := current[fingerprint]; !exists {
+				incident.missingSince = now
+			}
+		}
+	}
+	changed = append(changed, t.resolveExpired(now)...)
+
+	var pruned []string
+	if now.Sub(t.lastPrune) >= incidentPruneInterval {
+		pruned = t.prune(now)
+		t.lastPrune = now
+	}
+
+	t.persist(changed, pruned, now)
+}
+
+// resolveExpired resolves the incidents whose error has been gone for the
+// grace period and returns them. They resolve as of the time the error went
+// away, which keeps the grace period out of the MTTR.
+func (t *incidentTracker) resolveExpired(now time.Time) []*Incident {
+	var resolved []*Incident
+	for fingerprint, incident := range t.active {
+		if incident.missingSince.IsZero() || now.Sub(incident.missingSince) < t.grace {
+			continue
+		}
+		t.resolve(fingerprint, incident, incident.missingSince)
+		resolved = append(resolved, incident)
+	}
+	return resolved
+}
+
+func (t *incidentTracker) resolve(fingerprint string, incident *Incident, at time.Time) {
+	incident.State = IncidentResolved
+	incident.ResolvedAt = &at
+	incident.missingSince = time.Time{}
+	delete(t.active, fingerprint)
+}
+
+// run resolves the incidents whose grace period ran out while their cluster
+// published nothing new
+func (t *incidentTracker) run() {
+	ticker := time.NewTicker(incidentSweepInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		t.mu.Lock()
+		t.persist(t.resolveExpired(now), nil, now)
+		t.mu.Unlock()
+	}
+}
+
+// removeCluster resolves the active incidents of a cluster that is no
+// longer monitored, since nothing will publish its errors anymore
+func (t *incidentTracker) removeCluster(cluster string, now time.Time) {
+	t.resolveClusters(func(name string) bool { return name == cluster }, now)
+}
+
+// retainClusters resolves the active incidents restored for clusters other
+// than the monitored ones, e.g. contexts a session browsed before a restart
+func (t *incidentTracker) retainClusters(clusters []string, now time.Time) {
+	monitored := make(map[string]bool, len(clusters))
+	for _, name := range clusters {
+		monitored[name] = true
+	}
+	t.resolveClusters(func(name string) bool { return !monitored[name] }, now)
+}
+
+func (t *incidentTracker) resolveClusters(matches func(cluster string) bool, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var changed []*Incident
+	for fingerprint, incident := range t.active {
+		if !matches(incident.Cluster) {
+			continue
+		}
+		resolvedAt := now
+		if !incident.missingSince.IsZero() {
+			resolvedAt = incident.missingSince
+		}
+		t.resolve(fingerprint, incident, resolvedAt)
+		changed = append(changed, incident)
+	}
+	t.persist(changed, nil, now)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// prune drops resolved incidents older than the retention and returns their IDs
+func (t *incidentTracker) prune(now time.Time) []string {
+	var pruned []string
+	for id, incident := range t.incidents {
+		if incident.ResolvedAt != nil && now.Sub(*incident.ResolvedAt) > t.retention {
+			delete(t.incidents, id)
+			pruned = append(pruned, id)
+		}
+	}
+	return pruned
+}
+
+func (t *incidentTracker) persist(changed []*Incident, pruned []string, now time.Time) {
+	if t.db == nil || (len(changed) == 0 && len(pruned) == 0) {
+		return
+	}
+
+	err := t.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(incidentsBucket)
+		for _, incident := range changed {
+			if err := putJSON(bucket, []byte(incident.ID), incident); err != nil {
+				return err
+			}
+		}
+		for _, id := range pruned {
+			if err := bucket.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error persisting incidents: %v", err)
+		return
+	}
+	for _, incident := range changed {
+		incident.persistedAt = now
+	}
+}
+
+// report returns the incidents of the clusters (all when nil) and namespace
+// (all when empty) that were active between from and to, with reliability
+// figures per namespace of each cluster
+func (t *incidentTracker) report(clusters []string, namespace string, from, to time.Time) IncidentReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	report := IncidentReport{
+		From:       from,
+		To:         to,
+		Incidents:  []Incident{},
+		Namespaces: []NamespaceReliability{},
+	}
+
+	inClusters := make(map[string]bool, len(clusters))
+	for _, name := range clusters {
+		inClusters[name] = true
+	}
+
+	for _, incident := range t.incidents {
+		if clusters != nil && !inClusters[incident.Cluster] {
+			continue
+		}
+		if namespace != "" && incident.Namespace != namespace {
+			continue
+		}
+		if incident.FirstSeen.After(to) || (incident.ResolvedAt != nil && incident.ResolvedAt.Before(from)) {
+			continue
+		}
+		copied := *incident
+		copied.Pods = append([]string(nil), incident.Pods...)
+		report.Incidents = append(report.Incidents, copied)
+	}
+	sort.Slice(report.Incidents, func(i, j int) bool {
+		return report.Incidents[i].FirstSeen.After(report.Incidents[j].FirstSeen)
+	})
+
+	byNamespace := make(map[string]*NamespaceReliability)
+	recovery := make(map[string]time.Duration)
+	for _, incident := range report.Incidents {
+		key := incident.Cluster + "/" + incident.Namespace
+		summary, exists := byNamespace[key]
+		if !exists {
+			summary = &NamespaceReliability{Cluster: incident.Cluster, Namespace: incident.Namespace}
+			byNamespace[key] = summary
+		}
+
+		summary.Incidents++
+		if incident.Occurrence > 1 {
+			summary.Recurrences++
+		}
+		if incident.ResolvedAt != nil {
+			summary.Resolved++
+			recovery[key] += incident.ResolvedAt.Sub(incident.FirstSeen)
+		} else {
+			summary.Open++
+		}
+	}
+
+	for key, summary := range byNamespace {
+		if summary.Resolved > 0 {
+			summary.MTTRSeconds = (recovery[key] / time.Duration(summary.Resolved)).Seconds()
+		}
+		report.Namespaces = append(report.Namespaces, *summary)
+	}
+	sort.Slice(report.Namespaces, func(i, j int) bool {
+		a, b := report.Namespaces[i], report.Namespaces[j]
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+		return a.Namespace < b.Namespace
+	})
+
+	return report
+}
+
+func (s *Server) getIncidents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	now := time.Now()
+	to, err := parseTimeParam(query.Get("to"), now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, err := parseTimeParam(query.Get("from"), to.Add(-7*24*time.Hour))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if from.After(to) {
+		http.Error(w, "from must not be after to", http.StatusBadRequest)
+		return
+	}
+
+	// Like the live endpoints, report on the selected context or else on the
+	// default clusters
+	clusters := s.defaultClusterNames()
+	if name := s.requestedCluster(r); name != "" {
+		clusters = []string{name}
+	}
+	report := s.incidents.report(clusters, query.Get("namespace"), from, to)
+
+	if state := query.Get("state"); state != "" {
+		filtered := []Incident{}
+		for _, incident := range report.Incidents {
+			if incident.State == state {
+				filtered = append(filtered, incident)
+			}
+		}
+		report.Incidents = filtered
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
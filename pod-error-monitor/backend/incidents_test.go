@@ -0,0 +1,224 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pod-error-monitor/config"
+)
+
+// newTestHistory opens a history database in a temporary directory
+func newTestHistory(t *testing.T) *historyStore {
+	t.Helper()
+
+	history, err := openHistoryStore(&config.HistoryConfig{
+		Path:               filepath.Join(t.TempDir(), "history.db"),
+		Retention:          168,
+		SnapshotInterval:   60,
+		CheckpointInterval: 60,
+	})
+	if err != nil {
+		t.Fatalf("openHistoryStore: %v", err)
+	}
+	t.Cleanup(func() { history.Close() })
+	return history
+}
+
+func crashLoop(namespace, pod string) PodError {
+	return PodError{
+		Namespace:     namespace,
+		PodName:       pod,
+		ErrorType:     "CrashLoopBackOff",
+		ErrorMessage:  "back-off restarting failed container",
+		ContainerName: "app",
+		Owner:         "Deployment/api",
+	}
+}
+
+func TestIncidentTrackerUpdate(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	present := []PodError{crashLoop("shop", "api-1")}
+
+	tests := []struct {
+		name        string
+		cycles      [][]PodError // published once a minute
+		wantState   string
+		wantCount   int
+		wantResolve time.Duration // resolved at start plus this, if resolved
+	}{
+		{
+			name:      "first sighting opens",
+			cycles:    [][]PodError{present},
+			wantState: IncidentOpen,
+			wantCount: 1,
+		},
+		{
+			name:      "persisting error is ongoing",
+			cycles:    [][]PodError{present, present},
+			wantState: IncidentOngoing,
+			wantCount: 1,
+		},
+		{
+			name:      "crash loop flapping within the grace period keeps one incident",
+			cycles:    [][]PodError{present, nil, present, nil, nil, present},
+			wantState: IncidentOngoing,
+			wantCount: 1,
+		},
+		{
+			name:        "error gone for the grace period resolves as of its disappearance",
+			cycles:      [][]PodError{present, present, nil, nil, nil, nil, nil, nil},
+			wantState:   IncidentResolved,
+			wantCount:   1,
+			wantResolve: 2 * time.Minute,
+		},
+		{
+			name:      "error returning after resolution opens a new incident",
+			cycles:    [][]PodError{present, nil, nil, nil, nil, nil, nil, present},
+			wantState: IncidentOpen,
+			wantCount: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newIncidentTracker(time.Hour, 5*time.Minute)
+			for i, errors := range tt.cycles {
+				tracker.update("prod", errors, start.Add(time.Duration(i)*time.Minute))
+			}
+
+			report := tracker.report(nil, "", start, start.Add(time.Hour))
+			if len(report.Incidents) != tt.wantCount {
+				t.Fatalf("got %d incidents, want %d", len(report.Incidents), tt.wantCount)
+			}
+			latest := report.Incidents[0]
+			if latest.State != tt.wantState {
+				t.Errorf("state = %s, want %s", latest.State, tt.wantState)
+			}
+			if tt.wantResolve != 0 {
+				if latest.ResolvedAt == nil || !latest.ResolvedAt.Equal(start.Add(tt.wantResolve)) {
+					t.Errorf("resolvedAt = %v, want %v", latest.ResolvedAt, start.Add(tt.wantResolve))
+				}
+			}
+			if tt.wantCount > 1 && latest.Occurrence != tt.wantCount {
+				t.Errorf("occurrence = %d, want %d", latest.Occurrence, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestIncidentTrackerResolvesWithoutUpdates(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tracker := newIncidentTracker(time.Hour, 5*time.Minute)
+	tracker.update("prod", []PodError{crashLoop("shop", "api-1")}, start)
+	tracker.update("prod", nil, start.Add(time.Minute))
+
+	// The cache publishes nothing once its state stops changing
+	tracker.resolveExpired(start.Add(3 * time.Minute))
+	if len(tracker.active) != 1 {
+		t.Fatalf("resolved before the grace period ran out")
+	}
+	tracker.resolveExpired(start.Add(6 * time.Minute))
+	if len(tracker.active) != 0 {
+		t.Fatalf("not resolved after the grace period ran out")
+	}
+}
+
+func TestIncidentTrackerRemoveCluster(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tracker := newIncidentTracker(time.Hour, 5*time.Minute)
+	tracker.update("prod", []PodError{crashLoop("shop", "api-1")}, start)
+	tracker.update("staging", []PodError{crashLoop("shop", "api-1")}, start)
+
+	tracker.removeCluster("staging", start.Add(time.Minute))
+
+	for _, incident := range tracker.report(nil, "", start, start.Add(time.Hour)).Incidents {
+		want := IncidentOpen
+		if incident.Cluster == "staging" {
+			want = IncidentResolved
+		}
+		if incident.State != want {
+			t.Errorf("%s incident is %s, want %s", incident.Cluster, incident.State, want)
+		}
+	}
+}
+
+func TestIncidentReportPerCluster(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tracker := newIncidentTracker(time.Hour, time.Minute)
+	tracker.update("prod", []PodError{crashLoop("shop", "api-1")}, start)
+	tracker.update("staging", []PodError{crashLoop("shop", "api-1")}, start)
+	tracker.update("staging", nil, start.Add(time.Minute))
+	tracker.update("staging", nil, start.Add(2*time.Minute))
+
+	tests := []struct {
+		name     string
+		clusters []string
+		want     []NamespaceReliability
+	}{
+		{
+			name:     "same namespace in two clusters is reported separately",
+			clusters: nil,
+			want: []NamespaceReliability{
+				{Cluster: "prod", Namespace: "shop", Incidents: 1, Open: 1},
+				{Cluster: "staging", Namespace: "shop", Incidents: 1, Resolved: 1, MTTRSeconds: 60},
+			},
+		},
+		{
+			name:     "selected cluster only",
+			clusters: []string{"staging"},
+			want: []NamespaceReliability{
+				{Cluster: "staging", Namespace: "shop", Incidents: 1, Resolved: 1, MTTRSeconds: 60},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := tracker.report(tt.clusters, "", start, start.Add(time.Hour))
+			if len(report.Namespaces) != len(tt.want) {
+				t.Fatalf("got %+v, want %+v", report.Namespaces, tt.want)
+			}
+			for i := range tt.want {
+				if report.Namespaces[i] != tt.want[i] {
+					t.Errorf("namespaces[%d] = %+v, want %+v", i, report.Namespaces[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestIncidentTrackerPersistsChangesOnly(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	history := newTestHistory(t)
+	tracker := newIncidentTracker(time.Hour, 5*time.Minute)
+	if err := tracker.attach(history); err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+
+	errors := []PodError{crashLoop("shop", "api-1")}
+	tracker.update("prod", errors, start)
+	tracker.update("prod", errors, start.Add(time.Second))
+	incident := tracker.active[incidentFingerprint("prod", errors[0])]
+	written := incident.persistedAt
+
+	tracker.update("prod", errors, start.Add(time.Minute))
+	if !incident.persistedAt.Equal(written) {
+		t.Errorf("unchanged incident was written again")
+	}
+
+	tracker.update("prod", append(errors, crashLoop("shop", "api-2")), start.Add(2*time.Minute))
+	if incident.persistedAt.Equal(written) {
+		t.Errorf("incident with a new pod was not written")
+	}
+
+	// A restart restores the incident from the database
+	restored := newIncidentTracker(time.Hour, 5*time.Minute)
+	if err := restored.attach(history); err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+	got := restored.active[incident.Fingerprint]
+	if got == nil || len(got.Pods) != 2 || got.State != IncidentOngoing {
+		t.Errorf("restored incident = %+v", got)
+	}
+}
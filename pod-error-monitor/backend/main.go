@@ -8,28 +8,28 @@ import (
 	"log"
 	"net/http"
 	"sort"
+	"sync"
+	"time"
 
 	"pod-error-monitor/config"
+	"pod-error-monitor/detect"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/cors"
 	v1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
-type PodError struct {
-	Namespace     string `json:"namespace"`
-	PodName       string `json:"podName"`
-	ErrorType     string `json:"errorType"`
-	ErrorMessage  string `json:"errorMessage"`
-	ContainerName string `json:"containerName"`
-	RestartCount  int32  `json:"restartCount"`
-}
+// PodError is a finding of the detectors, as served by the API
+type PodError = detect.Finding
 
 type NamespaceStats struct {
+	Cluster       string  `json:"cluster"`
 	Name          string  `json:"name"`
 	TotalErrors   int     `json:"totalErrors"`
 	Score         float64 `json:"score"`
@@ -37,6 +37,9 @@ type NamespaceStats struct {
 	CrashLoop     int     `json:"crashLoop"`
 	ImagePull     int     `json:"imagePull"`
 	HighRestarts  int     `json:"highRestarts"`
+	Pending       int     `json:"pending"`
+	Rollout       int     `json:"rollout"`
+	Custom        int     `json:"custom"`
 	TotalRestarts int32   `json:"totalRestarts"`
 }
 
@@ -45,10 +48,26 @@ type KubeConfig struct {
 	Contexts       []string `json:"contexts"`
 }
 
+// cacheSyncTimeout bounds how long we wait for the initial pod list of a cluster
+const cacheSyncTimeout = 2 * time.Minute
+
 type Server struct {
-	clientset *kubernetes.Clientset
-	config    *clientcmd.ClientConfig
-	appConfig *config.Config
+	mu       sync.RWMutex
+	config   clientcmd.ClientConfig
+	clusters map[string]*cluster
+	// aliases map contexts to the pooled cluster of the same target that
+	// serves them
+	aliases map[string]string
+	// defaultClusters are monitored permanently and served when a request
+	// does not select a context
+	defaultClusters []string
+	defaultContext  string
+	stream          *streamHub
+	history         *historyStore
+	incidents       *incidentTracker
+	notifier        *notifier
+	detectors       *detect.Registry
+	appConfig       *config.Config
 }
 
 func main() {
@@ -61,8 +80,17 @@ func main() {
 		log.Fatalf("Error loading configuration: %v", err)
 	}
 
+	detectors, err := detect.NewRegistry(cfg.Monitoring.Detectors.Disabled)
+	if err != nil {
+		log.Fatalf("Error setting up detectors: %v", err)
+	}
+	for _, rule := range cfg.Monitoring.Rules {
+		detectors.Register(rule.Compiled)
+	}
+
 	var k8sConfig *rest.Config
 	var clientConfig clientcmd.ClientConfig
+	clusterName := "in-cluster"
 
 	if cfg.Kubernetes.UseInCluster {
 		// Get in-cluster config
@@ -81,24 +109,73 @@ func main() {
 		}
 
 		clientConfig = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
-		k8sConfig, err = clientConfig.ClientConfig()
+
+		// The default context is only monitored when no cluster list is configured
+		if len(cfg.Kubernetes.Clusters) == 0 {
+			k8sConfig, err = clientConfig.ClientConfig()
+			if err != nil {
+				log.Fatalf("Error building kubeconfig: %v", err)
+			}
+
+			clusterName = cfg.Kubernetes.DefaultContext
+			if clusterName == "" {
+				rawConfig, err := clientConfig.RawConfig()
+				if err != nil {
+					log.Fatalf("Error reading kubeconfig: %v", err)
+				}
+				clusterName = rawConfig.CurrentContext
+			}
+		}
+	}
+
+	// Initialize server with config
+	server := &Server{
+		config:    clientConfig,
+		clusters:  make(map[string]*cluster),
+		aliases:   make(map[string]string),
+		stream:    newStreamHub(),
+		incidents: newIncidentTracker(time.Duration(cfg.History.Retention)*time.Hour, time.Duration(cfg.Monitoring.ResolveAfter)*time.Minute),
+		detectors: detectors,
+		appConfig: cfg,
+	}
+
+	// Open the history store
+	if cfg.History.Enabled {
+		server.history, err = openHistoryStore(&cfg.History)
 		if err != nil {
-			log.Fatalf("Error building kubeconfig: %v", err)
+			log.Fatalf("Error opening history store: %v", err)
+		}
+		if err := server.incidents.attach(server.history); err != nil {
+			log.Fatalf("Error loading incidents: %v", err)
 		}
 	}
 
-	// Create the clientset
-	clientset, err := kubernetes.NewForConfig(k8sConfig)
-	if err != nil {
-		log.Fatalf("Error creating clientset: %v", err)
+	// Start sending notifications
+	if cfg.Notifications.Enabled {
+		server.notifier = newNotifier(&cfg.Notifications, time.Duration(cfg.Monitoring.ResolveAfter)*time.Minute)
+		go server.notifier.run()
 	}
 
-	// Initialize server with clientset and config
-	server := &Server{
-		clientset: clientset,
-		config:    &clientConfig,
-		appConfig: cfg,
+	// Start monitoring the default clusters
+	if len(cfg.Kubernetes.Clusters) == 0 {
+		server.addCluster(server.newCluster(clusterName, k8sConfig))
+	}
+	for _, clusterConfig := range cfg.Kubernetes.Clusters {
+		restConfig, err := buildClusterConfig(clusterConfig)
+		if err != nil {
+			log.Fatalf("Error building kubeconfig for cluster %s: %v", clusterConfig.Name, err)
+		}
+		server.addCluster(server.newCluster(clusterConfig.Name, restConfig))
+	}
+	if len(server.defaultClusters) == 1 {
+		server.defaultContext = server.defaultClusters[0]
 	}
+	server.incidents.retainClusters(server.defaultClusters, time.Now())
+	if server.history != nil {
+		server.history.retainClusters(server.defaultClusters)
+	}
+	go server.incidents.run()
+	go server.evictIdleClusters()
 
 	// Initialize router
 	r := mux.NewRouter()
@@ -106,13 +183,24 @@ func main() {
 	// API routes
 	r.HandleFunc("/api/namespaces", server.getNamespaceStats).Methods("GET")
 	r.HandleFunc("/api/namespaces/{namespace}/pods", server.getNamespacePodErrors).Methods("GET")
+	r.HandleFunc("/api/namespaces/{namespace}/workloads", server.getNamespaceWorkloads).Methods("GET")
 	r.HandleFunc("/api/contexts", server.getContexts).Methods("GET")
 	r.HandleFunc("/api/contexts/{context}", server.switchContext).Methods("POST")
+	r.HandleFunc("/api/stream", server.streamUpdates).Methods("GET")
+	r.HandleFunc("/api/history/namespaces/{namespace}", server.getNamespaceHistory).Methods("GET")
+	r.HandleFunc("/api/history/state", server.getHistoryState).Methods("GET")
+	r.HandleFunc("/api/incidents", server.getIncidents).Methods("GET")
+	r.HandleFunc("/api/clusters", server.getClusters).Methods("GET")
+
+	// Prometheus metrics
+	prometheus.MustRegister(newStatsCollector(server))
+	r.Handle("/metrics", promhttp.Handler()).Methods("GET")
 
 	// Configure CORS
 	c := cors.New(cors.Options{
-		AllowedOrigins: cfg.Server.CORS.AllowedOrigins,
-		AllowedMethods: cfg.Server.CORS.AllowedMethods,
+		AllowedOrigins:   cfg.Server.CORS.AllowedOrigins,
+		AllowedMethods:   cfg.Server.CORS.AllowedMethods,
+		AllowCredentials: true,
 	})
 
 	// Start server
@@ -121,13 +209,56 @@ func main() {
 	log.Fatal(http.ListenAndServe(addr, c.Handler(r)))
 }
 
+// startPodCache creates a pod cache for the cluster behind clientset and
+// metadataClient that publishes its updates and waits for its initial sync
+func (s *Server) startPodCache(clientset kubernetes.Interface, metadataClient metadata.Interface, cluster string) (*podCache, error) {
+	interval := time.Duration(s.appConfig.Kubernetes.RefreshInterval) * time.Second
+	c := newPodCache(clientset, metadataClient, cluster, &s.appConfig.Monitoring, s.detectors, interval, func(stats []NamespaceStats, errors []PodError) {
+		s.publishUpdate(cluster, stats, errors)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), cacheSyncTimeout)
+	defer cancel()
+	if err := c.Start(ctx); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// buildClusterConfig loads the REST config of a configured cluster
+func buildClusterConfig(cluster config.ClusterConfig) (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = cluster.KubeconfigPath
+	overrides := &clientcmd.ConfigOverrides{
+		CurrentContext: cluster.Context,
+	}
+
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+}
+
+// publishUpdate fans a recomputed state out to the stream, the history,
+// the incident tracker and the notifier
+func (s *Server) publishUpdate(cluster string, stats []NamespaceStats, errors []PodError) {
+	now := time.Now()
+	s.stream.publish(cluster, stats, errors)
+	if s.history != nil {
+		s.history.record(cluster, stats, errors)
+	}
+	s.incidents.update(cluster, errors, now)
+	// Contexts a dashboard session browses to do not send notifications
+	if s.notifier != nil && s.isDefaultCluster(cluster) {
+		s.notifier.update(cluster, stats, errors, now)
+	}
+}
+
 func (s *Server) getContexts(w http.ResponseWriter, r *http.Request) {
 	if s.config == nil {
 		http.Error(w, "Not running with kubeconfig", http.StatusBadRequest)
 		return
 	}
 
-	rawConfig, err := (*s.config).RawConfig()
+	rawConfig, err := s.config.RawConfig()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -137,9 +268,15 @@ func (s *Server) getContexts(w http.ResponseWriter, r *http.Request) {
 	for name := range rawConfig.Contexts {
 		contexts = append(contexts, name)
 	}
+	sort.Strings(contexts)
+
+	currentContext := s.selectedContext(r)
+	if currentContext == "" {
+		currentContext = s.defaultContext
+	}
 
 	response := KubeConfig{
-		CurrentContext: rawConfig.CurrentContext,
+		CurrentContext: currentContext,
 		Contexts:       contexts,
 	}
 
@@ -147,6 +284,8 @@ func (s *Server) getContexts(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// switchContext stores the context as the preference of the caller's
+// session. The kubeconfig on disk is never modified.
 func (s *Server) switchContext(w http.ResponseWriter, r *http.Request) {
 	if s.config == nil {
 		http.Error(w, "Not running with kubeconfig", http.StatusBadRequest)
@@ -156,59 +295,32 @@ func (s *Server) switchContext(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	newContext := vars["context"]
 
-	rawConfig, err := (*s.config).RawConfig()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Validate context exists
-	if _, exists := rawConfig.Contexts[newContext]; !exists {
-		http.Error(w, "Context not found", http.StatusBadRequest)
+	// Validate context exists and start monitoring it
+	if _, err := s.clusterFor(newContext); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Update current context
-	rawConfig.CurrentContext = newContext
-
-	// Create new config
-	configPath := s.appConfig.Kubernetes.KubeconfigPath
-	if err := clientcmd.ModifyConfig(clientcmd.NewDefaultPathOptions(), rawConfig, true); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Create new client config
-	rules := clientcmd.NewDefaultClientConfigLoadingRules()
-	rules.ExplicitPath = configPath
-	overrides := &clientcmd.ConfigOverrides{
-		CurrentContext: newContext,
-	}
-	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
-
-	// Get new rest config
-	config, err := clientConfig.ClientConfig()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Create new clientset
-	clientset, err := kubernetes.NewForConfig(config)
+	rawConfig, err := s.config.RawConfig()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Update server's clientset and config
-	s.clientset = clientset
-	s.config = &clientConfig
+	http.SetCookie(w, &http.Cookie{
+		Name:     contextCookie,
+		Value:    newContext,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
 
 	// Get list of contexts for response
 	contexts := make([]string, 0, len(rawConfig.Contexts))
 	for name := range rawConfig.Contexts {
 		contexts = append(contexts, name)
 	}
+	sort.Strings(contexts)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(KubeConfig{
@@ -218,13 +330,21 @@ func (s *Server) switchContext(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) getNamespaceStats(w http.ResponseWriter, r *http.Request) {
-	pods, err := s.clientset.CoreV1().Pods("").List(context.Background(), metav1.ListOptions{})
+	clusters, err := s.requestClusters(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	stats := calculateNamespaceStats(pods)
+	stats := []NamespaceStats{}
+	for _, c := range clusters {
+		if cache := c.podCache(); cache != nil {
+			stats = append(stats, cache.NamespaceStats()...)
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Score > stats[j].Score
+	})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(stats)
@@ -234,77 +354,57 @@ func (s *Server) getNamespacePodErrors(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	namespace := vars["namespace"]
 
-	pods, err := s.clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{})
+	clusters, err := s.requestClusters(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	errors := getPodErrors(pods)
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(errors)
-}
-
-func calculateNamespaceStats(pods *v1.PodList) []NamespaceStats {
-	statsMap := make(map[string]*NamespaceStats)
-	uniquePodsMap := make(map[string]map[string]bool)
-
-	// Initialize stats for each namespace
-	for _, pod := range pods.Items {
-		if _, exists := statsMap[pod.Namespace]; !exists {
-			statsMap[pod.Namespace] = &NamespaceStats{
-				Name: pod.Namespace,
-			}
-			uniquePodsMap[pod.Namespace] = make(map[string]bool)
+	errors := []PodError{}
+	for _, c := range clusters {
+		cache := c.podCache()
+		if cache == nil {
+			continue
 		}
 
-		stats := statsMap[pod.Namespace]
-
-		if pod.Status.Phase == v1.PodFailed {
-			stats.TotalErrors++
-			uniquePodsMap[pod.Namespace][pod.Name] = true
+		clusterErrors, err := cache.PodErrors(namespace)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+		errors = append(errors, clusterErrors...)
+	}
 
-		for _, containerStatus := range pod.Status.ContainerStatuses {
-			if containerStatus.RestartCount > 5 {
-				stats.TotalErrors++
-				stats.HighRestarts++
-				stats.TotalRestarts += containerStatus.RestartCount
-				uniquePodsMap[pod.Namespace][pod.Name] = true
-			}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(errors)
+}
 
-			if containerStatus.State.Waiting != nil {
-				reason := containerStatus.State.Waiting.Reason
-				switch reason {
-				case "CrashLoopBackOff":
-					stats.CrashLoop++
-					stats.TotalErrors++
-					uniquePodsMap[pod.Namespace][pod.Name] = true
-				case "ImagePullBackOff", "ErrImagePull":
-					stats.ImagePull++
-					stats.TotalErrors++
-					uniquePodsMap[pod.Namespace][pod.Name] = true
-				}
-			}
-		}
+// calculateNamespaceStats tallies the errors of each namespace and scores
+// them with the weights of the namespace
+func calculateNamespaceStats(errors []PodError, monitoring *config.MonitoringConfig) []NamespaceStats {
+	byNamespace := make(map[string][]PodError)
+	for _, e := range errors {
+		byNamespace[e.Namespace] = append(byNamespace[e.Namespace], e)
 	}
 
-	// Calculate final stats and convert to slice
+	options := scoreOptions(monitoring)
 	var results []NamespaceStats
-	for namespace, stats := range statsMap {
-		stats.UniquePods = len(uniquePodsMap[namespace])
-
-		// Calculate score
-		stats.Score = float64(stats.CrashLoop*3+
-			stats.ImagePull*2+
-			stats.HighRestarts*2+
-			(stats.TotalErrors-stats.CrashLoop-stats.ImagePull-stats.HighRestarts)) +
-			float64(stats.TotalRestarts)*0.1
-
-		if stats.TotalErrors > 0 {
-			results = append(results, *stats)
-		}
+	for namespace, nsErrors := range byNamespace {
+		tally := detect.TallyFindings(nsErrors, options)
+		counts := tally.Counts
+		results = append(results, NamespaceStats{
+			Name:          namespace,
+			TotalErrors:   counts.Total,
+			Score:         tally.Score(monitoring.ForNamespace(namespace).ErrorWeights.Weights()),
+			UniquePods:    tally.Pods,
+			CrashLoop:     counts.CrashLoop,
+			ImagePull:     counts.ImagePull,
+			HighRestarts:  counts.HighRestarts,
+			Pending:       counts.Pending,
+			Rollout:       counts.Rollout,
+			Custom:        counts.Custom,
+			TotalRestarts: counts.Restarts,
+		})
 	}
 
 	sort.Slice(results, func(i, j int) bool {
@@ -314,59 +414,45 @@ func calculateNamespaceStats(pods *v1.PodList) []NamespaceStats {
 	return results
 }
 
-func getPodErrors(pods *v1.PodList) []PodError {
-	var errors []PodError
-
-	for _, pod := range pods.Items {
-		if pod.Status.Phase == v1.PodFailed {
-			errors = append(errors, PodError{
-				Namespace:    pod.Namespace,
-				PodName:      pod.Name,
-				ErrorType:    "PodFailed",
-				ErrorMessage: "Pod is in Failed phase",
-			})
-		}
-
-		for _, containerStatus := range pod.Status.ContainerStatuses {
-			if containerStatus.RestartCount > 5 {
-				errors = append(errors, PodError{
-					Namespace:     pod.Namespace,
-					PodName:       pod.Name,
-					ErrorType:     "HighRestartCount",
-					ErrorMessage:  "Container has restarted multiple times",
-					ContainerName: containerStatus.Name,
-					RestartCount:  containerStatus.RestartCount,
-				})
-			}
+// scoreOptions returns how errors are tallied under the configuration
+func scoreOptions(monitoring *config.MonitoringConfig) detect.ScoreOptions {
+	return detect.ScoreOptions{
+		ByWorkload: monitoring.ScoreBy == config.ScoreByWorkload,
+		RuleWeight: monitoring.RuleWeight,
+	}
+}
 
-			if containerStatus.State.Waiting != nil {
-				reason := containerStatus.State.Waiting.Reason
-				if isErrorState(reason) {
-					errors = append(errors, PodError{
-						Namespace:     pod.Namespace,
-						PodName:       pod.Name,
-						ErrorType:     reason,
-						ErrorMessage:  containerStatus.State.Waiting.Message,
-						ContainerName: containerStatus.Name,
-						RestartCount:  containerStatus.RestartCount,
-					})
-				}
-			}
-		}
+// getPodErrors runs the detectors on every pod and on the workloads
+func getPodErrors(pods []*v1.Pod, events *detect.EventIndex, workloads *detect.Workloads, configs detect.ConfigLookup,
+	detectors *detect.Registry, monitoring *config.MonitoringConfig) []PodError {
+	var errors []PodError
+	now := time.Now()
+
+	for _, pod := range pods {
+		errors = append(errors, detectors.Pod(&detect.Input{
+			Pod:       pod,
+			Events:    events.ForPod(pod),
+			Configs:   configs,
+			Workloads: workloads,
+			Settings:  detectorSettings(monitoring, pod.Namespace),
+			Now:       now,
+		})...)
 	}
 
-	return errors
+	// Failing Jobs, CronJobs, Deployments and StatefulSets are errors of
+	// their own, not of a pod
+	return append(errors, detectors.Workloads(workloads, detectorSettings(monitoring, ""), now)...)
 }
 
-func isErrorState(state string) bool {
-	errorStates := map[string]bool{
-		"ImagePullBackOff":     true,
-		"CrashLoopBackOff":     true,
-		"ErrImagePull":         true,
-		"CreateContainerError": true,
-		"InvalidImageName":     true,
-		"ImageInspectError":    true,
-		"ErrImageNeverPull":    true,
-	}
-	return errorStates[state]
+// detectorSettings returns the thresholds the detectors apply in namespace
+func detectorSettings(monitoring *config.MonitoringConfig, namespace string) detect.Settings {
+	nsMonitoring := monitoring.ForNamespace(namespace)
+	return detect.Settings{
+		RestartThreshold:  int32(nsMonitoring.HighRestartThreshold),
+		PendingTimeout:    time.Duration(nsMonitoring.PendingTimeout) * time.Minute,
+		TerminationWindow: time.Duration(monitoring.TerminationWindow) * time.Minute,
+		EventMinCount:     int32(monitoring.Events.MinCount),
+		OverdueMultiple:   monitoring.Jobs.OverdueMultiple,
+		RolloutDeadline:   time.Duration(monitoring.RolloutDeadline) * time.Minute,
+	}
 }
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"pod-error-monitor/config"
+)
+
+// TestNamespaceStatsParity pins the stats of a fixture under the default
+// weights. The CLI test of the same name expects the same stats.
+func TestNamespaceStatsParity(t *testing.T) {
+	errors := []PodError{
+		{Namespace: "shop", PodName: "api-1", ErrorType: "CrashLoopBackOff", RestartCount: 12, Owner: "Deployment/api"},
+		{Namespace: "shop", PodName: "api-1", ErrorType: "HighRestartCount", RestartCount: 12, Owner: "Deployment/api"},
+		{Namespace: "shop", PodName: "api-2", ErrorType: "ImagePullBackOff", Owner: "Deployment/api"},
+		{Namespace: "shop", ErrorType: "JobFailed", Owner: "Job/backup"},
+		{Namespace: "payments", PodName: "pay-1", ErrorType: "Unschedulable", Owner: "Deployment/pay"},
+		{Namespace: "payments", ErrorType: "UnavailableReplicas", Owner: "StatefulSet/db"},
+		{Namespace: "payments", PodName: "pay-2", ErrorType: "OOMKilled", RestartCount: 3, Owner: "Deployment/pay"},
+	}
+	want := []NamespaceStats{
+		{Name: "shop", TotalErrors: 4, Score: 9.2, UniquePods: 2, CrashLoop: 1, ImagePull: 1, HighRestarts: 1, TotalRestarts: 12},
+		{Name: "payments", TotalErrors: 3, Score: 6, UniquePods: 2, Pending: 1, Rollout: 1},
+	}
+
+	monitoring := &config.MonitoringConfig{ErrorWeights: config.DefaultErrorWeights}
+	got := calculateNamespaceStats(errors, monitoring)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+}
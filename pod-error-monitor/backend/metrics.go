@@ -0,0 +1,182 @@
+package main
+
+import (
+	"pod-error-monitor/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "pod_error_monitor"
+
+var (
+	refreshDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "refresh_duration_seconds",
+		Help:      "Time taken to recompute the namespace stats and pod errors of a cluster.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+	}, []string{"cluster"})
+
+	kubernetesAPIErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "kubernetes_api_errors_total",
+		Help:      "Failed Kubernetes API calls, including informer watch errors and health probes.",
+	}, []string{"cluster"})
+
+	notificationsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "notifications_sent_total",
+		Help:      "Alerts delivered to a notification sink.",
+	}, []string{"sink"})
+
+	notificationsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "notifications_failed_total",
+		Help:      "Deliveries to a notification sink that failed or were dropped.",
+	}, []string{"sink"})
+)
+
+func init() {
+	prometheus.MustRegister(refreshDuration, kubernetesAPIErrors, notificationsSent, notificationsFailed)
+}
+
+// namespaceGauge describes one per-namespace gauge derived from NamespaceStats
+type namespaceGauge struct {
+	desc  *prometheus.Desc
+	value func(ns NamespaceStats) float64
+}
+
+func newNamespaceGauge(name, help string, value func(ns NamespaceStats) float64) namespaceGauge {
+	return namespaceGauge{
+		desc:  prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "namespace", name), help, []string{"cluster", "namespace"}, nil),
+		value: value,
+	}
+}
+
+// statsCollector exports the state of the pooled clusters at scrape time,
+// so namespaces without errors disappear instead of going stale
+type statsCollector struct {
+	server  *Server
+	metrics *config.MetricsConfig
+
+	namespaceGauges []namespaceGauge
+	podErrors       *prometheus.Desc
+	cacheAge        *prometheus.Desc
+	clusterUp       *prometheus.Desc
+	dropped         *prometheus.Desc
+	ruleErrors      *prometheus.Desc
+}
+
+func newStatsCollector(s *Server) *statsCollector {
+	errorLabels := []string{"cluster", "namespace", "error_type"}
+	if s.appConfig.Monitoring.Metrics.WorkloadLabel {
+		errorLabels = append(errorLabels, "workload")
+	}
+
+	return &statsCollector{
+		server:  s,
+		metrics: &s.appConfig.Monitoring.Metrics,
+		namespaceGauges: []namespaceGauge{
+			newNamespaceGauge("score", "Weighted error score of the namespace.",
+				func(ns NamespaceStats) float64 { return ns.Score }),
+			newNamespaceGauge("errors", "Total errors in the namespace.",
+				func(ns NamespaceStats) float64 { return float64(ns.TotalErrors) }),
+			newNamespaceGauge("crashloop", "Containers in CrashLoopBackOff.",
+				func(ns NamespaceStats) float64 { return float64(ns.CrashLoop) }),
+			newNamespaceGauge("image_pull", "Containers failing to pull their image.",
+				func(ns NamespaceStats) float64 { return float64(ns.ImagePull) }),
+			newNamespaceGauge("high_restarts", "Containers above the restart threshold.",
+				func(ns NamespaceStats) float64 { return float64(ns.HighRestarts) }),
+			newNamespaceGauge("pending", "Pods that are unschedulable or stuck in Pending.",
+				func(ns NamespaceStats) float64 { return float64(ns.Pending) }),
+			newNamespaceGauge("rollout", "Deployments and StatefulSets with a stuck rollout or missing replicas.",
+				func(ns NamespaceStats) float64 { return float64(ns.Rollout) }),
+			newNamespaceGauge("custom", "Pods matching a custom rule.",
+				func(ns NamespaceStats) float64 { return float64(ns.Custom) }),
+			newNamespaceGauge("unique_pods", "Pods with at least one error.",
+				func(ns NamespaceStats) float64 { return float64(ns.UniquePods) }),
+			newNamespaceGauge("restarts", "Restarts of the containers above the restart threshold.",
+				func(ns NamespaceStats) float64 { return float64(ns.TotalRestarts) }),
+		},
+		podErrors: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "pod_errors"),
+			"Current pod errors by type.", errorLabels, nil),
+		cacheAge: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "cache_age_seconds"),
+			"Seconds since the stats of the cluster were last confirmed to be current.", []string{"cluster"}, nil),
+		clusterUp: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "cluster_up"),
+			"Whether the cluster is reachable.", []string{"cluster"}, nil),
+		dropped: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "metrics_dropped_namespaces"),
+			"Namespaces with errors left out by max_namespaces.", []string{"cluster"}, nil),
+		ruleErrors: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "rule_evaluation_errors_total"),
+			"Pods a custom rule failed to evaluate on, which count as not matching.", []string{"rule"}, nil),
+	}
+}
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, gauge := range c.namespaceGauges {
+		ch <- gauge.desc
+	}
+	ch <- c.podErrors
+	ch <- c.cacheAge
+	ch <- c.clusterUp
+	ch <- c.dropped
+	ch <- c.ruleErrors
+}
+
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, rule := range c.server.appConfig.Monitoring.Rules {
+		ch <- prometheus.MustNewConstMetric(c.ruleErrors, prometheus.CounterValue, float64(rule.Compiled.Failures()), rule.Name)
+	}
+
+	for _, cl := range c.server.clusterList() {
+		up := 0.0
+		if cl.summary().Status == ClusterConnected {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.clusterUp, prometheus.GaugeValue, up, cl.name)
+
+		cache := cl.podCache()
+		if cache == nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.cacheAge, prometheus.GaugeValue, cache.Age().Seconds(), cl.name)
+
+		// Stats are sorted by score, so the limit keeps the worst namespaces
+		exported := make(map[string]bool)
+		dropped := 0
+		for _, ns := range cache.NamespaceStats() {
+			if !c.metrics.ExportsNamespace(ns.Name) {
+				continue
+			}
+			if c.metrics.MaxNamespaces > 0 && len(exported) >= c.metrics.MaxNamespaces {
+				dropped++
+				continue
+			}
+			exported[ns.Name] = true
+			for _, gauge := range c.namespaceGauges {
+				ch <- prometheus.MustNewConstMetric(gauge.desc, prometheus.GaugeValue, gauge.value(ns), cl.name, ns.Name)
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(c.dropped, prometheus.GaugeValue, float64(dropped), cl.name)
+
+		counts := make(map[[3]string]int)
+		for _, e := range cache.AllPodErrors() {
+			if !c.metrics.ExportsNamespace(e.Namespace) {
+				continue
+			}
+			if c.metrics.MaxNamespaces > 0 && !exported[e.Namespace] {
+				continue
+			}
+			key := [3]string{e.Namespace, e.ErrorType}
+			if c.metrics.WorkloadLabel {
+				key[2] = e.Owner
+			}
+			counts[key]++
+		}
+		for key, count := range counts {
+			labels := []string{cl.name, key[0], key[1]}
+			if c.metrics.WorkloadLabel {
+				labels = append(labels, key[2])
+			}
+			ch <- prometheus.MustNewConstMetric(c.podErrors, prometheus.GaugeValue, float64(count), labels...)
+		}
+	}
+}
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"pod-error-monitor/config"
+)
+
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+const (
+	alertNamespaceScore = "NamespaceScoreHigh"
+	alertPodError       = "PodErrorDetected"
+)
+
+const (
+	// notifyRemindInterval is how often firing alerts are checked for a
+	// reminder or the end of their grace period
+	notifyRemindInterval = time.Minute
+	// notifyAttempts bounds the deliveries of a batch to a failing sink
+	notifyAttempts = 3
+	// notifyRetryDelay is the wait before the first retry, doubled after
+	// every further failure
+	notifyRetryDelay = 5 * time.Second
+)
+
+// Alert is a condition reported to the notification sinks. Alerts with the
+// same fingerprint are deduplicated while they are firing.
+type Alert struct {
+	Fingerprint   string     `json:"fingerprint"`
+	Name          string     `json:"name"`
+	Status        string     `json:"status"`
+	Cluster       string     `json:"cluster"`
+	Namespace     string     `json:"namespace"`
+	ErrorType     string     `json:"errorType,omitempty"`
+	Owner         string     `json:"owner,omitempty"`
+	ContainerName string     `json:"containerName,omitempty"`
+	Pods          []string   `json:"pods,omitempty"`
+	Score         float64    `json:"score,omitempty"`
+	Summary       string     `json:"summary"`
+	Message       string     `json:"message,omitempty"`
+	StartsAt      time.Time  `json:"startsAt"`
+	EndsAt        *time.Time `json:"endsAt,omitempty"`
+}
+
+// notificationSink delivers a batch of alerts to one target
+type notificationSink interface {
+	send(ctx context.Context, alerts []Alert) error
+}
+
+func newNotificationSink(sink config.SinkConfig, resend time.Duration) notificationSink {
+	client := &http.Client{Timeout: time.Duration(sink.Timeout) * time.Second}
+
+	switch sink.Type {
+	case config.SinkSlack:
+		return &slackSink{url: sink.URL, headers: sink.Headers, client: client}
+	case config.SinkAlertmanager:
+		return &alertmanagerSink{
+			url:     strings.TrimSuffix(sink.URL, "/") + "/api/v2/alerts",
+			headers: sink.Headers,
+			client:  client,
+			resend:  resend,
+		}
+	default:
+		return &webhookSink{url: sink.URL, headers: sink.Headers, client: client}
+	}
+}
+
+// postJSON sends body to url and fails on any non-2xx response
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned %s: %s", url, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// webhookPayload is the body of the generic webhook
+type webhookPayload struct {
+	Status string  `json:"status"`
+	Alerts []Alert `json:"alerts"`
+}
+
+type webhookSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (s *webhookSink) send(ctx context.Context, alerts []Alert) error {
+	payload := webhookPayload{Status: AlertResolved, Alerts: alerts}
+	for _, alert := range alerts {
+		if alert.Status == AlertFiring {
+			payload.Status = AlertFiring
+			break
+		}
+	}
+	return postJSON(ctx, s.client, s.url, s.headers, payload)
+}
+
+// slackSink posts to a Slack-compatible incoming webhook
+type slackSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func (s *slackSink) send(ctx context.Context, alerts []Alert) error {
+	var text strings.Builder
+	for i, alert := range alerts {
+		if i > 0 {
+			text.WriteString("\n")
+		}
+		icon := ":rotating_light:"
+		if alert.Status == AlertResolved {
+			icon = ":white_check_mark:"
+		}
+		fmt.Fprintf(&text, "%s *[%s]* %s", icon, strings.ToUpper(alert.Status), alert.Summary)
+		if alert.Message != "" && alert.Status == AlertFiring {
+			fmt.Fprintf(&text, "\n> %s", alert.Message)
+		}
+	}
+	return postJSON(ctx, s.client, s.url, s.headers, map[string]string{"text": text.String()})
+}
+
+// alertmanagerAlert is the postable alert of the Alertmanager v2 API
+type alertmanagerAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// alertmanagerSink posts to the /api/v2/alerts endpoint of an Alertmanager
+type alertmanagerSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+	resend  time.Duration
+}
+
+func (s *alertmanagerSink) send(ctx context.Context, alerts []Alert) error {
+	body := make([]alertmanagerAlert, 0, len(alerts))
+	for _, alert := range alerts {
+		labels := map[string]string{
+			"alertname": alert.Name,
+			"cluster":   alert.Cluster,
+			"namespace": alert.Namespace,
+		}
+		for name, value := range map[string]string{
+			"error_type": alert.ErrorType,
+			"owner":      alert.Owner,
+			"container":  alert.ContainerName,
+		} {
+			if value != "" {
+				labels[name] = value
+			}
+		}
+
+		annotations := map[string]string{"summary": alert.Summary}
+		if alert.Message != "" {
+			annotations["description"] = alert.Message
+		}
+
+		// A firing alert expires on its own if the reminders stop arriving,
+		// e.g. because the monitor went away
+		endsAt := time.Now().Add(3 * s.resend)
+		if alert.EndsAt != nil {
+			endsAt = *alert.EndsAt
+		}
+
+		body = append(body, alertmanagerAlert{
+			Labels:      labels,
+			Annotations: annotations,
+			StartsAt:    alert.StartsAt,
+			EndsAt:      endsAt,
+		})
+	}
+	return postJSON(ctx, s.client, s.url, s.headers, body)
+}
+
+// firingAlert is an alert that was sent and not resolved yet
+type firingAlert struct {
+	alert    Alert
+	sinks    []string
+	lastSent time.Time
+	// missingSince is when the condition was last found gone, zero while present
+	missingSince time.Time
+}
+
+// sinkWorker queues the alerts of one sink and delivers them on its own,
+// so a slow or failing sink does not hold up the others. Queued alerts are
+// kept by fingerprint: a newer notification of an alert replaces one not
+// sent yet, so the queue is bounded by the alerts and nothing is dropped.
+type sinkWorker struct {
+	name       string
+	sink       notificationSink
+	retryDelay time.Duration
+
+	mu      sync.Mutex
+	pending []Alert
+	index   map[string]int
+	ready   chan struct{}
+}
+
+func newSinkWorker(name string, sink notificationSink) *sinkWorker {
+	return &sinkWorker{
+		name:       name,
+		sink:       sink,
+		retryDelay: notifyRetryDelay,
+		index:      make(map[string]int),
+		ready:      make(chan struct{}, 1),
+	}
+}
+
+// enqueue queues alerts for delivery without blocking
+func (w *sinkWorker) enqueue(alerts []Alert) {
+	w.mu.Lock()
+	for _, alert := range alerts {
+		if i, exists := w.index[alert.Fingerprint]; exists {
+			w.pending[i] = alert
+			continue
+		}
+		w.index[alert.Fingerprint] = len(w.pending)
+		w.pending = append(w.pending, alert)
+	}
+	w.mu.Unlock()
+
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
+
+// take returns and clears the queued alerts
+func (w *sinkWorker) take() []Alert {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	alerts := w.pending
+	w.pending = nil
+	w.index = make(map[string]int)
+	return alerts
+}
+
+// run delivers the queued alerts as they come in
+func (w *sinkWorker) run() {
+	for range w.ready {
+		if alerts := w.take(); len(alerts) > 0 {
+			w.deliver(alerts)
+		}
+	}
+}
+
+// deliver sends a batch to the sink, retrying with a growing delay
+func (w *sinkWorker) deliver(alerts []Alert) error {
+	delay := w.retryDelay
+	var err error
+	for attempt := 1; attempt <= notifyAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err = w.sink.send(ctx, alerts)
+		cancel()
+		if err == nil {
+			notificationsSent.WithLabelValues(w.name).Add(float64(len(alerts)))
+			return nil
+		}
+
+		log.Printf("Error sending %d alert(s) to %s (attempt %d of %d): %v", len(alerts), w.name, attempt, notifyAttempts, err)
+		if attempt < notifyAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	notificationsFailed.WithLabelValues(w.name).Inc()
+	return err
+}
+
+// notifier raises alerts from the published state and sends them to the
+// sinks picked by the routes. Firing alerts are sent once, reminded every
+// resend interval and followed by a resolve notification once their
+// condition has been gone for the grace period.
+type notifier struct {
+	mu      sync.Mutex
+	config  *config.NotificationsConfig
+	resend  time.Duration
+	grace   time.Duration
+	workers map[string]*sinkWorker
+	firing  map[string]*firingAlert
+}
+
+func newNotifier(cfg *config.NotificationsConfig, grace time.Duration) *notifier {
+	resend := time.Duration(cfg.ResendInterval) * time.Minute
+
+	n := &notifier{
+		config:  cfg,
+		resend:  resend,
+		grace:   grace,
+		workers: make(map[string]*sinkWorker),
+		firing:  make(map[string]*firingAlert),
+	}
+	for _, sink := range cfg.Sinks {
+		n.workers[sink.Name] = newSinkWorker(sink.Name, newNotificationSink(sink, resend))
+	}
+	return n
+}
+
+// run starts a worker per sink and sends the reminders
+func (n *notifier) run() {
+	for _, worker := range n.workers {
+		go worker.run()
+	}
+
+	ticker := time.NewTicker(notifyRemindInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		n.remind(now)
+	}
+}
+
+// alerts derives the alerts of a cluster from its stats and errors
+func (n *notifier) alerts(cluster string, stats []NamespaceStats, errors []PodError, now time.Time) []Alert {
+	var alerts []Alert
+
+	if threshold := n.config.ScoreThreshold; threshold > 0 {
+		for _, ns := range stats {
+			if ns.Score < threshold {
+				continue
+			}
+			alerts = append(alerts, Alert{
+				Fingerprint: cluster + "/" + ns.Name + "/" + alertNamespaceScore,
+				Name:        alertNamespaceScore,
+				Cluster:     cluster,
+				Namespace:   ns.Name,
+				Score:       ns.Score,
+				Summary:     fmt.Sprintf("Namespace %s on %s has an error score of %.1f (threshold %.1f)", ns.Name, cluster, ns.Score, threshold),
+				Message:     fmt.Sprintf("%d errors in %d pods", ns.TotalErrors, ns.UniquePods),
+			})
+		}
+	}
+
+	// Errors of the replicas of a workload are reported as one alert
+	groups := make(map[string][]PodError)
+	for _, e := range errors {
+		if !config.MatchesAny(n.config.ErrorTypes, e.ErrorType) {
+			continue
+		}
+		fingerprint := incidentFingerprint(cluster, e)
+		groups[fingerprint] = append(groups[fingerprint], e)
+	}
+	for fingerprint, group := range groups {
+		e := group[0]
+		pods := make([]string, 0, len(group))
+		for _, pe := range group {
+			if pe.PodName != "" {
+				pods = append(pods, pe.PodName)
+			}
+		}
+		sort.Strings(pods)
+
+		workload := e.Owner
+		if workload == "" {
+			workload = e.PodName
+		}
+		alerts = append(alerts, Alert{
+			Fingerprint:   fingerprint,
+			Name:          alertPodError,
+			Cluster:       cluster,
+			Namespace:     e.Namespace,
+			ErrorType:     e.ErrorType,
+			Owner:         e.Owner,
+			ContainerName: e.ContainerName,
+			Pods:          pods,
+			Summary:       fmt.Sprintf("%s in %s/%s container %s on %s", e.ErrorType, e.Namespace, workload, e.ContainerName, cluster),
+			Message:       e.ErrorMessage,
+		})
+	}
+
+	for i := range alerts {
+		alerts[i].Status = AlertFiring
+		alerts[i].StartsAt = now
+	}
+	return alerts
+}
+
+// update fires the new alerts of cluster, reminds of the overdue ones and
+// resolves the ones gone for the grace period
+func (n *notifier) update(cluster string, stats []NamespaceStats, errors []PodError, now time.Time) {
+	current := n.alerts(cluster, stats, errors, now)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	outgoing := make(map[string][]Alert)
+	seen := make(map[string]bool)
+	for _, alert := range current {
+		seen[alert.Fingerprint] = true
+
+		firing, exists := n.firing[alert.Fingerprint]
+		if !exists {
+			firing = &firingAlert{sinks: n.route(alert)}
+			n.firing[alert.Fingerprint] = firing
+		} else {
+			alert.StartsAt = firing.alert.StartsAt
+		}
+		firing.alert = alert
+		firing.missingSince = time.Time{}
+
+		if !exists || now.Sub(firing.lastSent) >= n.resend {
+			firing.lastSent = now
+			for _, sink := range firing.sinks {
+				outgoing[sink] = append(outgoing[sink], alert)
+			}
+		}
+	}
+
+	// Only resolve alerts of this cluster; others are owned by their own cache
+	for fingerprint, firing := range n.firing {
+		if firing.alert.Cluster == cluster && !seen[fingerprint] && firing.missingSince.IsZero() {
+			firing.missingSince = now
+		}
+	}
+	n.resolveExpired(now, outgoing)
+
+	n.enqueue(outgoing)
+}
+
+// resolveExpired adds the resolve notifications of the alerts whose
+// condition has been gone for the grace period to outgoing. They end as of
+// the time the condition went away.
+func (n *notifier) resolveExpired(now time.Time, outgoing map[string][]Alert) {
+	for fingerprint, firing := range n.firing {
+		if firing.missingSince.IsZero() || now.Sub(firing.missingSince) < n.grace {
+			continue
+		}
+		resolved := firing.alert
+		resolved.Status = AlertResolved
+		endsAt := firing.missingSince
+		resolved.EndsAt = &endsAt
+		for _, sink := range firing.sinks {
+			outgoing[sink] = append(outgoing[sink], resolved)
+		}
+		delete(n.firing, fingerprint)
+	}
+}
+
+// remind resolves the alerts whose grace period ran out while their cluster
+// published nothing new and resends the firing alerts that were not sent for
+// a resend interval
+func (n *notifier) remind(now time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	outgoing := make(map[string][]Alert)
+	n.resolveExpired(now, outgoing)
+	for _, firing := range n.firing {
+		if now.Sub(firing.lastSent) < n.resend {
+			continue
+		}
+		firing.lastSent = now
+		for _, sink := range firing.sinks {
+			outgoing[sink] = append(outgoing[sink], firing.alert)
+		}
+	}
+
+	n.enqueue(outgoing)
+}
+
+// route returns the sinks an alert is sent to
+func (n *notifier) route(alert Alert) []string {
+	if len(n.config.Routes) == 0 {
+		sinks := make([]string, 0, len(n.config.Sinks))
+		for _, sink := range n.config.Sinks {
+			sinks = append(sinks, sink.Name)
+		}
+		return sinks
+	}
+
+	var sinks []string
+	added := make(map[string]bool)
+	for _, route := range n.config.Routes {
+		if !route.Matches(alert.Cluster, alert.Namespace, alert.ErrorType) {
+			continue
+		}
+		for _, sink := range route.Sinks {
+			if !added[sink] {
+				added[sink] = true
+				sinks = append(sinks, sink)
+			}
+		}
+		if !route.Continue {
+			break
+		}
+	}
+	return sinks
+}
+
+// enqueue hands the alerts of each sink to its worker without blocking the
+// publisher
+func (n *notifier) enqueue(outgoing map[string][]Alert) {
+	for sink, alerts := range outgoing {
+		if worker, ok := n.workers[sink]; ok {
+			worker.enqueue(alerts)
+		}
+	}
+}
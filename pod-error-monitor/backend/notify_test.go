@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"pod-error-monitor/config"
+)
+
+// sinkServer records the requests sent to it and answers with the status
+// codes in order, then 200
+type sinkServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newSinkServer(t *testing.T, statuses ...int) *sinkServer {
+	s := &sinkServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		s.mu.Lock()
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, body)
+		status := http.StatusOK
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		s.mu.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func testAlerts() []Alert {
+	startsAt := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	endsAt := startsAt.Add(time.Hour)
+	return []Alert{
+		{
+			Fingerprint:   "prod/shop/Deployment/api/app/CrashLoopBackOff",
+			Name:          alertPodError,
+			Status:        AlertFiring,
+			Cluster:       "prod",
+			Namespace:     "shop",
+			ErrorType:     "CrashLoopBackOff",
+			Owner:         "Deployment/api",
+			ContainerName: "app",
+			Pods:          []string{"api-1"},
+			Summary:       "CrashLoopBackOff in shop/Deployment/api container app on prod",
+			Message:       "back-off restarting failed container",
+			StartsAt:      startsAt,
+		},
+		{
+			Fingerprint: "prod/batch/NamespaceScoreHigh",
+			Name:        alertNamespaceScore,
+			Status:      AlertResolved,
+			Cluster:     "prod",
+			Namespace:   "batch",
+			Score:       12,
+			Summary:     "Namespace batch on prod has an error score of 12.0 (threshold 10.0)",
+			StartsAt:    startsAt,
+			EndsAt:      &endsAt,
+		},
+	}
+}
+
+func TestSinkPayloads(t *testing.T) {
+	tests := []struct {
+		name     string
+		sinkType string
+		path     string
+		check    func(t *testing.T, body []byte)
+	}{
+		{
+			name:     "webhook",
+			sinkType: config.SinkWebhook,
+			path:     "/hook",
+			check: func(t *testing.T, body []byte) {
+				var payload webhookPayload
+				if err := json.Unmarshal(body, &payload); err != nil {
+					t.Fatalf("invalid payload: %v", err)
+				}
+				if payload.Status != AlertFiring {
+					t.Errorf("status = %s, want %s while any alert fires", payload.Status, AlertFiring)
+				}
+				if len(payload.Alerts) != 2 || payload.Alerts[0].Fingerprint != testAlerts()[0].Fingerprint {
+					t.Errorf("alerts = %+v", payload.Alerts)
+				}
+				if payload.Alerts[1].EndsAt == nil {
+					t.Errorf("resolved alert lost its endsAt")
+				}
+			},
+		},
+		{
+			name:     "slack",
+			sinkType: config.SinkSlack,
+			path:     "/services/T0/B0/X",
+			check: func(t *testing.T, body []byte) {
+				var payload map[string]string
+				if err := json.Unmarshal(body, &payload); err != nil {
+					t.Fatalf("invalid payload: %v", err)
+				}
+				lines := strings.Split(payload["text"], "\n")
+				want := []string{
+					":rotating_light: *[FIRING]* CrashLoopBackOff in shop/Deployment/api container app on prod",
+					"> back-off restarting failed container",
+					":white_check_mark: *[RESOLVED]* Namespace batch on prod has an error score of 12.0 (threshold 10.0)",
+				}
+				if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+					t.Errorf("text = %q, want %q", lines, want)
+				}
+			},
+		},
+		{
+			name:     "alertmanager",
+			sinkType: config.SinkAlertmanager,
+			path:     "/api/v2/alerts",
+			check: func(t *testing.T, body []byte) {
+				var payload []alertmanagerAlert
+				if err := json.Unmarshal(body, &payload); err != nil {
+					t.Fatalf("invalid payload: %v", err)
+				}
+				if len(payload) != 2 {
+					t.Fatalf("got %d alerts, want 2", len(payload))
+				}
+				firing, resolved := payload[0], payload[1]
+				wantLabels := map[string]string{
+					"alertname":  alertPodError,
+					"cluster":    "prod",
+					"namespace":  "shop",
+					"error_type": "CrashLoopBackOff",
+					"owner":      "Deployment/api",
+					"container":  "app",
+				}
+				for name, value := range wantLabels {
+					if firing.Labels[name] != value {
+						t.Errorf("label %s = %q, want %q", name, firing.Labels[name], value)
+					}
+				}
+				if firing.Annotations["description"] != "back-off restarting failed container" {
+					t.Errorf("annotations = %v", firing.Annotations)
+				}
+				if !firing.EndsAt.After(time.Now()) {
+					t.Errorf("firing alert ends at %v, want it to expire in the future", firing.EndsAt)
+				}
+				if _, exists := resolved.Labels["error_type"]; exists {
+					t.Errorf("score alert has an error_type label")
+				}
+				if !resolved.EndsAt.Equal(*testAlerts()[1].EndsAt) {
+					t.Errorf("resolved alert ends at %v, want %v", resolved.EndsAt, *testAlerts()[1].EndsAt)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSinkServer(t)
+			url := server.URL + tt.path
+			if tt.sinkType == config.SinkAlertmanager {
+				url = server.URL + "/"
+			}
+			sink := newNotificationSink(config.SinkConfig{
+				Name:    tt.name,
+				Type:    tt.sinkType,
+				URL:     url,
+				Headers: map[string]string{"Authorization": "Bearer secret"},
+				Timeout: 5,
+			}, time.Hour)
+
+			if err := sink.send(context.Background(), testAlerts()); err != nil {
+				t.Fatalf("send: %v", err)
+			}
+
+			if len(server.requests) != 1 {
+				t.Fatalf("got %d requests, want 1", len(server.requests))
+			}
+			req := server.requests[0]
+			if req.URL.Path != tt.path {
+				t.Errorf("path = %s, want %s", req.URL.Path, tt.path)
+			}
+			if req.Header.Get("Content-Type") != "application/json" || req.Header.Get("Authorization") != "Bearer secret" {
+				t.Errorf("headers = %v", req.Header)
+			}
+			tt.check(t, server.bodies[0])
+		})
+	}
+}
+
+func TestNotifierDeliverRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantErr      bool
+		wantRequests int
+	}{
+		{name: "success", wantRequests: 1},
+		{name: "recovers on retry", statuses: []int{http.StatusBadGateway, http.StatusServiceUnavailable}, wantRequests: 3},
+		{name: "gives up after the last attempt", statuses: []int{500, 500, 500, 500}, wantErr: true, wantRequests: notifyAttempts},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSinkServer(t, tt.statuses...)
+			n := newNotifier(&config.NotificationsConfig{
+				Sinks: []config.SinkConfig{{Name: "hook", Type: config.SinkWebhook, URL: server.URL, Timeout: 5}},
+			}, time.Minute)
+			worker := n.workers["hook"]
+			worker.retryDelay = time.Millisecond
+
+			err := worker.deliver(testAlerts()[:1])
+			if (err != nil) != tt.wantErr {
+				t.Errorf("deliver error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(server.requests) != tt.wantRequests {
+				t.Errorf("got %d requests, want %d", len(server.requests), tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestSinkWorkerQueue(t *testing.T) {
+	firing, resolved := testAlerts()[0], testAlerts()[1]
+	reminder := firing
+	reminder.Message = "back-off 5m0s restarting failed container"
+	resolvedFiring := resolved
+	resolvedFiring.Fingerprint = firing.Fingerprint
+
+	tests := []struct {
+		name    string
+		batches [][]Alert
+		want    []Alert
+	}{
+		{
+			name:    "batches are sent together",
+			batches: [][]Alert{{firing}, {resolved}},
+			want:    []Alert{firing, resolved},
+		},
+		{
+			name:    "newer notification replaces the queued one",
+			batches: [][]Alert{{firing, resolved}, {reminder}},
+			want:    []Alert{reminder, resolved},
+		},
+		{
+			name:    "resolve replaces a firing alert not sent yet",
+			batches: [][]Alert{{firing}, {resolvedFiring}},
+			want:    []Alert{resolvedFiring},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			worker := newSinkWorker("hook", nil)
+			for _, batch := range tt.batches {
+				worker.enqueue(batch)
+			}
+			got := worker.take()
+			if len(got) != len(tt.want) {
+				t.Fatalf("queued %d alerts, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i].Fingerprint != tt.want[i].Fingerprint || got[i].Status != tt.want[i].Status || got[i].Message != tt.want[i].Message {
+					t.Errorf("alert %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+			if rest := worker.take(); len(rest) != 0 {
+				t.Errorf("queue holds %d alerts after take, want none", len(rest))
+			}
+		})
+	}
+}
+
+func TestNotifierSinksAreIndependent(t *testing.T) {
+	release := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hanging.Close()
+	defer close(release)
+	slack := newSinkServer(t)
+
+	n := newNotifier(&config.NotificationsConfig{
+		ErrorTypes:     []string{"*"},
+		ResendInterval: 240,
+		Sinks: []config.SinkConfig{
+			{Name: "hook", Type: config.SinkWebhook, URL: hanging.URL, Timeout: 60},
+			{Name: "slack", Type: config.SinkSlack, URL: slack.URL, Timeout: 5},
+		},
+	}, 5*time.Minute)
+	for _, worker := range n.workers {
+		go worker.run()
+	}
+
+	n.update("prod", nil, []PodError{crashLoop("shop", "api-1")}, time.Now())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		slack.mu.Lock()
+		sent := len(slack.requests)
+		slack.mu.Unlock()
+		if sent > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("slack got nothing while the webhook hangs")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// drainQueue returns the alerts queued for delivery, keyed by sink
+func drainQueue(n *notifier) map[string][]Alert {
+	queued := make(map[string][]Alert)
+	for name, worker := range n.workers {
+		if alerts := worker.take(); len(alerts) > 0 {
+			queued[name] = alerts
+		}
+	}
+	return queued
+}
+
+func TestNotifierUpdate(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	present := []PodError{crashLoop("shop", "api-1"), crashLoop("shop", "api-2")}
+
+	tests := []struct {
+		name   string
+		cycles [][]PodError // published once a minute
+		resend int          // minutes
+		want   []string     // statuses sent, in order
+	}{
+		{
+			name:   "replicas share one alert",
+			cycles: [][]PodError{present},
+			want:   []string{AlertFiring},
+		},
+		{
+			name:   "persisting alert is not sent again before the resend interval",
+			cycles: [][]PodError{present, present, present},
+			want:   []string{AlertFiring},
+		},
+		{
+			name:   "persisting alert is reminded after the resend interval",
+			cycles: [][]PodError{present, present, present},
+			resend: 2,
+			want:   []string{AlertFiring, AlertFiring},
+		},
+		{
+			name:   "crash loop flapping within the grace period is not resolved",
+			cycles: [][]PodError{present, nil, present, nil, nil, present},
+			want:   []string{AlertFiring},
+		},
+		{
+			name:   "condition gone for the grace period resolves",
+			cycles: [][]PodError{present, nil, nil, nil, nil, nil, nil},
+			want:   []string{AlertFiring, AlertResolved},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resend := tt.resend
+			if resend == 0 {
+				resend = 240
+			}
+			n := newNotifier(&config.NotificationsConfig{
+				ErrorTypes:     []string{"*"},
+				ResendInterval: resend,
+				Sinks:          []config.SinkConfig{{Name: "hook", Type: config.SinkWebhook}},
+			}, 5*time.Minute)
+
+			var sent []Alert
+			for i, errors := range tt.cycles {
+				n.update("prod", nil, errors, start.Add(time.Duration(i)*time.Minute))
+				sent = append(sent, drainQueue(n)["hook"]...)
+			}
+
+			var statuses []string
+			for _, alert := range sent {
+				statuses = append(statuses, alert.Status)
+			}
+			if strings.Join(statuses, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("sent %v, want %v", statuses, tt.want)
+			}
+			if len(sent[0].Pods) != 2 {
+				t.Errorf("pods = %v, want both replicas", sent[0].Pods)
+			}
+			if last := sent[len(sent)-1]; last.Status == AlertResolved {
+				if last.EndsAt == nil || !last.EndsAt.Equal(start.Add(time.Minute)) {
+					t.Errorf("endsAt = %v, want %v", last.EndsAt, start.Add(time.Minute))
+				}
+			}
+		})
+	}
+}
+
+func TestNotifierRemindResolvesExpired(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	n := newNotifier(&config.NotificationsConfig{
+		ErrorTypes:     []string{"*"},
+		ResendInterval: 240,
+		Sinks:          []config.SinkConfig{{Name: "hook", Type: config.SinkWebhook}},
+	}, 5*time.Minute)
+
+	n.update("prod", nil, []PodError{crashLoop("shop", "api-1")}, start)
+	n.update("prod", nil, nil, start.Add(time.Minute))
+	drainQueue(n)
+
+	// The cache publishes nothing once its state stops changing
+	n.remind(start.Add(3 * time.Minute))
+	if sent := drainQueue(n)["hook"]; len(sent) != 0 {
+		t.Fatalf("sent %+v before the grace period ran out", sent)
+	}
+	n.remind(start.Add(6 * time.Minute))
+	if sent := drainQueue(n)["hook"]; len(sent) != 1 || sent[0].Status != AlertResolved {
+		t.Fatalf("sent %+v, want one resolve notification", sent)
+	}
+}
@@ -0,0 +1,157 @@
+// Package rules compiles the custom detection rules of the configuration.
+// A rule is a CEL expression over the pod, e.g.
+//
+//	pod.metadata.namespace == "payments" &&
+//	    pod.status.containerStatuses.exists(c, c.restartCount > 0)
+//
+// that raises an error named after the rule for every pod it is true for.
+package rules
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"pod-error-monitor/detect"
+
+	"github.com/google/cel-go/cel"
+	v1 "k8s.io/api/core/v1"
+)
+
+// errorLogInterval is how often the failures of a rule are logged at most
+const errorLogInterval = 10 * time.Minute
+
+var (
+	envOnce sync.Once
+	env     *cel.Env
+	envErr  error
+)
+
+// newEnv declares the variables rules may use: the pod with the fields of
+// its JSON, as kubectl get -o json shows them
+func newEnv() (*cel.Env, error) {
+	envOnce.Do(func() {
+		env, envErr = cel.NewEnv(cel.Variable("pod", cel.DynType))
+	})
+	return env, envErr
+}
+
+// Rule is a compiled custom rule. It is a detect.Detector, so it runs
+// alongside the built-in detectors.
+type Rule struct {
+	name     string
+	severity string
+	program  cel.Program
+	message  *template.Template
+	// text is the message as configured
+	text string
+
+	// failures counts the pods the expression failed on, lastLogged is
+	// when that was last logged in Unix nanoseconds
+	failures   atomic.Uint64
+	lastLogged atomic.Int64
+}
+
+// Compile checks a rule and prepares it to run. The message is a Go template
+// over the same pod, e.g. "{{.pod.metadata.name}} is not Ready"; without one
+// the error says which rule matched.
+func Compile(name, expression, severity, message string) (*Rule, error) {
+	if detect.IsBuiltinErrorType(name) {
+		return nil, fmt.Errorf("name collides with the built-in error type %s", name)
+	}
+
+	env, err := newEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("invalid expression: %v", issues.Err())
+	}
+	if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+		return nil, fmt.Errorf("expression must return a bool, not %s", ast.OutputType())
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression: %v", err)
+	}
+
+	if message == "" {
+		message = fmt.Sprintf("Pod matches rule %s", name)
+	}
+	tmpl, err := template.New(name).Parse(message)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message: %v", err)
+	}
+
+	return &Rule{name: name, severity: severity, program: program, message: tmpl, text: message}, nil
+}
+
+// Name is the name of the rule, which is also the error type it raises
+func (r *Rule) Name() string { return r.name }
+
+// Failures is how often the expression failed on a pod
+func (r *Rule) Failures() uint64 { return r.failures.Load() }
+
+// Detect evaluates the rule on a pod. An expression that fails on a pod,
+// e.g. because it reads a label the pod does not have, does not match but
+// counts as a failure of the rule; has() guards optional fields.
+func (r *Rule) Detect(in *detect.Input) []detect.Finding {
+	object, err := in.Object()
+	if err != nil {
+		r.fail(in.Pod, err)
+		return nil
+	}
+	vars := map[string]interface{}{"pod": object}
+
+	out, _, err := r.program.Eval(vars)
+	if err != nil {
+		r.fail(in.Pod, err)
+		return nil
+	}
+	if matched, ok := out.Value().(bool); !ok || !matched {
+		return nil
+	}
+
+	pod := in.Pod
+	return []detect.Finding{{
+		Namespace:    pod.Namespace,
+		PodName:      pod.Name,
+		ErrorType:    r.name,
+		ErrorMessage: r.render(vars),
+		Owner:        in.Workloads.PodOwner(pod),
+		Rule:         r.name,
+		Severity:     r.severity,
+	}}
+}
+
+// fail counts a failed evaluation and logs it, at most once per
+// errorLogInterval so a rule failing on every pod does not flood the log
+func (r *Rule) fail(pod *v1.Pod, err error) {
+	failures := r.failures.Add(1)
+
+	now := time.Now().UnixNano()
+	last := r.lastLogged.Load()
+	if last != 0 && now-last < int64(errorLogInterval) {
+		return
+	}
+	if !r.lastLogged.CompareAndSwap(last, now) {
+		return
+	}
+	log.Printf("Rule %s failed on pod %s/%s (%d failures so far): %v", r.name, pod.Namespace, pod.Name, failures, err)
+}
+
+// render fills in the message, falling back to the template text when the
+// pod does not fit it
+func (r *Rule) render(vars map[string]interface{}) string {
+	var buf bytes.Buffer
+	if err := r.message.Execute(&buf, vars); err != nil {
+		return r.text
+	}
+	return buf.String()
+}
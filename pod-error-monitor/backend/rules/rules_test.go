@@ -0,0 +1,147 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+
+	"pod-error-monitor/detect"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		ruleName   string
+		expression string
+		message    string
+		wantErr    string
+	}{
+		{
+			name:       "syntax error",
+			ruleName:   "Broken",
+			expression: "pod.metadata.name ==",
+			wantErr:    "invalid expression",
+		},
+		{
+			name:       "not a bool",
+			ruleName:   "NotBool",
+			expression: `"text"`,
+			wantErr:    "must return a bool",
+		},
+		{
+			name:       "broken message",
+			ruleName:   "BadMessage",
+			expression: "true",
+			message:    "{{.pod.metadata.name",
+			wantErr:    "invalid message",
+		},
+		{
+			name:       "waiting reason",
+			ruleName:   "CrashLoopBackOff",
+			expression: "true",
+			wantErr:    "collides with the built-in error type",
+		},
+		{
+			name:       "other built-in error type",
+			ruleName:   "UnavailableReplicas",
+			expression: "true",
+			wantErr:    "collides with the built-in error type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(tt.ruleName, tt.expression, "warning", tt.message)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRuleDetect(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "payments",
+			Name:      "api-1",
+			Labels:    map[string]string{"tier": "critical"},
+		},
+		Status: v1.PodStatus{Phase: v1.PodRunning},
+	}
+
+	tests := []struct {
+		name         string
+		expression   string
+		message      string
+		wantMatch    bool
+		wantMessage  string
+		wantFailures uint64
+	}{
+		{
+			name:        "match with message",
+			expression:  `pod.metadata.labels.tier == "critical"`,
+			message:     "Critical pod {{.pod.metadata.name}}",
+			wantMatch:   true,
+			wantMessage: "Critical pod api-1",
+		},
+		{
+			name:        "match with default message",
+			expression:  `pod.metadata.namespace == "payments"`,
+			wantMatch:   true,
+			wantMessage: "Pod matches rule Custom",
+		},
+		{
+			name:        "message the pod does not fit",
+			expression:  "true",
+			message:     "{{.pod.metadata.name.first}}",
+			wantMatch:   true,
+			wantMessage: "{{.pod.metadata.name.first}}",
+		},
+		{
+			name:       "no match",
+			expression: `pod.status.phase == "Pending"`,
+		},
+		{
+			name:       "guarded missing field",
+			expression: `has(pod.metadata.labels.team) && pod.metadata.labels.team == "core"`,
+		},
+		{
+			name:         "missing field fails",
+			expression:   `pod.metadata.labels.team == "core"`,
+			wantFailures: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Compile("Custom", tt.expression, "critical", tt.message)
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+
+			findings := rule.Detect(&detect.Input{Pod: pod, Settings: detect.DefaultSettings})
+			if len(findings) != 0 != tt.wantMatch {
+				t.Fatalf("findings = %+v, want match %v", findings, tt.wantMatch)
+			}
+			if got := rule.Failures(); got != tt.wantFailures {
+				t.Errorf("Failures = %d, want %d", got, tt.wantFailures)
+			}
+			if !tt.wantMatch {
+				return
+			}
+
+			e := findings[0]
+			if e.ErrorType != "Custom" || e.Rule != "Custom" || e.Severity != "critical" {
+				t.Errorf("type %s, rule %s, severity %s", e.ErrorType, e.Rule, e.Severity)
+			}
+			if e.ErrorMessage != tt.wantMessage {
+				t.Errorf("message = %q, want %q", e.ErrorMessage, tt.wantMessage)
+			}
+			if e.Owner != "Pod/api-1" {
+				t.Errorf("owner = %s", e.Owner)
+			}
+		})
+	}
+}
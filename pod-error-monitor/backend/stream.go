@@ -0,0 +1,445 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// streamHistorySize is the number of updates kept for resuming clients
+	streamHistorySize = 256
+	// streamBufferSize is the number of updates a slow client may lag behind
+	// before it is disconnected and has to resume from its cursor
+	streamBufferSize = 64
+	// streamHeartbeat keeps idle connections from being closed by proxies
+	streamHeartbeat = 15 * time.Second
+)
+
+// StreamEvent is a single message on /api/stream. A "snapshot" carries the
+// complete state of all clusters and replaces whatever the client had. An
+// "update" concerns a single cluster: it carries that cluster's complete
+// stats when they changed (null otherwise) and the pod errors that were
+// added, resolved or changed since the previous update.
+type StreamEvent struct {
+	Cursor   uint64           `json:"cursor"`
+	Type     string           `json:"type"`
+	Cluster  string           `json:"cluster,omitempty"`
+	Stats    []NamespaceStats `json:"stats"`
+	Errors   []PodError       `json:"errors,omitempty"`
+	Added    []PodError       `json:"added,omitempty"`
+	Resolved []PodError       `json:"resolved,omitempty"`
+	Changed  []PodError       `json:"changed,omitempty"`
+}
+
+// streamFilter limits a subscription to clusters, a namespace and/or an
+// error type
+type streamFilter struct {
+	// clusters are the names of the clusters selected, all when empty
+	clusters  map[string]bool
+	namespace string
+	errorType string
+
+	// sentStats are the filtered stats last sent for each cluster, so an
+	// update only carries stats when the selected ones changed
+	sentStats map[string][]NamespaceStats
+}
+
+// clusterFilter selects the named clusters, or all without names
+func clusterFilter(names ...string) map[string]bool {
+	if len(names) == 0 {
+		return nil
+	}
+	clusters := make(map[string]bool, len(names))
+	for _, name := range names {
+		clusters[name] = true
+	}
+	return clusters
+}
+
+// includesCluster reports whether the filter selects cluster
+func (f streamFilter) includesCluster(cluster string) bool {
+	return len(f.clusters) == 0 || f.clusters[cluster]
+}
+
+// streamHub tracks the latest state published by the pod cache and fans out
+// the differences to the connected stream clients.
+type streamHub struct {
+	mu          sync.Mutex
+	cursor      uint64
+	history     []StreamEvent
+	stats       map[string][]NamespaceStats
+	errors      map[string]map[string]PodError
+	subscribers map[chan StreamEvent]struct{}
+}
+
+func newStreamHub() *streamHub {
+	return &streamHub{
+		stats:       make(map[string][]NamespaceStats),
+		errors:      make(map[string]map[string]PodError),
+		subscribers: make(map[chan StreamEvent]struct{}),
+	}
+}
+
+// podErrorKey identifies a pod error across recomputes. Errors of a Job or
+// CronJob have no pod and are told apart by their owner.
+func podErrorKey(e PodError) string {
+	name := e.PodName
+	if name == "" {
+		name = e.Owner
+	}
+	return e.Cluster + "/" + e.Namespace + "/" + name + "/" + e.ContainerName + "/" + e.ErrorType
+}
+
+// diffPodErrors compares the current errors against the previous set, keyed
+// by podErrorKey, and returns the differences along with the new set. An
+// error that persists has changed unless same holds for both versions.
+func diffPodErrors(previous map[string]PodError, errors []PodError, same func(a, b PodError) bool) (added, resolved, changed []PodError, current map[string]PodError) {
+	current = make(map[string]PodError, len(errors))
+	for _, e := range errors {
+		key := podErrorKey(e)
+		current[key] = e
+
+		old, exists := previous[key]
+		switch {
+		case !exists:
+			added = append(added, e)
+		case !same(old, e):
+			changed = append(changed, e)
+		}
+	}
+	for key, e := range previous {
+		if _, exists := current[key]; !exists {
+			resolved = append(resolved, e)
+		}
+	}
+
+	return added, resolved, changed, current
+}
+
+// samePodError reports whether two versions of an error are identical, so
+// clients see every new event and restart
+func samePodError(a, b PodError) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+// publish records the new state of cluster and notifies subscribers of what changed
+func (h *streamHub) publish(cluster string, stats []NamespaceStats, errors []PodError) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	event := StreamEvent{Type: "update", Cluster: cluster}
+	if previous, exists := h.stats[cluster]; !exists || !reflect.DeepEqual(stats, previous) {
+		event.Stats = append([]NamespaceStats{}, stats...)
+	}
+
+	var current map[string]PodError
+	event.Added, event.Resolved, event.Changed, current = diffPodErrors(h.errors[cluster], errors, samePodError)
+
+	h.stats[cluster] = stats
+	h.errors[cluster] = current
+
+	if event.Stats == nil && len(event.Added) == 0 && len(event.Resolved) == 0 && len(event.Changed) == 0 {
+		return
+	}
+
+	h.broadcast(event)
+}
+
+// removeCluster forgets the state of a cluster that is no longer monitored
+// and sends every client a fresh snapshot
+func (h *streamHub) removeCluster(cluster string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, exists := h.stats[cluster]; !exists {
+		return
+	}
+	delete(h.stats, cluster)
+	delete(h.errors, cluster)
+
+	h.broadcast(h.snapshot())
+}
+
+// broadcast assigns the next cursor to event and sends it to every subscriber
+func (h *streamHub) broadcast(event StreamEvent) {
+	h.cursor++
+	event.Cursor = h.cursor
+	h.history = append(h.history, event)
+	if len(h.history) > streamHistorySize {
+		h.history = h.history[len(h.history)-streamHistorySize:]
+	}
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// The client cannot keep up; drop it so it resumes from its cursor
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe registers a new client. If cursor is still in the history the
+// missed updates are returned for replay, otherwise a snapshot is returned.
+func (h *streamHub) subscribe(cursor uint64, resume bool) ([]StreamEvent, chan StreamEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var initial []StreamEvent
+	if resume && h.canResume(cursor) {
+		for _, event := range h.history {
+			if event.Cursor > cursor {
+				initial = append(initial, event)
+			}
+		}
+	} else {
+		snapshot := h.snapshot()
+		snapshot.Cursor = h.cursor
+		initial = []StreamEvent{snapshot}
+	}
+
+	ch := make(chan StreamEvent, streamBufferSize)
+	h.subscribers[ch] = struct{}{}
+
+	return initial, ch
+}
+
+// snapshot returns the complete state of all clusters
+func (h *streamHub) snapshot() StreamEvent {
+	event := StreamEvent{
+		Type:   "snapshot",
+		Stats:  []NamespaceStats{},
+		Errors: []PodError{},
+	}
+	for _, stats := range h.stats {
+		event.Stats = append(event.Stats, stats...)
+	}
+	for _, errors := range h.errors {
+		for _, e := range errors {
+			event.Errors = append(event.Errors, e)
+		}
+	}
+	sort.Slice(event.Stats, func(i, j int) bool {
+		return event.Stats[i].Score > event.Stats[j].Score
+	})
+	sort.Slice(event.Errors, func(i, j int) bool {
+		return podErrorKey(event.Errors[i]) < podErrorKey(event.Errors[j])
+	})
+	return event
+}
+
+func (h *streamHub) canResume(cursor uint64) bool {
+	if cursor > h.cursor {
+		return false
+	}
+	if cursor == h.cursor {
+		return true
+	}
+	return len(h.history) > 0 && h.history[0].Cursor <= cursor+1
+}
+
+func (h *streamHub) unsubscribe(ch chan StreamEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, exists := h.subscribers[ch]; exists {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// apply narrows an event down to what the filter selects. It reports false
+// when nothing relevant is left.
+func (f *streamFilter) apply(event StreamEvent) (StreamEvent, bool) {
+	if len(f.clusters) == 0 && f.namespace == "" && f.errorType == "" {
+		return event, true
+	}
+	if event.Type == "update" && !f.includesCluster(event.Cluster) {
+		return event, false
+	}
+
+	filtered := StreamEvent{Cursor: event.Cursor, Type: event.Type, Cluster: event.Cluster}
+	switch {
+	case event.Type == "snapshot":
+		// A snapshot replaces what the client had, for every cluster it covers
+		filtered.Stats = f.filterStats(event.Stats)
+		f.sentStats = make(map[string][]NamespaceStats)
+		for _, ns := range event.Stats {
+			if _, exists := f.sentStats[ns.Cluster]; !exists {
+				f.sentStats[ns.Cluster] = []NamespaceStats{}
+			}
+		}
+		for _, ns := range filtered.Stats {
+			f.sentStats[ns.Cluster] = append(f.sentStats[ns.Cluster], ns)
+		}
+	case event.Stats != nil:
+		stats := f.filterStats(event.Stats)
+		if sent, exists := f.sentStats[event.Cluster]; !exists || !sameStats(sent, stats) {
+			if f.sentStats == nil {
+				f.sentStats = make(map[string][]NamespaceStats)
+			}
+			f.sentStats[event.Cluster] = stats
+			filtered.Stats = stats
+		}
+	}
+	filtered.Errors = f.filterErrors(event.Errors)
+	filtered.Added = f.filterErrors(event.Added)
+	filtered.Resolved = f.filterErrors(event.Resolved)
+	filtered.Changed = f.filterErrors(event.Changed)
+
+	if event.Type == "snapshot" {
+		return filtered, true
+	}
+	empty := filtered.Stats == nil && filtered.Added == nil && filtered.Resolved == nil && filtered.Changed == nil
+	return filtered, !empty
+}
+
+// filterStats returns the selected namespace stats, empty rather than nil
+func (f streamFilter) filterStats(stats []NamespaceStats) []NamespaceStats {
+	filtered := []NamespaceStats{}
+	for _, ns := range stats {
+		if f.includesCluster(ns.Cluster) && (f.namespace == "" || ns.Name == f.namespace) {
+			filtered = append(filtered, ns)
+		}
+	}
+	return filtered
+}
+
+// sameStats reports whether two sets of namespace stats are identical
+func sameStats(a, b []NamespaceStats) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func (f streamFilter) filterErrors(errors []PodError) []PodError {
+	var result []PodError
+	for _, e := range errors {
+		if !f.includesCluster(e.Cluster) {
+			continue
+		}
+		if f.namespace != "" && e.Namespace != f.namespace {
+			continue
+		}
+		if f.errorType != "" && e.ErrorType != f.errorType {
+			continue
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+// streamUpdates pushes namespace stats and pod error deltas as server-sent
+// events. Clients resume with the Last-Event-ID header or the cursor query
+// parameter and may filter with the namespace and errorType parameters. The
+// context is selected like for every other route.
+func (s *Server) streamUpdates(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := &streamFilter{
+		namespace: query.Get("namespace"),
+		errorType: query.Get("errorType"),
+	}
+
+	// The stream covers the clusters every other route serves: the selected
+	// context, started if needed and kept alive while streaming, or else the
+	// default clusters
+	clusters, err := s.requestClusters(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	names := make([]string, 0, len(clusters))
+	for _, c := range clusters {
+		names = append(names, c.name)
+	}
+	filter.clusters = clusterFilter(names...)
+	var selected *cluster
+	if len(clusters) == 1 && clusters[0].onDemand {
+		selected = clusters[0]
+	}
+
+	var cursor uint64
+	resume := false
+	rawCursor := r.Header.Get("Last-Event-ID")
+	if rawCursor == "" {
+		rawCursor = query.Get("cursor")
+	}
+	if rawCursor != "" {
+		parsed, err := strconv.ParseUint(rawCursor, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		cursor = parsed
+		resume = true
+	}
+
+	initial, ch := s.stream.subscribe(cursor, resume)
+	defer s.stream.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	for _, event := range initial {
+		if err := writeStreamEvent(w, filter, event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, open := <-ch:
+			if !open {
+				return
+			}
+			if err := writeStreamEvent(w, filter, event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if selected != nil {
+				selected.touch()
+			}
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeStreamEvent(w http.ResponseWriter, filter *streamFilter, event StreamEvent) error {
+	event, ok := filter.apply(event)
+	if !ok {
+		return nil
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Cursor, event.Type, data)
+	return err
+}
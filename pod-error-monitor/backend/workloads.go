@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"pod-error-monitor/config"
+	"pod-error-monitor/detect"
+
+	"github.com/gorilla/mux"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// WorkloadStats aggregates the errors of the pods owned by one workload
+type WorkloadStats struct {
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	// DesiredReplicas is taken from the workload spec, or is the number of
+	// pods when the workload cannot be looked up
+	DesiredReplicas int32          `json:"desiredReplicas"`
+	Pods            int            `json:"pods"`
+	AffectedPods    int            `json:"affectedPods"`
+	TotalErrors     int            `json:"totalErrors"`
+	ErrorTypes      map[string]int `json:"errorTypes"`
+	TotalRestarts   int32          `json:"totalRestarts"`
+	Score           float64        `json:"score"`
+}
+
+// workloadListers look up the desired replicas of the workloads owning pods.
+// They only fill in the replica counts, so the cache does not wait for them.
+type workloadListers struct {
+	deployments  appslisters.DeploymentLister
+	statefulSets appslisters.StatefulSetLister
+	daemonSets   appslisters.DaemonSetLister
+	replicaSets  appslisters.ReplicaSetLister
+	jobs         batchlisters.JobLister
+	cronJobs     batchlisters.CronJobLister
+	synced       []cache.InformerSynced
+}
+
+func newWorkloadListers(factory informers.SharedInformerFactory) *workloadListers {
+	deployments := factory.Apps().V1().Deployments()
+	statefulSets := factory.Apps().V1().StatefulSets()
+	daemonSets := factory.Apps().V1().DaemonSets()
+	replicaSets := factory.Apps().V1().ReplicaSets()
+	jobs := factory.Batch().V1().Jobs()
+	cronJobs := factory.Batch().V1().CronJobs()
+
+	return &workloadListers{
+		deployments:  deployments.Lister(),
+		statefulSets: statefulSets.Lister(),
+		daemonSets:   daemonSets.Lister(),
+		replicaSets:  replicaSets.Lister(),
+		jobs:         jobs.Lister(),
+		cronJobs:     cronJobs.Lister(),
+		synced: []cache.InformerSynced{
+			deployments.Informer().HasSynced,
+			statefulSets.Informer().HasSynced,
+			daemonSets.Informer().HasSynced,
+			replicaSets.Informer().HasSynced,
+			jobs.Informer().HasSynced,
+			cronJobs.Informer().HasSynced,
+		},
+	}
+}
+
+// hasSynced reports whether all workload informers have synced
+func (l *workloadListers) hasSynced() bool {
+	for _, synced := range l.synced {
+		if !synced() {
+			return false
+		}
+	}
+	return true
+}
+
+// desiredReplicas returns the replicas a workload asks for
+func (l *workloadListers) desiredReplicas(namespace, kind, name string) (int32, bool) {
+	if !l.hasSynced() {
+		return 0, false
+	}
+
+	switch kind {
+	case "Deployment":
+		if d, err := l.deployments.Deployments(namespace).Get(name); err == nil {
+			return detect.ReplicaCount(d.Spec.Replicas), true
+		}
+	case "StatefulSet":
+		if s, err := l.statefulSets.StatefulSets(namespace).Get(name); err == nil {
+			return detect.ReplicaCount(s.Spec.Replicas), true
+		}
+	case "DaemonSet":
+		if d, err := l.daemonSets.DaemonSets(namespace).Get(name); err == nil {
+			return d.Status.DesiredNumberScheduled, true
+		}
+	case "ReplicaSet":
+		if r, err := l.replicaSets.ReplicaSets(namespace).Get(name); err == nil {
+			return detect.ReplicaCount(r.Spec.Replicas), true
+		}
+	case "Job":
+		if j, err := l.jobs.Jobs(namespace).Get(name); err == nil {
+			return detect.ReplicaCount(j.Spec.Parallelism), true
+		}
+	}
+	return 0, false
+}
+
+// newWorkloadIndex lists the workloads of namespace from the workload
+// listers, or of all namespaces when namespace is empty, and indexes pods
+// by the workload controlling them
+func newWorkloadIndex(workloads *workloadListers, namespace string, pods []*v1.Pod) *detect.Workloads {
+	jobs, err := workloads.jobs.Jobs(namespace).List(labels.Everything())
+	if err != nil {
+		return nil
+	}
+	cronJobs, err := workloads.cronJobs.CronJobs(namespace).List(labels.Everything())
+	if err != nil {
+		return nil
+	}
+	deployments, err := workloads.deployments.Deployments(namespace).List(labels.Everything())
+	if err != nil {
+		return nil
+	}
+	statefulSets, err := workloads.statefulSets.StatefulSets(namespace).List(labels.Everything())
+	if err != nil {
+		return nil
+	}
+	replicaSets, err := workloads.replicaSets.ReplicaSets(namespace).List(labels.Everything())
+	if err != nil {
+		return nil
+	}
+	return detect.NewWorkloads(jobs, cronJobs, deployments, statefulSets, replicaSets, pods)
+}
+
+// aggregateWorkloads groups the errors of a namespace by the workload owning
+// their pods, which index resolves. Workloads without errors are left out.
+func aggregateWorkloads(pods []*v1.Pod, errors []PodError, index *detect.Workloads, workloads *workloadListers, monitoring *config.MonitoringConfig) []WorkloadStats {
+	podsByOwner := make(map[string]int)
+	for _, pod := range pods {
+		if pod.Status.Phase != v1.PodSucceeded {
+			podsByOwner[pod.Namespace+"/"+index.PodOwner(pod)]++
+		}
+	}
+
+	byOwner := make(map[string][]PodError)
+	for _, e := range errors {
+		key := e.Namespace + "/" + e.Owner
+		byOwner[key] = append(byOwner[key], e)
+	}
+
+	// A workload scores like a namespace holding only its errors
+	options := scoreOptions(monitoring)
+	results := make([]WorkloadStats, 0, len(byOwner))
+	for key, ownerErrors := range byOwner {
+		first := ownerErrors[0]
+		kind, name, _ := strings.Cut(first.Owner, "/")
+		tally := detect.TallyFindings(ownerErrors, options)
+		stats := WorkloadStats{
+			Cluster:       first.Cluster,
+			Namespace:     first.Namespace,
+			Kind:          kind,
+			Name:          name,
+			Pods:          podsByOwner[key],
+			AffectedPods:  tally.Pods,
+			TotalErrors:   tally.Counts.Total,
+			ErrorTypes:    make(map[string]int),
+			TotalRestarts: tally.Counts.Restarts,
+			Score:         tally.Score(monitoring.ForNamespace(first.Namespace).ErrorWeights.Weights()),
+		}
+		for _, e := range ownerErrors {
+			stats.ErrorTypes[e.ErrorType]++
+		}
+
+		if stats.Pods < stats.AffectedPods {
+			stats.Pods = stats.AffectedPods
+		}
+		stats.DesiredReplicas = int32(stats.Pods)
+		if workloads != nil {
+			if desired, ok := workloads.desiredReplicas(stats.Namespace, stats.Kind, stats.Name); ok {
+				stats.DesiredReplicas = desired
+			}
+		}
+		results = append(results, stats)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Kind+"/"+results[i].Name < results[j].Kind+"/"+results[j].Name
+	})
+	return results
+}
+
+// Workloads returns the errors of a namespace grouped by workload
+func (c *podCache) Workloads(namespace string) ([]WorkloadStats, error) {
+	pods, err := c.Pods(namespace)
+	if err != nil {
+		return nil, err
+	}
+	index := c.workloadIndex(namespace, pods)
+	return aggregateWorkloads(pods, c.podErrors(pods, c.eventIndex(), index, c.configRefs()), index, c.workloads, c.monitoring), nil
+}
+
+func (s *Server) getNamespaceWorkloads(w http.ResponseWriter, r *http.Request) {
+	namespace := mux.Vars(r)["namespace"]
+
+	clusters, err := s.requestClusters(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	workloads := []WorkloadStats{}
+	for _, c := range clusters {
+		cache := c.podCache()
+		if cache == nil {
+			continue
+		}
+
+		clusterWorkloads, err := cache.Workloads(namespace)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		workloads = append(workloads, clusterWorkloads...)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workloads)
+}
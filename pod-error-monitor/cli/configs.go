@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+)
+
+// configLookupTimeout bounds the reference lookups of one watch refresh
+const configLookupTimeout = 10 * time.Second
+
+// secretsResource is read as metadata only, so Secret values are never
+// fetched
+var secretsResource = v1.SchemeGroupVersion.WithResource("secrets")
+
+// errSecretsUnchecked leaves references to Secrets unchecked without a
+// metadata client
+var errSecretsUnchecked = errors.New("secrets are not looked up")
+
+// configGetter looks up the ConfigMaps and Secrets that pods refer to with
+// one GET each, remembering the answers for the run. Only pods that cannot
+// start are checked, so few objects are fetched. A failed GET (e.g. for
+// missing permissions) leaves the reference unchecked.
+type configGetter struct {
+	ctx        context.Context
+	clientset  kubernetes.Interface
+	metadata   metadata.Interface
+	configMaps map[string]configMapResult
+	secrets    map[string]error
+}
+
+type configMapResult struct {
+	configMap *v1.ConfigMap
+	err       error
+}
+
+// newConfigGetter creates a lookup for one run. Secrets are read as metadata
+// through metadataClient, and not at all when it is nil.
+func newConfigGetter(ctx context.Context, clientset kubernetes.Interface, metadataClient metadata.Interface) *configGetter {
+	return &configGetter{
+		ctx:        ctx,
+		clientset:  clientset,
+		metadata:   metadataClient,
+		configMaps: make(map[string]configMapResult),
+		secrets:    make(map[string]error),
+	}
+}
+
+func (g *configGetter) ConfigMap(namespace, name string) (*v1.ConfigMap, error) {
+	key := namespace + "/" + name
+	if result, ok := g.configMaps[key]; ok {
+		return result.configMap, result.err
+	}
+	configMap, err := g.clientset.CoreV1().ConfigMaps(namespace).Get(g.ctx, name, metav1.GetOptions{})
+	g.configMaps[key] = configMapResult{configMap: configMap, err: err}
+	return configMap, err
+}
+
+func (g *configGetter) Secret(namespace, name string) error {
+	if g.metadata == nil {
+		return errSecretsUnchecked
+	}
+	key := namespace + "/" + name
+	if err, ok := g.secrets[key]; ok {
+		return err
+	}
+	_, err := g.metadata.Resource(secretsResource).Namespace(namespace).Get(g.ctx, name, metav1.GetOptions{})
+	g.secrets[key] = err
+	return err
+}
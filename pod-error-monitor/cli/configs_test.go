@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"pod-error-monitor/detect"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/metadata"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// referencingPod returns a Pending pod of the shop namespace whose app
+// container reads key of the settings ConfigMap and all of secret
+func referencingPod(name, key, secret string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: name, CreationTimestamp: metav1.Now()},
+		Spec: v1.PodSpec{Containers: []v1.Container{{
+			Name: "app",
+			Env: []v1.EnvVar{{Name: "SETTING", ValueFrom: &v1.EnvVarSource{ConfigMapKeyRef: &v1.ConfigMapKeySelector{
+				LocalObjectReference: v1.LocalObjectReference{Name: "settings"}, Key: key,
+			}}}},
+			EnvFrom: []v1.EnvFromSource{{SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: secret}}}},
+		}}},
+		Status: v1.PodStatus{Phase: v1.PodPending},
+	}
+}
+
+func TestConfigGetter(t *testing.T) {
+	detectors, err := detect.NewRegistry(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := &metav1.PartialObjectMetadata{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "db"},
+	}
+
+	tests := []struct {
+		name     string
+		metadata func() metadata.Interface
+		want     map[string]string
+	}{
+		{
+			name: "ConfigMaps and Secrets",
+			metadata: func() metadata.Interface {
+				scheme := metadatafake.NewTestScheme()
+				metav1.AddMetaToScheme(scheme)
+				return metadatafake.NewSimpleMetadataClient(scheme, secret)
+			},
+			want: map[string]string{"api-1": "MissingConfigMapKey", "api-2": "MissingSecret"},
+		},
+		{
+			name:     "Secrets without a metadata client",
+			metadata: func() metadata.Interface { return nil },
+			want:     map[string]string{"api-1": "MissingConfigMapKey"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(&v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "settings"},
+				Data:       map[string]string{"log_level": "info"},
+			})
+			gets := 0
+			clientset.PrependReactor("get", "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+				gets++
+				return false, nil, nil
+			})
+
+			pods := []*v1.Pod{
+				referencingPod("api-1", "region", "db"),
+				referencingPod("api-2", "log_level", "api-keys"),
+			}
+			configs := newConfigGetter(context.Background(), clientset, tt.metadata())
+			got := make(map[string]string)
+			for _, e := range findPodErrors(pods, nil, nil, configs, detectors) {
+				got[e.PodName] = e.ErrorType
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("errors = %v, want %v", got, tt.want)
+			}
+			for pod, errorType := range tt.want {
+				if got[pod] != errorType {
+					t.Errorf("%s: error = %q, want %q", pod, got[pod], errorType)
+				}
+			}
+			if gets != 1 {
+				t.Errorf("ConfigMap fetched %d times, want once", gets)
+			}
+		})
+	}
+}
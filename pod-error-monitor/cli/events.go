@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"pod-error-monitor/detect"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// eventWindow is how long an event stays relevant after it was last seen,
+// matching the default of the backend
+const eventWindow = 30 * time.Minute
+
+// listPodEvents indexes the recent warnings about the pods of namespace.
+// Events only enrich the errors, so a failure (e.g. missing permissions)
+// leaves them out instead of failing the run.
+func listPodEvents(ctx context.Context, clientset kubernetes.Interface, namespace string) *detect.EventIndex {
+	list, err := clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{FieldSelector: detect.PodEventSelector})
+	if err != nil {
+		return nil
+	}
+
+	events := make([]*v1.Event, 0, len(list.Items))
+	for i := range list.Items {
+		events = append(events, &list.Items[i])
+	}
+	return detect.NewEventIndex(events, eventWindow, time.Now())
+}
+
+// eventsLabel summarizes events as "Reason xCount", newest first
+func eventsLabel(events []detect.PodEvent) string {
+	if len(events) == 0 {
+		return "-"
+	}
+	labels := make([]string, 0, len(events))
+	for _, event := range events {
+		labels = append(labels, fmt.Sprintf("%s x%d", event.Reason, event.Count))
+	}
+	return strings.Join(labels, ", ")
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"pod-error-monitor/detect"
+
+	"github.com/urfave/cli/v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Exit codes. When several conditions apply the highest code wins.
+const (
+	exitOK                = 0
+	exitFailure           = 1 // invalid flags or kubeconfig, or access denied
+	exitErrorsFound       = 2 // errors matched --fail-on-type or --fail-on-namespace
+	exitThresholdExceeded = 3 // a namespace reached --fail-on-score
+	exitUnreachable       = 4 // the cluster could not be queried
+)
+
+// queryFailed returns the exit of a failed cluster query. Denied access is
+// a problem of the credentials rather than of the cluster, so it does not
+// count as unreachable.
+func queryFailed(kubeContext string, err error) cli.ExitCoder {
+	if apierrors.IsUnauthorized(err) || apierrors.IsForbidden(err) {
+		return cli.Exit(fmt.Sprintf("access to cluster %s denied: %v", kubeContext, err), exitFailure)
+	}
+	return cli.Exit(fmt.Sprintf("cluster %s unreachable: %v", kubeContext, err), exitUnreachable)
+}
+
+// failPolicy holds the --fail-on-* conditions of a CI run
+type failPolicy struct {
+	score      float64
+	types      []string
+	namespaces []string
+}
+
+func newFailPolicy(c *cli.Context) (failPolicy, error) {
+	policy := failPolicy{
+		score:      c.Float64("fail-on-score"),
+		types:      c.StringSlice("fail-on-type"),
+		namespaces: c.StringSlice("fail-on-namespace"),
+	}
+	return policy, policy.validate()
+}
+
+// validate rejects conditions that could never be met
+func (p failPolicy) validate() error {
+	if p.score < 0 {
+		return fmt.Errorf("--fail-on-score must not be negative")
+	}
+	// A misspelled type would never match and let every run pass
+	for _, errorType := range p.types {
+		if !detect.IsBuiltinErrorType(errorType) {
+			return fmt.Errorf("unknown --fail-on-type %q", errorType)
+		}
+	}
+	for _, pattern := range p.namespaces {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid --fail-on-namespace pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// enabled reports whether any condition was given
+func (p failPolicy) enabled() bool {
+	return p.score > 0 || len(p.types) > 0 || len(p.namespaces) > 0
+}
+
+// violation is one failed condition
+type violation struct {
+	namespace string
+	message   string
+	code      int
+}
+
+func (v violation) String() string {
+	return fmt.Sprintf("namespace %s: %s", v.namespace, v.message)
+}
+
+// check evaluates the policy against the namespace stats and returns the
+// violations with the exit code they lead to
+func (p failPolicy) check(stats []namespaceStats) ([]violation, int) {
+	var violations []violation
+	code := exitOK
+
+	add := func(v violation) {
+		violations = append(violations, v)
+		if v.code > code {
+			code = v.code
+		}
+	}
+
+	for _, ns := range stats {
+		if p.score > 0 && ns.score >= p.score {
+			add(violation{
+				namespace: ns.name,
+				message:   fmt.Sprintf("score %.1f reached the threshold of %.1f", ns.score, p.score),
+				code:      exitThresholdExceeded,
+			})
+		}
+
+		for _, errorType := range p.types {
+			if count := ns.errorTypes[errorType]; count > 0 {
+				add(violation{
+					namespace: ns.name,
+					message:   fmt.Sprintf("%d %s error(s)", count, errorType),
+					code:      exitErrorsFound,
+				})
+			}
+		}
+
+		if ns.counts.Total > 0 && matchesAny(p.namespaces, ns.name) {
+			add(violation{
+				namespace: ns.name,
+				message:   fmt.Sprintf("%d error(s) in %d pod(s)", ns.counts.Total, ns.uniquePods),
+				code:      exitErrorsFound,
+			})
+		}
+	}
+
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].code > violations[j].code
+	})
+	return violations, code
+}
+
+// matchesAny reports whether value matches one of the glob patterns
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, value); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"pod-error-monitor/detect"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestFailPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  failPolicy
+		wantErr bool
+	}{
+		{name: "no conditions"},
+		{name: "waiting reason and detector type", policy: failPolicy{types: []string{"CrashLoopBackOff", "OOMKilled"}}},
+		{name: "misspelled type", policy: failPolicy{types: []string{"CrashLoopBakOff"}}, wantErr: true},
+		{name: "negative score", policy: failPolicy{score: -1}, wantErr: true},
+		{name: "invalid namespace pattern", policy: failPolicy{namespaces: []string{"prod-["}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFailPolicyCheck(t *testing.T) {
+	shop := namespaceStats{
+		name:       "shop",
+		counts:     detect.Counts{Total: 3, CrashLoop: 2, ImagePull: 1},
+		uniquePods: 2,
+		errorTypes: map[string]int{"CrashLoopBackOff": 2, "ImagePullBackOff": 1},
+		score:      8,
+	}
+	prod := namespaceStats{
+		name:       "prod-payments",
+		counts:     detect.Counts{Total: 1, Pending: 1},
+		uniquePods: 1,
+		errorTypes: map[string]int{"Unschedulable": 1},
+		score:      2,
+	}
+	quiet := namespaceStats{name: "prod-web", errorTypes: map[string]int{}}
+	stats := []namespaceStats{shop, prod, quiet}
+
+	tests := []struct {
+		name           string
+		policy         failPolicy
+		wantCode       int
+		wantViolations []string
+	}{
+		{
+			name:     "no conditions",
+			wantCode: exitOK,
+		},
+		{
+			name:     "nothing matches",
+			policy:   failPolicy{score: 10, types: []string{"OOMKilled"}, namespaces: []string{"staging-*"}},
+			wantCode: exitOK,
+		},
+		{
+			name:           "error type found",
+			policy:         failPolicy{types: []string{"CrashLoopBackOff"}},
+			wantCode:       exitErrorsFound,
+			wantViolations: []string{"namespace shop: 2 CrashLoopBackOff error(s)"},
+		},
+		{
+			name:           "namespaces with errors",
+			policy:         failPolicy{namespaces: []string{"prod-*"}},
+			wantCode:       exitErrorsFound,
+			wantViolations: []string{"namespace prod-payments: 1 error(s) in 1 pod(s)"},
+		},
+		{
+			name:     "score threshold outranks errors found",
+			policy:   failPolicy{score: 8, types: []string{"Unschedulable"}},
+			wantCode: exitThresholdExceeded,
+			wantViolations: []string{
+				"namespace shop: score 8.0 reached the threshold of 8.0",
+				"namespace prod-payments: 1 Unschedulable error(s)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, code := tt.policy.check(stats)
+			if code != tt.wantCode {
+				t.Errorf("code = %d, want %d", code, tt.wantCode)
+			}
+			if len(violations) != len(tt.wantViolations) {
+				t.Fatalf("violations = %v, want %v", violations, tt.wantViolations)
+			}
+			for i, v := range violations {
+				if v.String() != tt.wantViolations[i] {
+					t.Errorf("violation %d = %q, want %q", i, v, tt.wantViolations[i])
+				}
+			}
+		})
+	}
+}
+
+func TestQueryFailed(t *testing.T) {
+	pods := schema.GroupResource{Resource: "pods"}
+
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int
+	}{
+		{name: "forbidden", err: apierrors.NewForbidden(pods, "", errors.New("no list on pods")), wantCode: exitFailure},
+		{name: "unauthorized", err: apierrors.NewUnauthorized("token expired"), wantCode: exitFailure},
+		{name: "connection refused", err: errors.New("dial tcp 127.0.0.1:6443: connect: connection refused"), wantCode: exitUnreachable},
+		{name: "server timeout", err: apierrors.NewServerTimeout(pods, "list", 1), wantCode: exitUnreachable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := queryFailed("prod", tt.err).ExitCode(); code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d", code, tt.wantCode)
+			}
+		})
+	}
+}
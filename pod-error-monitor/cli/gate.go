@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"pod-error-monitor/detect"
+
+	"github.com/urfave/cli/v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// gateProbeTimeout bounds the reachability check before the baseline
+	gateProbeTimeout = 15 * time.Second
+	// gateSyncTimeout bounds the initial pod list of the gate
+	gateSyncTimeout = 2 * time.Minute
+)
+
+// gateErrorTypes are the errors that block a deploy when they are new
+var gateErrorTypes = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"InvalidImageName":           true,
+	"ErrImageNeverPull":          true,
+	"HighRestartCount":           true,
+	"OOMKilled":                  true,
+	"ContainerCannotRun":         true,
+	"CreateContainerConfigError": true,
+	"RunContainerError":          true,
+	"Unschedulable":              true,
+}
+
+// gateErrorFamilies groups the error types one failure cycles through. A
+// crash-looping container shows CrashLoopBackOff while it backs off, an
+// OOMKill or a failed start while it runs again and eventually too many
+// restarts, and an image that cannot be pulled alternates between
+// ErrImagePull and ImagePullBackOff. Types of one family count as the same
+// error, so such a container in the baseline never becomes a regression.
+var gateErrorFamilies = map[string]string{
+	"CrashLoopBackOff":   "crash",
+	"OOMKilled":          "crash",
+	"ContainerCannotRun": "crash",
+	"RunContainerError":  "crash",
+	"HighRestartCount":   "crash",
+	"ImagePullBackOff":   "image",
+	"ErrImagePull":       "image",
+}
+
+// gateKey identifies an error of a container independent of the phase of
+// its failure cycle
+func gateKey(e podError) string {
+	family, ok := gateErrorFamilies[e.ErrorType]
+	if !ok {
+		family = e.ErrorType
+	}
+	return e.Namespace + "/" + podLabel(e) + "/" + e.ContainerName + "/" + family
+}
+
+// regression is a gated error that was not present in the baseline
+type regression struct {
+	err       podError
+	firstSeen time.Time
+}
+
+func gateCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "gate",
+		Usage: "Watch the pods of a rollout and fail on new crash loops, image pull errors or restarts",
+		Description: `Records the errors of the selected pods as a baseline, then watches them for
+--window. The gate fails (exit code 2) if a CrashLoopBackOff, an OOMKill, an
+image pull error, a container that cannot be configured or started, an
+unschedulable pod or a container above
+--restart-threshold appears that was not in the baseline. Errors that existed before the rollout never block it. An
+unreachable cluster exits with code 4, denied access with code 1.
+
+# Gate a rollout of the web deployment for 5 minutes
+kubectl apply -f web.yaml && pod-error-monitor gate -n shop -l app=web`,
+		Flags: append(kubeFlags(),
+			&cli.StringFlag{
+				Name:    "selector",
+				Aliases: []string{"l"},
+				Usage:   "Label selector of the pods to watch (default: all pods)",
+			},
+			&cli.DurationFlag{
+				Name:  "window",
+				Value: 5 * time.Minute,
+				Usage: "How long to watch for new errors",
+			},
+			&cli.IntFlag{
+				Name:  "restart-threshold",
+				Value: 5,
+				Usage: "Restarts of a container above which it counts as a regression",
+			},
+			&cli.BoolFlag{
+				Name:  "fail-fast",
+				Usage: "Fail on the first regression instead of watching the whole window",
+			},
+			detectorFlag(),
+		),
+		Action: runGate,
+	}
+}
+
+func runGate(c *cli.Context) error {
+	window := c.Duration("window")
+	if window <= 0 {
+		return fmt.Errorf("--window must be positive")
+	}
+	threshold := int32(c.Int("restart-threshold"))
+	if threshold < 0 {
+		return fmt.Errorf("--restart-threshold must not be negative")
+	}
+	detectors, err := newDetectors(c)
+	if err != nil {
+		return err
+	}
+	namespace := kubeFlag(c, "namespace")
+	selector := c.String("selector")
+	if _, err := labels.Parse(selector); err != nil {
+		return fmt.Errorf("invalid --selector: %v", err)
+	}
+
+	clientset, _, kubeContext, err := newClients(kubeFlag(c, "kubeconfig"), kubeFlag(c, "context"))
+	if err != nil {
+		return err
+	}
+
+	// Probe once, so an unreachable cluster fails right away instead of
+	// leaving the informer retrying
+	probeCtx, cancelProbe := context.WithTimeout(c.Context, gateProbeTimeout)
+	defer cancelProbe()
+	_, err = clientset.CoreV1().Pods(namespace).List(probeCtx, metav1.ListOptions{LabelSelector: selector, Limit: 1})
+	if err != nil {
+		return queryFailed(kubeContext, err)
+	}
+
+	// The gate judges pods only, so it needs neither events nor the
+	// workloads that raise errors of their own
+	watcher := newPodWatcher(clientset, nil, namespace, selector, detectors, watchSources{})
+	defer watcher.stop()
+
+	syncCtx, cancel := context.WithTimeout(c.Context, gateSyncTimeout)
+	defer cancel()
+	if err := watcher.sync(syncCtx); err != nil {
+		if c.Context.Err() != nil {
+			return fmt.Errorf("gate interrupted")
+		}
+		return queryFailed(kubeContext, err)
+	}
+
+	pods, err := watcher.lister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	baseline := make(map[string]bool)
+	for _, e := range gateErrors(pods, watcher.workloadIndex(pods), detectors, threshold) {
+		baseline[gateKey(e)] = true
+	}
+
+	start := time.Now()
+	fmt.Printf("Gate: watching %d pod(s) in %s for %s (context %s, selector %q)\n",
+		len(pods), namespaceLabel(namespace), window, kubeContext, selector)
+	if len(baseline) > 0 {
+		fmt.Printf("Ignoring %d error(s) present before the rollout\n", len(baseline))
+	}
+
+	regressions := make(map[string]*regression)
+	check := func() {
+		pods, err := watcher.lister.List(labels.Everything())
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		for _, e := range gateErrors(pods, watcher.workloadIndex(pods), detectors, threshold) {
+			key := gateKey(e)
+			if baseline[key] {
+				continue
+			}
+			if r, exists := regressions[key]; exists {
+				r.err = e
+				continue
+			}
+			regressions[key] = &regression{err: e, firstSeen: now}
+			fmt.Printf("REGRESSION +%s %s/%s %s %s\n",
+				now.Sub(start).Truncate(time.Second),
+				e.Namespace, e.PodName, orDash(e.ContainerName), e.ErrorType)
+		}
+	}
+	check()
+
+	deadline := time.NewTimer(window)
+	defer deadline.Stop()
+
+watch:
+	for !(c.Bool("fail-fast") && len(regressions) > 0) {
+		select {
+		case <-c.Context.Done():
+			printGateSummary(regressions, start)
+			return fmt.Errorf("gate interrupted")
+		case <-deadline.C:
+			break watch
+		case <-watcher.changed:
+			check()
+		}
+	}
+
+	// Pods still counting toward the gate at the end
+	pods, err = watcher.lister.List(labels.Everything())
+	if err == nil {
+		fmt.Printf("\nWatched %s, %d pod(s) selected at the end\n", time.Since(start).Truncate(time.Second), len(pods))
+	}
+
+	printGateSummary(regressions, start)
+	if len(regressions) > 0 {
+		return cli.Exit("", exitErrorsFound)
+	}
+	return nil
+}
+
+// gateErrors returns the gated errors of the pods, with restart breaches
+// judged against threshold instead of the default restart limit. The pods
+// are attributed to the owners workloads resolves.
+func gateErrors(pods []*v1.Pod, workloads *detect.Workloads, detectors *detect.Registry, threshold int32) []podError {
+	settings := detect.DefaultSettings
+	settings.RestartThreshold = threshold
+
+	var errors []podError
+	now := time.Now()
+	for _, pod := range pods {
+		for _, finding := range detectors.Pod(&detect.Input{Pod: pod, Workloads: workloads, Settings: settings, Now: now}) {
+			if !gateErrorTypes[finding.ErrorType] {
+				continue
+			}
+			if finding.ErrorType == "HighRestartCount" {
+				finding.ErrorMessage = fmt.Sprintf("Container restarted %d times (threshold %d)", finding.RestartCount, threshold)
+			}
+			errors = append(errors, newPodError(finding, pod))
+		}
+	}
+	return errors
+}
+
+// printGateSummary prints what regressed, oldest first
+func printGateSummary(regressions map[string]*regression, start time.Time) {
+	if len(regressions) == 0 {
+		fmt.Println("Gate passed: no new errors")
+		return
+	}
+
+	sorted := make([]*regression, 0, len(regressions))
+	for _, r := range regressions {
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if !sorted[i].firstSeen.Equal(sorted[j].firstSeen) {
+			return sorted[i].firstSeen.Before(sorted[j].firstSeen)
+		}
+		return gateKey(sorted[i].err) < gateKey(sorted[j].err)
+	})
+
+	fmt.Printf("\nGate failed: %d new error(s)\n", len(sorted))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "AFTER\tNAMESPACE\tPOD\tCONTAINER\tTYPE\tRESTARTS\tOWNER\tMESSAGE\n")
+	for _, r := range sorted {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			r.firstSeen.Sub(start).Truncate(time.Second),
+			r.err.Namespace,
+			r.err.PodName,
+			orDash(containerLabel(r.err)),
+			r.err.ErrorType,
+			r.err.RestartCount,
+			orDash(r.err.Owner),
+			strings.ReplaceAll(r.err.ErrorMessage, "\n", " "),
+		)
+	}
+	w.Flush()
+}
+
+func namespaceLabel(namespace string) string {
+	if namespace == "" {
+		return "all namespaces"
+	}
+	return "namespace " + namespace
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"pod-error-monitor/detect"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testPod(name string, restarts int32, state, lastState v1.ContainerState) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: name},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "app"}}},
+		Status: v1.PodStatus{
+			Phase: v1.PodRunning,
+			ContainerStatuses: []v1.ContainerStatus{{
+				Name:                 "app",
+				RestartCount:         restarts,
+				State:                state,
+				LastTerminationState: lastState,
+			}},
+		},
+	}
+}
+
+func waiting(reason string) v1.ContainerState {
+	return v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: reason}}
+}
+
+func running() v1.ContainerState {
+	return v1.ContainerState{Running: &v1.ContainerStateRunning{}}
+}
+
+func terminated(reason string, exitCode int32) v1.ContainerState {
+	return v1.ContainerState{Terminated: &v1.ContainerStateTerminated{
+		Reason:     reason,
+		ExitCode:   exitCode,
+		FinishedAt: metav1.NewTime(time.Now().Add(-time.Minute)),
+	}}
+}
+
+func TestGateBaseline(t *testing.T) {
+	crashLooping := testPod("api-1", 4, waiting("CrashLoopBackOff"), terminated("OOMKilled", 137))
+
+	tests := []struct {
+		name           string
+		baseline       *v1.Pod
+		later          *v1.Pod
+		wantRegression bool
+	}{
+		{
+			name:     "crash loop seen running after an OOMKill",
+			baseline: crashLooping,
+			later:    testPod("api-1", 5, running(), terminated("OOMKilled", 137)),
+		},
+		{
+			name:     "crash loop passing the restart threshold",
+			baseline: crashLooping,
+			later:    testPod("api-1", 7, running(), terminated("Error", 1)),
+		},
+		{
+			name:     "crash loop failing to start",
+			baseline: crashLooping,
+			later:    testPod("api-1", 5, terminated("ContainerCannotRun", 128), v1.ContainerState{}),
+		},
+		{
+			name:     "image pull error backing off",
+			baseline: testPod("api-1", 0, waiting("ErrImagePull"), v1.ContainerState{}),
+			later:    testPod("api-1", 0, waiting("ImagePullBackOff"), v1.ContainerState{}),
+		},
+		{
+			name:           "crash loop of a new pod",
+			baseline:       crashLooping,
+			later:          testPod("api-2", 1, waiting("CrashLoopBackOff"), terminated("Error", 1)),
+			wantRegression: true,
+		},
+		{
+			name:           "crash loop of a pod that pulled its image before",
+			baseline:       testPod("api-1", 0, waiting("ImagePullBackOff"), v1.ContainerState{}),
+			later:          testPod("api-1", 1, waiting("CrashLoopBackOff"), terminated("Error", 1)),
+			wantRegression: true,
+		},
+		{
+			name:           "unschedulable pod is a different error than a crash loop",
+			baseline:       crashLooping,
+			later:          unschedulablePod("api-1"),
+			wantRegression: true,
+		},
+	}
+
+	detectors, err := detect.NewRegistry(nil)
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baseline := make(map[string]bool)
+			for _, e := range gateErrors([]*v1.Pod{tt.baseline}, nil, detectors, 5) {
+				baseline[gateKey(e)] = true
+			}
+			if len(baseline) == 0 {
+				t.Fatalf("baseline pod has no gated errors")
+			}
+
+			later := gateErrors([]*v1.Pod{tt.later}, nil, detectors, 5)
+			if len(later) == 0 {
+				t.Fatalf("later pod has no gated errors")
+			}
+			regressed := false
+			for _, e := range later {
+				if !baseline[gateKey(e)] {
+					regressed = true
+				}
+			}
+			if regressed != tt.wantRegression {
+				t.Errorf("regression = %v, want %v (errors %+v)", regressed, tt.wantRegression, later)
+			}
+		})
+	}
+}
+
+func unschedulablePod(name string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: name},
+		Status: v1.PodStatus{
+			Phase: v1.PodPending,
+			Conditions: []v1.PodCondition{{
+				Type:    v1.PodScheduled,
+				Status:  v1.ConditionFalse,
+				Reason:  v1.PodReasonUnschedulable,
+				Message: "0/3 nodes are available: 3 Insufficient memory.",
+			}},
+		},
+	}
+}
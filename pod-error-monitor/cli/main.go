@@ -1,42 +1,43 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"sort"
 	"strings"
+	"syscall"
 	"text/tabwriter"
+	"time"
+
+	"pod-error-monitor/detect"
 
 	"github.com/urfave/cli/v2"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
+// podError is a finding of the detectors and where its pod runs
 type podError struct {
-	namespace     string
-	podName       string
-	errorType     string
-	errorMessage  string
-	containerName string
-	restartCount  int32
+	detect.Finding
+	nodeName string
+	image    string
+	created  time.Time
 }
 
 type namespaceStats struct {
-	name             string
-	totalErrors      int
-	uniquePods       map[string]bool
-	errorTypes       map[string]int
-	totalRestarts    int32
-	crashLoopCount   int
-	imagePullCount   int
-	highRestartCount int
-	score            float64
+	name       string
+	counts     detect.Counts
+	uniquePods int
+	errorTypes map[string]int
+	score      float64
 }
 
 func main() {
@@ -44,33 +45,66 @@ func main() {
 		Name:    "pod-error-monitor",
 		Usage:   "Monitor Kubernetes pod errors",
 		Version: "1.0.0",
-		Flags: []cli.Flag{
-			&cli.StringFlag{
-				Name:    "kubeconfig",
-				Aliases: []string{"k"},
-				Value:   filepath.Join(homedir.HomeDir(), ".kube", "config"),
-				Usage:   "Path to kubeconfig file",
+		Flags: append(kubeFlags(),
+			&cli.BoolFlag{
+				Name:    "watch",
+				Aliases: []string{"w"},
+				Usage:   "Watch for changes, refreshing on pod events and every --interval",
+			},
+			&cli.DurationFlag{
+				Name:    "interval",
+				Aliases: []string{"i"},
+				Value:   5 * time.Second,
+				Usage:   "Refresh interval in watch mode",
 			},
 			&cli.StringFlag{
-				Name:    "namespace",
-				Aliases: []string{"n"},
-				Usage:   "Filter by namespace (default: all namespaces)",
+				Name:    "output",
+				Aliases: []string{"o"},
+				Value:   outputTable,
+				Usage:   "Output format: table, wide, json, yaml, csv or markdown",
 			},
 			&cli.StringFlag{
-				Name:    "context",
-				Aliases: []string{"c"},
-				Usage:   "Use specific Kubernetes context",
+				Name:  "group-by",
+				Value: groupByPod,
+				Usage: "Group the errors by pod, or by the owning workload with replica counts and a score per workload",
+			},
+			&cli.Float64Flag{
+				Name:  "fail-on-score",
+				Usage: "Exit with code 3 when a namespace score reaches this value",
+			},
+			&cli.StringSliceFlag{
+				Name:  "fail-on-type",
+				Usage: "Exit with code 2 when errors of these types are found (comma separated)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "fail-on-namespace",
+				Usage: "Exit with code 2 when these namespaces (glob patterns, comma separated) have errors",
 			},
 			&cli.BoolFlag{
-				Name:    "watch",
-				Aliases: []string{"w"},
-				Usage:   "Watch for changes (updates every 5 seconds)",
+				Name:    "quiet",
+				Aliases: []string{"q"},
+				Usage:   "Print only the --fail-on-* violations",
 			},
 			&cli.BoolFlag{
 				Name:    "verbose",
 				Aliases: []string{"V"},
 				Usage:   "Show additional information about errors",
 			},
+			detectorFlag(),
+		),
+		Commands: []*cli.Command{
+			{
+				Name:  "tui",
+				Usage: "Browse namespaces, pod errors, events and previous logs interactively",
+				Flags: append(kubeFlags(), detectorFlag(), &cli.DurationFlag{
+					Name:    "interval",
+					Aliases: []string{"i"},
+					Value:   5 * time.Second,
+					Usage:   "Refresh interval, besides refreshing on pod events",
+				}),
+				Action: runTUI,
+			},
+			gateCommand(),
 		},
 		Action:          runCLI,
 		HideHelpCommand: true,
@@ -79,6 +113,8 @@ func main() {
 
 USAGE:
    {{.HelpName}} [options]
+   {{.HelpName}} tui [options]
+   {{.HelpName}} gate [options]
 
 VERSION:
    {{.Version}}
@@ -102,9 +138,37 @@ ERROR TYPES:
    PodFailed           Pod is in Failed phase
    HighRestartCount    Container has restarted more than 5 times
    CreateContainerError Unable to create the container
+   CreateContainerConfigError A ConfigMap, Secret or key the container refers
+                       to is missing
+   RunContainerError   The runtime could not run the container command
+   PostStartHookError  A lifecycle hook failed (also PreStopHookError)
    InvalidImageName    Container image name is invalid
    ImageInspectError   Error inspecting the container image
    ErrImageNeverPull   Image pull policy prevents pulling
+   OOMKilled           Container was killed for exceeding its memory limit
+   Error               Container exited with a non-zero code (explained for
+                       1, 126, 127, 137, 139 and 143)
+   ContainerCannotRun  The runtime could not start the container
+   Unschedulable       The scheduler cannot place the pod (its message says why)
+   StuckPending        Pod has been Pending for more than 10 minutes
+   LivenessProbeFailed A probe keeps failing (also ReadinessProbeFailed and
+                       StartupProbeFailed)
+   FailedMount         A volume keeps failing to mount (also FailedAttachVolume)
+   FailedCreatePodSandBox The pod sandbox or its network keeps failing
+   BackoffLimitExceeded A Job ran out of retries (also DeadlineExceeded, and
+                       JobFailed for other reasons)
+   CronJobOverdue      A CronJob has not succeeded for 2 schedule intervals
+   CronJobSuspended    A CronJob is suspended
+   ProgressDeadlineExceeded A Deployment rollout stopped making progress
+   UnavailableReplicas A Deployment or StatefulSet has fewer available
+                       replicas than desired
+
+   Errors carry the recent warning events of their pod and container
+   (FailedMount, FailedScheduling, Unhealthy, BackOff, FailedCreatePodSandBox,
+   Evicted). A warning seen 3 times within 30 minutes is an error of its own.
+
+   A crash within the last hour is reported even when the container has
+   restarted since. A crash loop carries the exit code of its last crash.
 
 EXAMPLES:
    # Monitor all namespaces
@@ -113,115 +177,254 @@ EXAMPLES:
    # Monitor specific namespace
    {{.HelpName}} -n kube-system
 
-   # Use specific context
+   # Use specific context for this run only (the kubeconfig is not modified)
    {{.HelpName}} -c minikube
 
    # Use custom kubeconfig
    {{.HelpName}} -k /path/to/kubeconfig
 
-   # Watch for changes (updates every 5 seconds)
+   # Merge several kubeconfig files like kubectl does
+   KUBECONFIG=~/.kube/config:~/.kube/staging {{.HelpName}} -c staging
+
+   # Watch for changes (new errors are highlighted, Ctrl-C exits)
    {{.HelpName}} -w
 
+   # Watch with a longer refresh interval
+   {{.HelpName}} -w -i 30s
+
+   # Show node, owner, image and age of every error
+   {{.HelpName}} -o wide
+
+   # One row per Deployment, StatefulSet, DaemonSet or Job with the
+   # affected replicas out of desired
+   {{.HelpName}} --group-by workload
+
+   # Machine readable output (JSON matches the backend API, CSV has one
+   # row per pod error, markdown renders tables for incident docs)
+   {{.HelpName}} -o json
+   {{.HelpName}} -n kube-system -o markdown > incident.md
+
+   # Fail a CI job on crash loops, image pull errors or a score of 10
+   {{.HelpName}} -n shop --fail-on-type CrashLoopBackOff,ImagePullBackOff --fail-on-score 10 -q
+
+   # Fail when any production namespace has errors
+   {{.HelpName}} --fail-on-namespace 'prod-*' -q
+
+   # Gate a rollout: fail if the web pods start crash looping, cannot pull
+   # their image or restart within 5 minutes (see gate --help)
+   {{.HelpName}} gate -n shop -l app=web --window 5m
+
+   # Skip the event and rollout detectors
+   {{.HelpName}} --disable-detector events,rollouts
+
    # Show verbose error information
    {{.HelpName}} --verbose, -V
 
+   # Browse interactively: enter drills into a namespace and a pod,
+   # / filters, e shows events, l previous logs, c switches context
+   {{.HelpName}} tui -n kube-system
+
    # Combine multiple options
    {{.HelpName}} -n kube-system -c minikube -w -V
 
+EXIT CODES:
+   0   No --fail-on-* condition was met
+   1   Invalid flags or kubeconfig, or access to the pods denied
+   2   Errors found: an error matched --fail-on-type or --fail-on-namespace
+   3   Threshold exceeded: a namespace score reached --fail-on-score
+   4   Cluster unreachable: the pods could not be listed
+   When several conditions apply, the highest code is used.
+
 OPTIONS:
    {{range .VisibleFlags}}{{.}}
    {{end}}
 `,
 	}
 
-	if err := app.Run(os.Args); err != nil {
+	// Ctrl-C cancels the context, so watch mode can exit cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := app.RunContext(ctx, os.Args); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func runCLI(c *cli.Context) error {
-	// Load kubeconfig
-	config, err := clientcmd.LoadFromFile(c.String("kubeconfig"))
-	if err != nil {
-		return fmt.Errorf("failed to load kubeconfig: %v", err)
+// kubeFlags are the cluster selection flags shared by all commands
+func kubeFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "kubeconfig",
+			Aliases: []string{"k"},
+			Usage:   "Path to kubeconfig file (default: the files in $KUBECONFIG, or ~/.kube/config)",
+		},
+		&cli.StringFlag{
+			Name:    "namespace",
+			Aliases: []string{"n"},
+			Usage:   "Filter by namespace (default: all namespaces)",
+		},
+		&cli.StringFlag{
+			Name:    "context",
+			Aliases: []string{"c"},
+			Usage:   "Use specific Kubernetes context for this invocation (the kubeconfig is not modified)",
+		},
 	}
+}
 
-	// Switch context if specified
-	if ctx := c.String("context"); ctx != "" {
-		if err := switchContext(ctx, c.String("kubeconfig")); err != nil {
-			return fmt.Errorf("failed to switch context: %v", err)
+// kubeFlag returns a cluster selection flag, preferring the value set on the
+// command over one set before it (e.g. "-c prod tui")
+func kubeFlag(c *cli.Context, name string) string {
+	for _, ctx := range c.Lineage() {
+		if ctx.IsSet(name) {
+			return ctx.String(name)
 		}
 	}
+	return c.String(name)
+}
 
-	// Build kubernetes client
-	restConfig, err := clientcmd.BuildConfigFromFlags("", c.String("kubeconfig"))
+// detectorFlag turns off built-in detectors, like monitoring.detectors of
+// the backend configuration
+func detectorFlag() cli.Flag {
+	return &cli.StringSliceFlag{
+		Name:  "disable-detector",
+		Usage: "Skip these detectors (comma separated): " + strings.Join(detect.Names(), ", "),
+	}
+}
+
+// newDetectors returns the detectors to run, leaving out those disabled on
+// the command or before it
+func newDetectors(c *cli.Context) (*detect.Registry, error) {
+	var disabled []string
+	for _, ctx := range c.Lineage() {
+		if ctx.IsSet("disable-detector") {
+			disabled = ctx.StringSlice("disable-detector")
+			break
+		}
+	}
+	detectors, err := detect.NewRegistry(disabled)
 	if err != nil {
-		return fmt.Errorf("failed to build config: %v", err)
+		return nil, fmt.Errorf("invalid --disable-detector: %v", err)
 	}
+	return detectors, nil
+}
 
-	clientset, err := kubernetes.NewForConfig(restConfig)
+func runCLI(c *cli.Context) error {
+	if err := validateOutput(c.String("output")); err != nil {
+		return err
+	}
+
+	// Build kubernetes client; --context only applies to this invocation
+	clientset, metadataClient, kubeContext, err := newClients(c.String("kubeconfig"), c.String("context"))
 	if err != nil {
-		return fmt.Errorf("failed to create client: %v", err)
+		return err
 	}
 
-	// Print current context
-	fmt.Printf("Context: %s\n", config.CurrentContext)
-	if c.String("namespace") != "" {
-		fmt.Printf("Namespace: %s\n", c.String("namespace"))
+	output := c.String("output")
+	groupBy := c.String("group-by")
+	if groupBy != groupByPod && groupBy != groupByWorkload {
+		return fmt.Errorf("--group-by must be %s or %s", groupByPod, groupByWorkload)
+	}
+	policy, err := newFailPolicy(c)
+	if err != nil {
+		return err
+	}
+	quiet := c.Bool("quiet")
+	detectors, err := newDetectors(c)
+	if err != nil {
+		return err
 	}
-	fmt.Println()
 
-	// Get and display errors
-	return displayErrors(clientset, c.String("namespace"))
-}
+	if c.Bool("watch") {
+		interval := c.Duration("interval")
+		if interval <= 0 {
+			return fmt.Errorf("--interval must be positive")
+		}
+		if output != outputTable && output != outputWide {
+			return fmt.Errorf("--watch only supports the table and wide outputs")
+		}
+		if policy.enabled() || quiet {
+			return fmt.Errorf("--watch cannot be combined with --fail-on-* or --quiet")
+		}
+		if groupBy != groupByPod {
+			return fmt.Errorf("--watch cannot be combined with --group-by %s", groupBy)
+		}
+		return watchErrors(c.Context, clientset, metadataClient, kubeContext, c.String("namespace"), detectors, interval, output == outputWide)
+	}
 
-func calculateNamespaceStats(errors []podError) []namespaceStats {
-	// Group by namespace
-	statsMap := make(map[string]*namespaceStats)
+	pods, err := listPods(c.Context, clientset, c.String("namespace"))
+	if err != nil {
+		return queryFailed(kubeContext, err)
+	}
+	lists := listWorkloads(c.Context, clientset, c.String("namespace"))
+	index := lists.index(pods)
+	events := listPodEvents(c.Context, clientset, c.String("namespace"))
+	configs := newConfigGetter(c.Context, clientset, metadataClient)
+	allErrors := findPodErrors(pods, events, index, configs, detectors)
+
+	var workloads []workloadStats
+	if groupBy == groupByWorkload {
+		desired := lists.desiredReplicas(c.Context, clientset, c.String("namespace"))
+		workloads = groupWorkloads(pods, index, desired, allErrors)
+	}
 
-	// Initialize stats for each namespace
-	for _, err := range errors {
-		if _, exists := statsMap[err.namespace]; !exists {
-			statsMap[err.namespace] = &namespaceStats{
-				name:       err.namespace,
-				uniquePods: make(map[string]bool),
-				errorTypes: make(map[string]int),
+	if !quiet {
+		// Machine readable output must not be preceded by the header
+		if output == outputTable || output == outputWide {
+			fmt.Printf("Context: %s\n", kubeContext)
+			if c.String("namespace") != "" {
+				fmt.Printf("Namespace: %s\n", c.String("namespace"))
 			}
+			fmt.Println()
 		}
 
-		stats := statsMap[err.namespace]
-		stats.totalErrors++
-		stats.uniquePods[err.podName] = true
-		stats.errorTypes[err.errorType]++
-		stats.totalRestarts += err.restartCount
-
-		// Count specific error types
-		switch err.errorType {
-		case "CrashLoopBackOff":
-			stats.crashLoopCount++
-		case "ImagePullBackOff", "ErrImagePull":
-			stats.imagePullCount++
-		case "HighRestartCount":
-			stats.highRestartCount++
+		// Display errors
+		if err := displayErrors(allErrors, workloads, kubeContext, output); err != nil {
+			return err
 		}
 	}
 
-	// Calculate scores and convert to slice
+	if !policy.enabled() {
+		return nil
+	}
+
+	// Violations go to stderr unless they are all that is printed, so they
+	// never mix with machine readable output
+	violations, code := policy.check(calculateNamespaceStats(allErrors))
+	out := os.Stderr
+	if quiet {
+		out = os.Stdout
+	}
+	for _, v := range violations {
+		fmt.Fprintf(out, "FAIL %s\n", v)
+	}
+	if code != exitOK {
+		return cli.Exit("", code)
+	}
+	return nil
+}
+
+func calculateNamespaceStats(errors []podError) []namespaceStats {
+	byNamespace := make(map[string][]detect.Finding)
+	errorTypes := make(map[string]map[string]int)
+	for _, err := range errors {
+		if _, exists := errorTypes[err.Namespace]; !exists {
+			errorTypes[err.Namespace] = make(map[string]int)
+		}
+		byNamespace[err.Namespace] = append(byNamespace[err.Namespace], err.Finding)
+		errorTypes[err.Namespace][err.ErrorType]++
+	}
+
+	// Scored like the backend with its default weights
 	var results []namespaceStats
-	for _, stats := range statsMap {
-		// Scoring formula:
-		// - CrashLoopBackOff: 3 points
-		// - ImagePull issues: 2 points
-		// - High restart count: 2 points
-		// - Other errors: 1 point
-		// - Each restart: 0.1 points
-		stats.score = float64(stats.crashLoopCount*3+
-			stats.imagePullCount*2+
-			stats.highRestartCount*2+
-			(stats.totalErrors-stats.crashLoopCount-stats.imagePullCount-stats.highRestartCount)) +
-			float64(stats.totalRestarts)*0.1
-
-		results = append(results, *stats)
+	for namespace, findings := range byNamespace {
+		tally := detect.TallyFindings(findings, detect.ScoreOptions{})
+		results = append(results, namespaceStats{
+			name:       namespace,
+			counts:     tally.Counts,
+			uniquePods: tally.Pods,
+			errorTypes: errorTypes[namespace],
+			score:      tally.Score(detect.DefaultWeights),
+		})
 	}
 
 	// Sort by score in descending order
@@ -232,93 +435,112 @@ func calculateNamespaceStats(errors []podError) []namespaceStats {
 	return results
 }
 
-func displayErrors(clientset *kubernetes.Clientset, namespace string) error {
-	// Get pods
-	pods, err := clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{})
+// listPods lists the pods of namespace (all when empty)
+func listPods(ctx context.Context, clientset kubernetes.Interface, namespace string) ([]*v1.Pod, error) {
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to list pods: %v", err)
+		return nil, fmt.Errorf("failed to list pods: %v", err)
 	}
 
+	podList := make([]*v1.Pod, 0, len(pods.Items))
+	for i := range pods.Items {
+		podList = append(podList, &pods.Items[i])
+	}
+	return podList, nil
+}
+
+// displayErrors prints the errors, grouped by workload when workloads is
+// not nil
+func displayErrors(allErrors []podError, workloads []workloadStats, cluster, output string) error {
+	switch output {
+	case outputTable, outputWide:
+		if workloads != nil {
+			printWorkloads(allErrors, workloads)
+		} else {
+			printErrors(allErrors, nil, output == outputWide)
+		}
+		return nil
+	default:
+		return writeReport(os.Stdout, output, cluster, allErrors, workloads)
+	}
+}
+
+// findPodErrors runs the detectors over the given pods and workloads.
+// ConfigMap and Secret references are not checked, as the CLI does not read
+// Secrets.
+func findPodErrors(pods []*v1.Pod, events *detect.EventIndex, workloads *detect.Workloads, configs detect.ConfigLookup, detectors *detect.Registry) []podError {
 	var allErrors []podError
-	// Collect all errors
-	for _, pod := range pods.Items {
-		if pod.Status.Phase == v1.PodFailed {
-			allErrors = append(allErrors, podError{
-				namespace:    pod.Namespace,
-				podName:      pod.Name,
-				errorType:    "PodFailed",
-				errorMessage: "Pod is in Failed phase",
-			})
-			continue
+	now := time.Now()
+	for _, pod := range pods {
+		findings := detectors.Pod(&detect.Input{
+			Pod:       pod,
+			Events:    events.ForPod(pod),
+			Configs:   configs,
+			Workloads: workloads,
+			Settings:  detect.DefaultSettings,
+			Now:       now,
+		})
+		for _, finding := range findings {
+			allErrors = append(allErrors, newPodError(finding, pod))
 		}
+	}
 
-		for _, containerStatus := range pod.Status.ContainerStatuses {
-			if containerStatus.RestartCount > 5 {
-				allErrors = append(allErrors, podError{
-					namespace:     pod.Namespace,
-					podName:       pod.Name,
-					errorType:     "HighRestartCount",
-					errorMessage:  "Container has restarted multiple times",
-					containerName: containerStatus.Name,
-					restartCount:  containerStatus.RestartCount,
-				})
-			}
+	for _, finding := range detectors.Workloads(workloads, detect.DefaultSettings, now) {
+		allErrors = append(allErrors, podError{Finding: finding})
+	}
+	return allErrors
+}
 
-			if containerStatus.State.Waiting != nil {
-				reason := containerStatus.State.Waiting.Reason
-				errorTypes := map[string]bool{
-					"ImagePullBackOff":     true,
-					"CrashLoopBackOff":     true,
-					"ErrImagePull":         true,
-					"CreateContainerError": true,
-					"InvalidImageName":     true,
-					"ImageInspectError":    true,
-					"ErrImageNeverPull":    true,
-				}
-
-				if errorTypes[reason] {
-					allErrors = append(allErrors, podError{
-						namespace:     pod.Namespace,
-						podName:       pod.Name,
-						errorType:     reason,
-						errorMessage:  containerStatus.State.Waiting.Message,
-						containerName: containerStatus.Name,
-						restartCount:  containerStatus.RestartCount,
-					})
-				}
-			}
+// newPodError adds where the pod of a finding runs
+func newPodError(finding detect.Finding, pod *v1.Pod) podError {
+	e := podError{
+		Finding:  finding,
+		nodeName: pod.Spec.NodeName,
+		created:  pod.CreationTimestamp.Time,
+	}
+	for _, container := range podContainers(pod) {
+		if container.name == e.ContainerName {
+			e.image = container.image
 		}
 	}
+	return e
+}
 
-	// Calculate namespace statistics
-	stats := calculateNamespaceStats(allErrors)
+// exitLabel is the exit code and signal of an error, e.g. "137 (SIGKILL)"
+func exitLabel(e podError) string {
+	if e.ExitCode == 0 {
+		return "-"
+	}
+	if e.Signal == "" {
+		return fmt.Sprint(e.ExitCode)
+	}
+	return fmt.Sprintf("%d (%s)", e.ExitCode, e.Signal)
+}
 
-	// Display namespace statistics
-	fmt.Println("\nNamespace Statistics (sorted by severity):")
-	fmt.Println("----------------------------------------")
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintf(w, "NAMESPACE\tSCORE\tTOTAL ERRORS\tUNIQUE PODS\tCRASHLOOP\tIMAGE PULL\tHIGH RESTARTS\tTOTAL RESTARTS\n")
-	fmt.Fprintf(w, "---------\t-----\t------------\t-----------\t---------\t----------\t-------------\t--------------\n")
+// podLabel is the pod of an error, or the Job or CronJob it is about
+func podLabel(e podError) string {
+	if e.PodName == "" {
+		return e.Owner
+	}
+	return e.PodName
+}
 
-	for _, ns := range stats {
-		fmt.Fprintf(w, "%s\t%.1f\t%d\t%d\t%d\t%d\t%d\t%d\n",
-			ns.name,
-			ns.score,
-			ns.totalErrors,
-			len(ns.uniquePods),
-			ns.crashLoopCount,
-			ns.imagePullCount,
-			ns.highRestartCount,
-			ns.totalRestarts,
-		)
+// containerLabel is the container name of an error, with its kind unless
+// it is a regular container
+func containerLabel(e podError) string {
+	if e.ContainerKind == "" || e.ContainerKind == detect.ContainerKindRegular {
+		return e.ContainerName
 	}
-	w.Flush()
-	fmt.Println("\nScoring formula:")
-	fmt.Println("- CrashLoopBackOff: 3 points")
-	fmt.Println("- ImagePull issues: 2 points")
-	fmt.Println("- High restart count: 2 points")
-	fmt.Println("- Other errors: 1 point")
-	fmt.Println("- Each restart: 0.1 points")
+	return fmt.Sprintf("%s [%s]", e.ContainerName, e.ContainerKind)
+}
+
+// printErrors prints the namespace statistics and the detailed errors.
+// Errors whose key is in added are highlighted; wide adds the node, owner,
+// image and age of each error.
+func printErrors(allErrors []podError, added map[string]bool, wide bool) {
+	// Calculate namespace statistics
+	stats := calculateNamespaceStats(allErrors)
+	printNamespaceStats(stats)
 
 	// Display detailed errors by namespace
 	fmt.Println("\nDetailed Errors by Namespace:")
@@ -327,7 +549,7 @@ func displayErrors(clientset *kubernetes.Clientset, namespace string) error {
 	// Group errors by namespace
 	namespaceErrors := make(map[string][]podError)
 	for _, err := range allErrors {
-		namespaceErrors[err.namespace] = append(namespaceErrors[err.namespace], err)
+		namespaceErrors[err.Namespace] = append(namespaceErrors[err.Namespace], err)
 	}
 
 	// Sort namespaces by score
@@ -335,33 +557,173 @@ func displayErrors(clientset *kubernetes.Clientset, namespace string) error {
 		errors := namespaceErrors[ns.name]
 		if len(errors) > 0 {
 			fmt.Printf("\nNamespace: %s (Score: %.1f, %d errors)\n", ns.name, ns.score, len(errors))
-			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-			fmt.Fprintf(w, "POD\tCONTAINER\tTYPE\tRESTARTS\tMESSAGE\n")
-			fmt.Fprintf(w, "---\t---------\t----\t--------\t-------\n")
-
+			rows := make([]errorRow, 0, len(errors))
 			for _, err := range errors {
-				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n",
-					err.podName,
-					err.containerName,
-					err.errorType,
-					err.restartCount,
-					err.errorMessage,
-				)
+				rows = append(rows, errorRow{err: err, added: added[err.key()]})
 			}
-			w.Flush()
+			printErrorTable(rows, added != nil, wide)
 			fmt.Println()
 		}
 	}
+}
 
-	return nil
+// printNamespaceStats prints the namespace statistics and the scoring formula
+func printNamespaceStats(stats []namespaceStats) {
+	// Display namespace statistics
+	fmt.Println("\nNamespace Statistics (sorted by severity):")
+	fmt.Println("----------------------------------------")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "NAMESPACE\tSCORE\tTOTAL ERRORS\tUNIQUE PODS\tCRASHLOOP\tIMAGE PULL\tHIGH RESTARTS\tPENDING\tROLLOUT\tTOTAL RESTARTS\n")
+	fmt.Fprintf(w, "---------\t-----\t------------\t-----------\t---------\t----------\t-------------\t-------\t-------\t--------------\n")
+
+	for _, ns := range stats {
+		fmt.Fprintf(w, "%s\t%.1f\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\n",
+			ns.name,
+			ns.score,
+			ns.counts.Total,
+			ns.uniquePods,
+			ns.counts.CrashLoop,
+			ns.counts.ImagePull,
+			ns.counts.HighRestarts,
+			ns.counts.Pending,
+			ns.counts.Rollout,
+			ns.counts.Restarts,
+		)
+	}
+	w.Flush()
+	weights := detect.DefaultWeights
+	fmt.Println("\nScoring formula:")
+	fmt.Printf("- CrashLoopBackOff: %g points\n", weights.CrashLoop)
+	fmt.Printf("- ImagePull issues: %g points\n", weights.ImagePull)
+	fmt.Printf("- High restart count: %g points\n", weights.HighRestarts)
+	fmt.Printf("- Unschedulable or stuck Pending: %g points\n", weights.Pending)
+	fmt.Printf("- Stuck rollout or unavailable replicas: %g points\n", weights.Rollout)
+	fmt.Printf("- Other errors: %g points\n", weights.OtherErrors)
+	fmt.Printf("- Each restart above the threshold: %g points\n", weights.RestartMultiplier)
+}
+
+// errorRow is one line of an error table
+type errorRow struct {
+	err      podError
+	added    bool
+	resolved bool
+}
+
+// printErrorTable prints errors as a table. With marks, a first column flags
+// errors that appeared (+) or resolved (-) and colors their lines.
+func printErrorTable(rows []errorRow, marks, wide bool) {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	if marks {
+		fmt.Fprint(w, " \t")
+	}
+	fmt.Fprintf(w, "POD\tCONTAINER\tTYPE\tRESTARTS\t")
+	if wide {
+		fmt.Fprintf(w, "NODE\tOWNER\tIMAGE\tAGE\tEXIT\tEVENTS\t")
+	}
+	fmt.Fprintf(w, "MESSAGE\n")
+	if marks {
+		fmt.Fprint(w, " \t")
+	}
+	fmt.Fprintf(w, "---\t---------\t----\t--------\t")
+	if wide {
+		fmt.Fprintf(w, "----\t-----\t-----\t---\t----\t------\t")
+	}
+	fmt.Fprintf(w, "-------\n")
+
+	for _, row := range rows {
+		if marks {
+			mark := " "
+			if row.added {
+				mark = "+"
+			} else if row.resolved {
+				mark = "-"
+			}
+			fmt.Fprintf(w, "%s\t", mark)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t",
+			podLabel(row.err),
+			containerLabel(row.err),
+			row.err.ErrorType,
+			row.err.RestartCount,
+		)
+		if wide {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t",
+				orDash(row.err.nodeName),
+				orDash(row.err.Owner),
+				orDash(row.err.image),
+				formatAge(row.err.created),
+				exitLabel(row.err),
+				eventsLabel(row.err.Events),
+			)
+		}
+		fmt.Fprintf(w, "%s\n", strings.ReplaceAll(row.err.ErrorMessage, "\n", " "))
+	}
+	w.Flush()
+
+	// Color after aligning, so escape codes do not skew the column widths
+	for i, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if marks && i >= 2 {
+			switch {
+			case rows[i-2].added:
+				line = addedStyle.Render(line)
+			case rows[i-2].resolved:
+				line = resolvedStyle.Render(line)
+			}
+		}
+		fmt.Println(line)
+	}
 }
 
-func switchContext(newContext, kubeconfig string) error {
-	config, err := clientcmd.LoadFromFile(kubeconfig)
+// loadKubeconfig loads the kubeconfig at path, or else merges the files
+// listed in KUBECONFIG, or else reads ~/.kube/config
+func loadKubeconfig(path string) (*clientcmdapi.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = path
+
+	config, err := rules.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %v", err)
+	}
+	return config, nil
+}
+
+// newClients builds the clients for a context of the kubeconfig, or for its
+// current context when kubeContext is empty, and returns the context used.
+// The metadata client reads objects without their contents, like the
+// Secrets pods refer to. The kubeconfig files are never written.
+func newClients(kubeconfig, kubeContext string) (*kubernetes.Clientset, metadata.Interface, string, error) {
+	config, err := loadKubeconfig(kubeconfig)
 	if err != nil {
-		return fmt.Errorf("failed to load kubeconfig: %v", err)
+		return nil, nil, "", err
 	}
 
+	if kubeContext != "" {
+		if err := switchContext(config, kubeContext); err != nil {
+			return nil, nil, "", fmt.Errorf("failed to switch context: %v", err)
+		}
+	}
+
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: config.CurrentContext}
+	restConfig, err := clientcmd.NewDefaultClientConfig(*config, overrides).ClientConfig()
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to build config: %v", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to create client: %v", err)
+	}
+	metadataClient, err := metadata.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to create client: %v", err)
+	}
+	return clientset, metadataClient, config.CurrentContext, nil
+}
+
+// switchContext selects newContext in the loaded config after checking that
+// it points to a usable cluster. Only the in-memory config changes.
+func switchContext(config *clientcmdapi.Config, newContext string) error {
 	// Clean the context name
 	newContext = strings.TrimSpace(newContext)
 
@@ -386,5 +748,5 @@ func switchContext(newContext, kubeconfig string) error {
 	}
 
 	config.CurrentContext = newContext
-	return clientcmd.WriteToFile(*config, kubeconfig)
+	return nil
 }
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"pod-error-monitor/detect"
+)
+
+// TestNamespaceStatsParity expects the stats the backend test of the same
+// name pins for its default weights
+func TestNamespaceStatsParity(t *testing.T) {
+	findings := []detect.Finding{
+		{Namespace: "shop", PodName: "api-1", ErrorType: "CrashLoopBackOff", RestartCount: 12, Owner: "Deployment/api"},
+		{Namespace: "shop", PodName: "api-1", ErrorType: "HighRestartCount", RestartCount: 12, Owner: "Deployment/api"},
+		{Namespace: "shop", PodName: "api-2", ErrorType: "ImagePullBackOff", Owner: "Deployment/api"},
+		{Namespace: "shop", ErrorType: "JobFailed", Owner: "Job/backup"},
+		{Namespace: "payments", PodName: "pay-1", ErrorType: "Unschedulable", Owner: "Deployment/pay"},
+		{Namespace: "payments", ErrorType: "UnavailableReplicas", Owner: "StatefulSet/db"},
+		{Namespace: "payments", PodName: "pay-2", ErrorType: "OOMKilled", RestartCount: 3, Owner: "Deployment/pay"},
+	}
+	want := []NamespaceStats{
+		{Cluster: "test", Name: "shop", TotalErrors: 4, Score: 9.2, UniquePods: 2, CrashLoop: 1, ImagePull: 1, HighRestarts: 1, TotalRestarts: 12},
+		{Cluster: "test", Name: "payments", TotalErrors: 3, Score: 6, UniquePods: 2, Pending: 1, Rollout: 1},
+	}
+
+	errors := make([]podError, 0, len(findings))
+	for _, finding := range findings {
+		errors = append(errors, podError{Finding: finding})
+	}
+	got := newErrorReport("test", errors, nil).Namespaces
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"pod-error-monitor/detect"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	outputTable    = "table"
+	outputWide     = "wide"
+	outputJSON     = "json"
+	outputYAML     = "yaml"
+	outputCSV      = "csv"
+	outputMarkdown = "markdown"
+)
+
+var outputFormats = []string{outputTable, outputWide, outputJSON, outputYAML, outputCSV, outputMarkdown}
+
+func validateOutput(output string) error {
+	for _, format := range outputFormats {
+		if output == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown output format %q, expected one of %s", output, strings.Join(outputFormats, ", "))
+}
+
+// PodError is a finding of the detectors, whose JSON the backend API
+// serves as well
+type PodError = detect.Finding
+
+// PodEvent is an event of a pod error
+type PodEvent = detect.PodEvent
+
+// NamespaceStats mirrors the JSON of the backend API
+type NamespaceStats struct {
+	Cluster       string  `json:"cluster"`
+	Name          string  `json:"name"`
+	TotalErrors   int     `json:"totalErrors"`
+	Score         float64 `json:"score"`
+	UniquePods    int     `json:"uniquePods"`
+	CrashLoop     int     `json:"crashLoop"`
+	ImagePull     int     `json:"imagePull"`
+	HighRestarts  int     `json:"highRestarts"`
+	Pending       int     `json:"pending"`
+	Rollout       int     `json:"rollout"`
+	Custom        int     `json:"custom"`
+	TotalRestarts int32   `json:"totalRestarts"`
+}
+
+// WorkloadStats mirrors the workloads endpoint of the backend API
+type WorkloadStats struct {
+	Cluster         string         `json:"cluster"`
+	Namespace       string         `json:"namespace"`
+	Kind            string         `json:"kind"`
+	Name            string         `json:"name"`
+	DesiredReplicas int32          `json:"desiredReplicas"`
+	Pods            int            `json:"pods"`
+	AffectedPods    int            `json:"affectedPods"`
+	TotalErrors     int            `json:"totalErrors"`
+	ErrorTypes      map[string]int `json:"errorTypes"`
+	TotalRestarts   int32          `json:"totalRestarts"`
+	Score           float64        `json:"score"`
+}
+
+// errorReport is the document written by the json and yaml outputs. It
+// only holds workloads when the errors are grouped by workload.
+type errorReport struct {
+	Namespaces []NamespaceStats `json:"namespaces"`
+	Workloads  []WorkloadStats  `json:"workloads,omitempty"`
+	Errors     []PodError       `json:"errors"`
+}
+
+func newErrorReport(cluster string, errors []podError, workloads []workloadStats) errorReport {
+	report := errorReport{
+		Namespaces: []NamespaceStats{},
+		Errors:     make([]PodError, 0, len(errors)),
+	}
+
+	for _, ns := range calculateNamespaceStats(errors) {
+		report.Namespaces = append(report.Namespaces, NamespaceStats{
+			Cluster:       cluster,
+			Name:          ns.name,
+			TotalErrors:   ns.counts.Total,
+			Score:         ns.score,
+			UniquePods:    ns.uniquePods,
+			CrashLoop:     ns.counts.CrashLoop,
+			ImagePull:     ns.counts.ImagePull,
+			HighRestarts:  ns.counts.HighRestarts,
+			Pending:       ns.counts.Pending,
+			Rollout:       ns.counts.Rollout,
+			Custom:        ns.counts.Custom,
+			TotalRestarts: ns.counts.Restarts,
+		})
+	}
+
+	// Errors follow the namespaces in score order
+	byNamespace := make(map[string][]podError)
+	for _, e := range errors {
+		byNamespace[e.Namespace] = append(byNamespace[e.Namespace], e)
+	}
+	for _, ns := range report.Namespaces {
+		for _, e := range byNamespace[ns.Name] {
+			finding := e.Finding
+			finding.Cluster = cluster
+			report.Errors = append(report.Errors, finding)
+		}
+	}
+
+	for _, workload := range workloads {
+		report.Workloads = append(report.Workloads, WorkloadStats{
+			Cluster:         cluster,
+			Namespace:       workload.namespace,
+			Kind:            workload.kind,
+			Name:            workload.name,
+			DesiredReplicas: workload.desired,
+			Pods:            workload.pods,
+			AffectedPods:    workload.affectedPods,
+			TotalErrors:     workload.totalErrors,
+			ErrorTypes:      workload.errorTypes,
+			TotalRestarts:   workload.totalRestarts,
+			Score:           workload.score,
+		})
+	}
+
+	return report
+}
+
+// writeReport writes the errors in one of the machine readable formats
+func writeReport(w io.Writer, output, cluster string, errors []podError, workloads []workloadStats) error {
+	report := newErrorReport(cluster, errors, workloads)
+
+	switch output {
+	case outputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case outputYAML:
+		data, err := yaml.Marshal(report)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case outputCSV:
+		if workloads != nil {
+			return writeWorkloadsCSV(w, report)
+		}
+		return writeCSV(w, report)
+	case outputMarkdown:
+		return writeMarkdown(w, report)
+	}
+	return fmt.Errorf("unknown output format %q", output)
+}
+
+// writeCSV writes one row per pod error, carrying the score of its namespace
+func writeCSV(w io.Writer, report errorReport) error {
+	scores := make(map[string]float64)
+	for _, ns := range report.Namespaces {
+		scores[ns.Name] = ns.Score
+	}
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"cluster", "namespace", "namespaceScore", "podName", "containerName", "containerKind", "errorType", "restartCount", "exitCode", "signal", "finishedAt", "owner", "errorMessage", "events"})
+	for _, e := range report.Errors {
+		out.Write([]string{
+			e.Cluster,
+			e.Namespace,
+			strconv.FormatFloat(scores[e.Namespace], 'f', 1, 64),
+			e.PodName,
+			e.ContainerName,
+			e.ContainerKind,
+			e.ErrorType,
+			strconv.Itoa(int(e.RestartCount)),
+			strconv.Itoa(int(e.ExitCode)),
+			e.Signal,
+			e.FinishedAt,
+			e.Owner,
+			e.ErrorMessage,
+			csvEvents(e.Events),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// writeWorkloadsCSV writes one row per workload
+func writeWorkloadsCSV(w io.Writer, report errorReport) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"cluster", "namespace", "kind", "name", "score", "affectedPods", "desiredReplicas", "pods", "totalErrors", "totalRestarts", "errorTypes"})
+	for _, workload := range report.Workloads {
+		out.Write([]string{
+			workload.Cluster,
+			workload.Namespace,
+			workload.Kind,
+			workload.Name,
+			strconv.FormatFloat(workload.Score, 'f', 1, 64),
+			strconv.Itoa(workload.AffectedPods),
+			strconv.Itoa(int(workload.DesiredReplicas)),
+			strconv.Itoa(workload.Pods),
+			strconv.Itoa(workload.TotalErrors),
+			strconv.Itoa(int(workload.TotalRestarts)),
+			strings.ReplaceAll(errorTypesLabel(workload.ErrorTypes), ", ", "; "),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// csvEvents joins events as "Reason xCount" pairs
+func csvEvents(events []PodEvent) string {
+	labels := make([]string, 0, len(events))
+	for _, event := range events {
+		labels = append(labels, fmt.Sprintf("%s x%d", event.Reason, event.Count))
+	}
+	return strings.Join(labels, "; ")
+}
+
+// writeMarkdown writes the namespace stats and the errors as Markdown tables
+func writeMarkdown(w io.Writer, report errorReport) error {
+	cell := func(value string) string {
+		value = strings.ReplaceAll(value, "\n", " ")
+		return strings.ReplaceAll(value, "|", "\\|")
+	}
+
+	fmt.Fprintln(w, "## Namespaces")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "| Namespace | Score | Total errors | Unique pods | CrashLoop | Image pull | High restarts | Pending | Rollout | Total restarts |")
+	fmt.Fprintln(w, "|---|---:|---:|---:|---:|---:|---:|---:|---:|---:|")
+	for _, ns := range report.Namespaces {
+		fmt.Fprintf(w, "| %s | %.1f | %d | %d | %d | %d | %d | %d | %d | %d |\n",
+			cell(ns.Name), ns.Score, ns.TotalErrors, ns.UniquePods,
+			ns.CrashLoop, ns.ImagePull, ns.HighRestarts, ns.Pending, ns.Rollout, ns.TotalRestarts)
+	}
+
+	if len(report.Workloads) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "## Workloads")
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "| Namespace | Workload | Score | Affected | Errors | Restarts | Types |")
+		fmt.Fprintln(w, "|---|---|---:|---:|---:|---:|---|")
+		for _, workload := range report.Workloads {
+			fmt.Fprintf(w, "| %s | %s | %.1f | %d/%d | %d | %d | %s |\n",
+				cell(workload.Namespace), cell(workload.Kind+"/"+workload.Name), workload.Score,
+				workload.AffectedPods, workload.DesiredReplicas, workload.TotalErrors,
+				workload.TotalRestarts, cell(errorTypesLabel(workload.ErrorTypes)))
+		}
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "## Pod errors")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "| Namespace | Pod | Container | Kind | Type | Restarts | Owner | Message |")
+	fmt.Fprintln(w, "|---|---|---|---|---|---:|---|---|")
+	for _, e := range report.Errors {
+		_, err := fmt.Fprintf(w, "| %s | %s | %s | %s | %s | %d | %s | %s |\n",
+			cell(e.Namespace), cell(e.PodName), cell(e.ContainerName), cell(e.ContainerKind), cell(e.ErrorType),
+			e.RestartCount, cell(e.Owner), cell(e.ErrorMessage))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// formatAge prints the time since t the way kubectl does (e.g. 5m, 3h, 12d)
+func formatAge(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	d := time.Since(t)
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd", int(d.Hours()/24))
+}
@@ -0,0 +1,786 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"pod-error-monitor/detect"
+
+	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/urfave/cli/v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// tuiSyncTimeout bounds the initial pod list of a context
+	tuiSyncTimeout = 30 * time.Second
+	// tuiRequestTimeout bounds loading events and logs
+	tuiRequestTimeout = 15 * time.Second
+	// tuiLogLines is how many lines of previous logs are shown
+	tuiLogLines = 500
+)
+
+type tuiView int
+
+const (
+	viewNamespaces tuiView = iota
+	viewErrors
+	viewPod
+	viewDetail
+	viewContexts
+)
+
+var (
+	statusStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+	errorStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+	titleStyle  = lipgloss.NewStyle().Bold(true).Padding(0, 1).
+			Foreground(lipgloss.Color("230")).Background(lipgloss.Color("62"))
+)
+
+type namespaceItem struct {
+	stats namespaceStats
+}
+
+func (i namespaceItem) Title() string { return i.stats.name }
+
+func (i namespaceItem) Description() string {
+	return fmt.Sprintf("score %.1f · %d errors in %d pods · crashloop %d · image pull %d · high restarts %d · pending %d",
+		i.stats.score, i.stats.counts.Total, i.stats.uniquePods,
+		i.stats.counts.CrashLoop, i.stats.counts.ImagePull, i.stats.counts.HighRestarts, i.stats.counts.Pending)
+}
+
+func (i namespaceItem) FilterValue() string { return i.stats.name }
+
+type errorItem struct {
+	err podError
+}
+
+func (i errorItem) Title() string {
+	if i.err.ContainerName == "" {
+		return fmt.Sprintf("%s  %s", podLabel(i.err), i.err.ErrorType)
+	}
+	return fmt.Sprintf("%s/%s  %s", i.err.PodName, containerLabel(i.err), i.err.ErrorType)
+}
+
+func (i errorItem) Description() string {
+	message := strings.ReplaceAll(i.err.ErrorMessage, "\n", " ")
+	return fmt.Sprintf("restarts %d · %s", i.err.RestartCount, message)
+}
+
+func (i errorItem) FilterValue() string {
+	return podLabel(i.err) + " " + i.err.ContainerName + " " + i.err.ErrorType
+}
+
+type contextItem struct {
+	name    string
+	current bool
+}
+
+func (i contextItem) Title() string {
+	if i.current {
+		return i.name + " (current)"
+	}
+	return i.name
+}
+
+func (i contextItem) Description() string { return "" }
+
+func (i contextItem) FilterValue() string { return i.name }
+
+// watcherReadyMsg reports that the pod cache of a context synced, or failed to
+type watcherReadyMsg struct {
+	kubeContext string
+	clientset   kubernetes.Interface
+	watcher     *podWatcher
+	err         error
+}
+
+// podsChangedMsg reports that the pods of a watcher changed
+type podsChangedMsg struct {
+	watcher *podWatcher
+}
+
+// refreshTickMsg asks for the periodic refresh of a watcher, which brings
+// up and clears the errors that depend on time rather than on a change
+type refreshTickMsg struct {
+	watcher *podWatcher
+}
+
+// detailMsg carries the events or logs to show in the detail view
+type detailMsg struct {
+	title   string
+	content string
+}
+
+// tuiModel is the Bubble Tea model of the interactive mode
+type tuiModel struct {
+	kubeconfig     string
+	kubeContext    string
+	watchNamespace string
+	detectors      *detect.Registry
+	interval       time.Duration
+
+	clientset kubernetes.Interface
+	watcher   *podWatcher
+	errors    []podError
+	refreshed time.Time
+
+	view      tuiView
+	back      tuiView
+	namespace string
+	pod       string
+	container string
+
+	namespaces list.Model
+	podErrors  list.Model
+	contexts   list.Model
+	detail     viewport.Model
+	title      string
+
+	status string
+}
+
+func runTUI(c *cli.Context) error {
+	detectors, err := newDetectors(c)
+	if err != nil {
+		return err
+	}
+	kubeconfig := kubeFlag(c, "kubeconfig")
+	kubeContext := kubeFlag(c, "context")
+	if kubeContext == "" {
+		config, err := loadKubeconfig(kubeconfig)
+		if err != nil {
+			return err
+		}
+		kubeContext = config.CurrentContext
+	}
+
+	interval := c.Duration("interval")
+	if interval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+
+	m := newTUIModel(kubeconfig, kubeContext, kubeFlag(c, "namespace"), detectors, interval)
+	_, err = tea.NewProgram(m, tea.WithAltScreen(), tea.WithContext(c.Context)).Run()
+	if err == tea.ErrProgramKilled {
+		return nil
+	}
+	return err
+}
+
+func newTUIModel(kubeconfig, kubeContext, namespace string, detectors *detect.Registry, interval time.Duration) *tuiModel {
+	newList := func(title string) list.Model {
+		l := list.New(nil, list.NewDefaultDelegate(), 0, 0)
+		l.Title = title
+		l.SetShowHelp(false)
+		l.DisableQuitKeybindings()
+		return l
+	}
+
+	return &tuiModel{
+		kubeconfig:     kubeconfig,
+		kubeContext:    kubeContext,
+		watchNamespace: namespace,
+		detectors:      detectors,
+		interval:       interval,
+		namespaces:     newList("Namespaces"),
+		podErrors:      newList("Errors"),
+		contexts:       newList("Contexts"),
+		detail:         viewport.New(0, 0),
+		status:         "Connecting to " + kubeContext + "...",
+	}
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return startWatcher(m.kubeconfig, m.kubeContext, m.watchNamespace, m.detectors)
+}
+
+// startWatcher connects to a context and waits for its pod cache
+func startWatcher(kubeconfig, kubeContext, namespace string, detectors *detect.Registry) tea.Cmd {
+	return func() tea.Msg {
+		clientset, metadataClient, _, err := newClients(kubeconfig, kubeContext)
+		if err != nil {
+			return watcherReadyMsg{kubeContext: kubeContext, err: err}
+		}
+
+		watcher := newPodWatcher(clientset, metadataClient, namespace, "", detectors, watchSources{events: true, workloads: true, configs: true})
+		ctx, cancel := context.WithTimeout(context.Background(), tuiSyncTimeout)
+		defer cancel()
+		if err := watcher.sync(ctx); err != nil {
+			watcher.stop()
+			return watcherReadyMsg{kubeContext: kubeContext, err: err}
+		}
+
+		return watcherReadyMsg{kubeContext: kubeContext, clientset: clientset, watcher: watcher}
+	}
+}
+
+// waitForChange delivers the next debounced change of the watcher
+func waitForChange(watcher *podWatcher) tea.Cmd {
+	return func() tea.Msg {
+		select {
+		case <-watcher.stopCh:
+			return nil
+		case <-watcher.changed:
+		}
+		select {
+		case <-watcher.stopCh:
+			return nil
+		case <-time.After(watchDebounce):
+		}
+		return podsChangedMsg{watcher: watcher}
+	}
+}
+
+// refreshTick schedules the next periodic refresh of the watcher
+func refreshTick(watcher *podWatcher, interval time.Duration) tea.Cmd {
+	return tea.Tick(interval, func(time.Time) tea.Msg {
+		return refreshTickMsg{watcher: watcher}
+	})
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		// Leave room for the status line
+		for _, l := range []*list.Model{&m.namespaces, &m.podErrors, &m.contexts} {
+			l.SetSize(msg.Width, msg.Height-1)
+		}
+		m.detail.Width = msg.Width
+		m.detail.Height = msg.Height - 2
+		return m, nil
+
+	case watcherReadyMsg:
+		if msg.err != nil {
+			m.status = errorStyle.Render(fmt.Sprintf("Context %s: %v", msg.kubeContext, msg.err))
+			return m, nil
+		}
+		if m.watcher != nil {
+			go m.watcher.stop()
+		}
+		m.kubeContext = msg.kubeContext
+		m.clientset = msg.clientset
+		m.watcher = msg.watcher
+		m.view = viewNamespaces
+		m.status = ""
+		return m, tea.Batch(m.refresh(), waitForChange(m.watcher), refreshTick(m.watcher, m.interval))
+
+	case podsChangedMsg:
+		// Changes of a watcher replaced by a context switch are dropped
+		if msg.watcher != m.watcher {
+			return m, nil
+		}
+		return m, tea.Batch(m.refresh(), waitForChange(m.watcher))
+
+	case refreshTickMsg:
+		// The ticks of a replaced watcher stop here
+		if msg.watcher != m.watcher {
+			return m, nil
+		}
+		return m, tea.Batch(m.refresh(), refreshTick(m.watcher, m.interval))
+
+	case detailMsg:
+		m.status = ""
+		m.title = msg.title
+		m.detail.SetContent(msg.content)
+		m.detail.GotoTop()
+		m.view = viewDetail
+		return m, nil
+
+	case tea.KeyMsg:
+		if msg.String() == "ctrl+c" {
+			return m, tea.Quit
+		}
+		// A key press dismisses the last error
+		if m.watcher != nil {
+			m.status = ""
+		}
+		if l := m.activeList(); l != nil && l.FilterState() == list.Filtering {
+			// Keys belong to the filter input while typing
+			var cmd tea.Cmd
+			*l, cmd = l.Update(msg)
+			return m, cmd
+		}
+		if cmd, handled := m.handleKey(msg); handled {
+			return m, cmd
+		}
+	}
+
+	var cmd tea.Cmd
+	if l := m.activeList(); l != nil {
+		*l, cmd = l.Update(msg)
+	} else {
+		m.detail, cmd = m.detail.Update(msg)
+	}
+	return m, cmd
+}
+
+// handleKey handles the key bindings of the current view. Unhandled keys
+// are passed on to the list or viewport.
+func (m *tuiModel) handleKey(msg tea.KeyMsg) (tea.Cmd, bool) {
+	key := msg.String()
+
+	switch key {
+	case "q":
+		return tea.Quit, true
+	case "esc", "backspace":
+		if l := m.activeList(); l != nil && l.FilterState() == list.FilterApplied {
+			l.ResetFilter()
+			return nil, true
+		}
+		return nil, m.goBack()
+	}
+
+	switch m.view {
+	case viewNamespaces:
+		switch key {
+		case "enter":
+			if item, ok := m.namespaces.SelectedItem().(namespaceItem); ok {
+				m.namespace = item.stats.name
+				m.podErrors.ResetFilter()
+				m.podErrors.Select(0)
+				m.updateErrorList()
+				m.view = viewErrors
+			}
+			return nil, true
+		case "c":
+			return m.openContexts(), true
+		}
+
+	case viewErrors:
+		item, ok := m.podErrors.SelectedItem().(errorItem)
+		if !ok {
+			return nil, false
+		}
+		// Errors of a Job or CronJob have no pod to show
+		if item.err.PodName == "" {
+			return nil, false
+		}
+		switch key {
+		case "enter":
+			m.pod, m.container = item.err.PodName, item.err.ContainerName
+			m.showPod()
+			return nil, true
+		case "e":
+			m.back = viewErrors
+			return m.loadEvents(item.err.Namespace, item.err.PodName), true
+		case "l":
+			m.back = viewErrors
+			return m.loadLogs(item.err.Namespace, item.err.PodName, item.err.ContainerName), true
+		}
+
+	case viewPod:
+		switch key {
+		case "e":
+			m.back = viewPod
+			return m.loadEvents(m.namespace, m.pod), true
+		case "l":
+			m.back = viewPod
+			return m.loadLogs(m.namespace, m.pod, m.container), true
+		case "tab":
+			m.nextContainer()
+			m.showPod()
+			return nil, true
+		}
+
+	case viewContexts:
+		if key == "enter" {
+			if item, ok := m.contexts.SelectedItem().(contextItem); ok {
+				m.status = "Connecting to " + item.name + "..."
+				m.view = viewNamespaces
+				return startWatcher(m.kubeconfig, item.name, m.watchNamespace, m.detectors), true
+			}
+			return nil, true
+		}
+	}
+
+	return nil, false
+}
+
+// activeList returns the list of the current view, or nil for the viewport views
+func (m *tuiModel) activeList() *list.Model {
+	switch m.view {
+	case viewNamespaces:
+		return &m.namespaces
+	case viewErrors:
+		return &m.podErrors
+	case viewContexts:
+		return &m.contexts
+	}
+	return nil
+}
+
+// goBack returns to the parent view
+func (m *tuiModel) goBack() bool {
+	switch m.view {
+	case viewErrors, viewContexts:
+		m.view = viewNamespaces
+	case viewPod:
+		m.view = viewErrors
+	case viewDetail:
+		m.view = m.back
+		if m.back == viewPod {
+			m.showPod()
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// refresh recomputes the errors from the pod cache and updates the views
+func (m *tuiModel) refresh() tea.Cmd {
+	errors, err := m.watcher.podErrors()
+	if err != nil {
+		m.status = errorStyle.Render(err.Error())
+		return nil
+	}
+	m.errors = errors
+	m.refreshed = time.Now()
+
+	stats := calculateNamespaceStats(errors)
+	items := make([]list.Item, 0, len(stats))
+	for _, ns := range stats {
+		items = append(items, namespaceItem{stats: ns})
+	}
+	m.namespaces.Title = fmt.Sprintf("Namespaces · %s", m.kubeContext)
+	cmd := m.namespaces.SetItems(items)
+
+	if m.view == viewPod {
+		m.showPod()
+	}
+	return tea.Batch(cmd, m.updateErrorList())
+}
+
+// updateErrorList shows the errors of the selected namespace
+func (m *tuiModel) updateErrorList() tea.Cmd {
+	var items []list.Item
+	for _, e := range m.errors {
+		if e.Namespace == m.namespace {
+			items = append(items, errorItem{err: e})
+		}
+	}
+	m.podErrors.Title = fmt.Sprintf("Errors · %s", m.namespace)
+	return m.podErrors.SetItems(items)
+}
+
+// showPod renders the selected pod into the viewport
+func (m *tuiModel) showPod() {
+	m.view = viewPod
+	m.title = fmt.Sprintf("Pod %s/%s", m.namespace, m.pod)
+
+	pod, err := m.watcher.lister.Pods(m.namespace).Get(m.pod)
+	if err != nil {
+		m.detail.SetContent(fmt.Sprintf("Pod %s is gone: %v", m.pod, err))
+		return
+	}
+
+	var errors []podError
+	for _, e := range m.errors {
+		if e.Namespace == m.namespace && e.PodName == m.pod {
+			errors = append(errors, e)
+		}
+	}
+	m.detail.SetContent(describePod(pod, errors, m.container))
+}
+
+// nextContainer selects the next container of the pod for the logs
+func (m *tuiModel) nextContainer() {
+	pod, err := m.watcher.lister.Pods(m.namespace).Get(m.pod)
+	if err != nil {
+		return
+	}
+	containers := podContainers(pod)
+	if len(containers) == 0 {
+		return
+	}
+
+	next := 0
+	for i, container := range containers {
+		if container.name == m.container {
+			next = (i + 1) % len(containers)
+		}
+	}
+	m.container = containers[next].name
+}
+
+// openContexts lists the contexts of the kubeconfig
+func (m *tuiModel) openContexts() tea.Cmd {
+	config, err := loadKubeconfig(m.kubeconfig)
+	if err != nil {
+		m.status = errorStyle.Render(err.Error())
+		return nil
+	}
+
+	names := make([]string, 0, len(config.Contexts))
+	for name := range config.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	items := make([]list.Item, 0, len(names))
+	selected := 0
+	for i, name := range names {
+		items = append(items, contextItem{name: name, current: name == m.kubeContext})
+		if name == m.kubeContext {
+			selected = i
+		}
+	}
+
+	m.view = viewContexts
+	cmd := m.contexts.SetItems(items)
+	m.contexts.Select(selected)
+	return cmd
+}
+
+// loadEvents fetches the events of a pod, like kubectl describe shows them
+func (m *tuiModel) loadEvents(namespace, pod string) tea.Cmd {
+	if m.clientset == nil {
+		return nil
+	}
+	clientset := m.clientset
+	m.status = "Loading events..."
+
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), tuiRequestTimeout)
+		defer cancel()
+
+		title := fmt.Sprintf("Events of %s/%s", namespace, pod)
+		events, err := clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+			FieldSelector: fields.Set{
+				"involvedObject.kind": "Pod",
+				"involvedObject.name": pod,
+			}.String(),
+		})
+		if err != nil {
+			return detailMsg{title: title, content: fmt.Sprintf("failed to list events: %v", err)}
+		}
+		return detailMsg{title: title, content: formatEvents(events.Items)}
+	}
+}
+
+// loadLogs fetches the logs of the previous instance of a container
+func (m *tuiModel) loadLogs(namespace, pod, container string) tea.Cmd {
+	if m.clientset == nil {
+		return nil
+	}
+	clientset := m.clientset
+	m.status = "Loading logs..."
+
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), tuiRequestTimeout)
+		defer cancel()
+
+		title := fmt.Sprintf("Previous logs of %s/%s", namespace, pod)
+		if container != "" {
+			title += "/" + container
+		}
+		lines := int64(tuiLogLines)
+		data, err := clientset.CoreV1().Pods(namespace).GetLogs(pod, &v1.PodLogOptions{
+			Container: container,
+			Previous:  true,
+			TailLines: &lines,
+		}).DoRaw(ctx)
+		if err != nil {
+			return detailMsg{title: title, content: fmt.Sprintf("failed to get previous logs: %v", err)}
+		}
+		if len(data) == 0 {
+			return detailMsg{title: title, content: "(no output)"}
+		}
+		return detailMsg{title: title, content: string(data)}
+	}
+}
+
+// formatEvents prints events oldest first
+func formatEvents(events []v1.Event) string {
+	if len(events) == 0 {
+		return "No events."
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return detect.EventTime(&events[i]).Before(detect.EventTime(&events[j]))
+	})
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "LAST SEEN\tTYPE\tREASON\tCOUNT\tMESSAGE\n")
+	for i, e := range events {
+		fmt.Fprintf(w, "%s ago\t%s\t%s\t%d\t%s\n",
+			time.Since(detect.EventTime(&events[i])).Truncate(time.Second),
+			e.Type,
+			e.Reason,
+			detect.EventCount(&events[i]),
+			strings.ReplaceAll(e.Message, "\n", " "),
+		)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// podContainer is a container of the pod spec
+type podContainer struct {
+	name  string
+	image string
+	kind  string
+}
+
+// podContainers returns the init, regular and ephemeral containers of a pod
+// in the order the kubelet starts them
+func podContainers(pod *v1.Pod) []podContainer {
+	var containers []podContainer
+	for _, container := range pod.Spec.InitContainers {
+		kind := detect.ContainerKindInit
+		if container.RestartPolicy != nil && *container.RestartPolicy == v1.ContainerRestartPolicyAlways {
+			kind = detect.ContainerKindSidecar
+		}
+		containers = append(containers, podContainer{name: container.Name, image: container.Image, kind: kind})
+	}
+	for _, container := range pod.Spec.Containers {
+		containers = append(containers, podContainer{name: container.Name, image: container.Image, kind: detect.ContainerKindRegular})
+	}
+	for _, container := range pod.Spec.EphemeralContainers {
+		containers = append(containers, podContainer{name: container.Name, image: container.Image, kind: detect.ContainerKindEphemeral})
+	}
+	return containers
+}
+
+// describePod summarizes a pod and its errors. The container selected for
+// the logs is marked with an arrow.
+func describePod(pod *v1.Pod, errors []podError, selected string) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Phase:     %s\n", pod.Status.Phase)
+	fmt.Fprintf(&b, "Node:      %s\n", pod.Spec.NodeName)
+	if pod.Status.StartTime != nil {
+		fmt.Fprintf(&b, "Started:   %s (%s ago)\n",
+			pod.Status.StartTime.Format(time.RFC3339),
+			time.Since(pod.Status.StartTime.Time).Truncate(time.Second))
+	}
+	for _, owner := range pod.OwnerReferences {
+		fmt.Fprintf(&b, "Owner:     %s/%s\n", owner.Kind, owner.Name)
+	}
+
+	b.WriteString("\nConditions:\n")
+	for _, condition := range pod.Status.Conditions {
+		fmt.Fprintf(&b, "  %-20s %s", condition.Type, condition.Status)
+		if condition.Reason != "" {
+			fmt.Fprintf(&b, " (%s)", condition.Reason)
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\nContainers:\n")
+	statuses := make(map[string]v1.ContainerStatus)
+	for _, container := range detect.ContainerStatuses(pod) {
+		statuses[container.Status.Name] = container.Status
+	}
+	for _, container := range podContainers(pod) {
+		marker := "  "
+		if container.name == selected {
+			marker = "> "
+		}
+		status := statuses[container.name]
+		fmt.Fprintf(&b, "%s%s\n", marker, containerLabel(podError{Finding: detect.Finding{ContainerName: container.name, ContainerKind: container.kind}}))
+		fmt.Fprintf(&b, "    Image:    %s\n", container.image)
+		fmt.Fprintf(&b, "    Ready:    %t, restarts %d\n", status.Ready, status.RestartCount)
+		fmt.Fprintf(&b, "    State:    %s\n", describeState(status.State))
+		if status.LastTerminationState.Terminated != nil {
+			fmt.Fprintf(&b, "    Last:     %s\n", describeState(status.LastTerminationState))
+		}
+	}
+
+	b.WriteString("\nErrors:\n")
+	if len(errors) == 0 {
+		b.WriteString("  none\n")
+	}
+	for _, e := range errors {
+		fmt.Fprintf(&b, "  %s", e.ErrorType)
+		if e.ContainerName != "" {
+			fmt.Fprintf(&b, " in %s", containerLabel(e))
+		}
+		if e.ExitCode != 0 {
+			fmt.Fprintf(&b, " (exit %s)", exitLabel(e))
+		}
+		if e.ErrorMessage != "" {
+			fmt.Fprintf(&b, ": %s", strings.ReplaceAll(e.ErrorMessage, "\n", " "))
+		}
+		b.WriteString("\n")
+		for _, event := range e.Events {
+			fmt.Fprintf(&b, "    %s x%d, %s ago: %s\n", event.Reason, event.Count,
+				eventAge(event), strings.ReplaceAll(event.Message, "\n", " "))
+		}
+	}
+
+	return b.String()
+}
+
+// eventAge is the time since an event of an error was last seen
+func eventAge(event detect.PodEvent) string {
+	lastSeen, err := time.Parse(time.RFC3339, event.LastSeen)
+	if err != nil {
+		return "-"
+	}
+	return time.Since(lastSeen).Truncate(time.Second).String()
+}
+
+func describeState(state v1.ContainerState) string {
+	switch {
+	case state.Waiting != nil:
+		return fmt.Sprintf("Waiting (%s) %s", state.Waiting.Reason, state.Waiting.Message)
+	case state.Running != nil:
+		return fmt.Sprintf("Running since %s", state.Running.StartedAt.Format(time.RFC3339))
+	case state.Terminated != nil:
+		t := state.Terminated
+		return fmt.Sprintf("Terminated (%s), exit code %d at %s", t.Reason, t.ExitCode, t.FinishedAt.Format(time.RFC3339))
+	}
+	return "Unknown"
+}
+
+func (m *tuiModel) View() string {
+	var body string
+	switch m.view {
+	case viewPod, viewDetail:
+		body = titleStyle.Render(m.title) + "\n" + m.detail.View()
+	default:
+		body = m.activeList().View()
+	}
+	return body + "\n" + m.statusLine()
+}
+
+// statusLine shows the context, the refresh time and the key bindings
+func (m *tuiModel) statusLine() string {
+	if m.status != "" {
+		return m.status
+	}
+
+	var keys string
+	switch m.view {
+	case viewNamespaces:
+		keys = "enter: errors · /: filter · c: contexts · q: quit"
+	case viewErrors:
+		keys = "enter: pod · e: events · l: previous logs · /: filter · esc: back"
+	case viewPod:
+		keys = "e: events · l: previous logs · tab: next container · esc: back"
+	case viewDetail:
+		keys = "↑/↓: scroll · esc: back"
+	case viewContexts:
+		keys = "enter: switch · /: filter · esc: back"
+	}
+
+	refreshed := ""
+	if !m.refreshed.IsZero() {
+		refreshed = " · refreshed " + m.refreshed.Format("15:04:05")
+	}
+	return statusStyle.Render(m.kubeContext + refreshed + " · " + keys)
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"pod-error-monitor/detect"
+
+	"github.com/charmbracelet/lipgloss"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/tools/cache"
+)
+
+// watchDebounce groups bursts of pod events into one frame
+const watchDebounce = 500 * time.Millisecond
+
+var (
+	addedStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("2")).Bold(true)
+	resolvedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("1")).Strikethrough(true)
+	headerStyle   = lipgloss.NewStyle().Bold(true)
+)
+
+// key identifies an error across refreshes. Errors of a Job or CronJob are
+// told apart by their owner.
+func (e podError) key() string {
+	return e.Namespace + "/" + podLabel(e) + "/" + e.ContainerName + "/" + e.ErrorType
+}
+
+// watchSources selects what a podWatcher caches besides the pods
+type watchSources struct {
+	// events attaches the tracked pod warnings to the errors
+	events bool
+	// workloads caches the CronJobs, Deployments and StatefulSets whose own
+	// state raises errors. The Jobs and ReplicaSets that own pods are always
+	// cached.
+	workloads bool
+	// configs looks up the ConfigMaps and Secrets that pods which cannot
+	// start refer to, on every refresh
+	configs bool
+}
+
+// podWatcher keeps an informer cache of the pods of one namespace (all when
+// empty) matching a label selector (all when empty) and signals changed
+// whenever a pod is added, updated or deleted
+type podWatcher struct {
+	factory  informers.SharedInformerFactory
+	informer cache.SharedIndexInformer
+	lister   corelisters.PodLister
+	// Events come from a separate factory limited to pod warnings, nil
+	// unless watched. They only enrich the errors, so sync does not wait
+	// for them.
+	eventFactory  informers.SharedInformerFactory
+	eventInformer cache.SharedIndexInformer
+	events        corelisters.EventLister
+	// Workloads come from a factory without the pod label selector. Like
+	// events, sync does not wait for them. The listers of workloads that
+	// are not watched are nil.
+	workloadFactory informers.SharedInformerFactory
+	workloadsSynced []cache.InformerSynced
+	jobs            batchlisters.JobLister
+	cronJobs        batchlisters.CronJobLister
+	deployments     appslisters.DeploymentLister
+	statefulSets    appslisters.StatefulSetLister
+	replicaSets     appslisters.ReplicaSetLister
+	detectors       *detect.Registry
+	changed         chan struct{}
+	stopCh          chan struct{}
+	stopOnce        sync.Once
+	// clientset and metadata look up the references of pods, unless configs
+	// is false. Secrets are not looked up without metadata.
+	clientset kubernetes.Interface
+	metadata  metadata.Interface
+	configs   bool
+}
+
+func newPodWatcher(clientset kubernetes.Interface, metadataClient metadata.Interface, namespace, selector string, detectors *detect.Registry,
+	sources watchSources) *podWatcher {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = selector
+		}),
+	)
+	podInformer := factory.Core().V1().Pods()
+
+	w := &podWatcher{
+		factory:   factory,
+		informer:  podInformer.Informer(),
+		lister:    podInformer.Lister(),
+		clientset: clientset,
+		metadata:  metadataClient,
+		configs:   sources.configs,
+		detectors: detectors,
+		changed:   make(chan struct{}, 1),
+		stopCh:    make(chan struct{}),
+	}
+
+	notify := func() {
+		select {
+		case w.changed <- struct{}{}:
+		default:
+		}
+	}
+	w.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { notify() },
+		UpdateFunc: func(interface{}, interface{}) { notify() },
+		DeleteFunc: func(interface{}) { notify() },
+	})
+
+	if sources.events {
+		w.eventFactory = informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+			informers.WithNamespace(namespace),
+			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.FieldSelector = detect.PodEventSelector
+			}),
+		)
+		eventInformer := w.eventFactory.Core().V1().Events()
+		w.eventInformer = eventInformer.Informer()
+		w.events = eventInformer.Lister()
+
+		// Only warnings attached to errors can change them
+		w.eventInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				if event, ok := obj.(*v1.Event); ok && detect.IsTrackedEvent(event.Reason) {
+					notify()
+				}
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				oldEvent, ok1 := oldObj.(*v1.Event)
+				newEvent, ok2 := newObj.(*v1.Event)
+				if !ok1 || !ok2 || oldEvent.ResourceVersion == newEvent.ResourceVersion || !detect.IsTrackedEvent(newEvent.Reason) {
+					return
+				}
+				notify()
+			},
+		})
+	}
+
+	w.workloadFactory = informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithNamespace(namespace),
+	)
+	jobInformer := w.workloadFactory.Batch().V1().Jobs()
+	replicaSetInformer := w.workloadFactory.Apps().V1().ReplicaSets()
+	w.jobs = jobInformer.Lister()
+	w.replicaSets = replicaSetInformer.Lister()
+	workloadInformers := []cache.SharedIndexInformer{
+		jobInformer.Informer(),
+		replicaSetInformer.Informer(),
+	}
+	if sources.workloads {
+		cronJobInformer := w.workloadFactory.Batch().V1().CronJobs()
+		deploymentInformer := w.workloadFactory.Apps().V1().Deployments()
+		statefulSetInformer := w.workloadFactory.Apps().V1().StatefulSets()
+		w.cronJobs = cronJobInformer.Lister()
+		w.deployments = deploymentInformer.Lister()
+		w.statefulSets = statefulSetInformer.Lister()
+		workloadInformers = append(workloadInformers,
+			cronJobInformer.Informer(),
+			deploymentInformer.Informer(),
+			statefulSetInformer.Informer(),
+		)
+	}
+	for _, informer := range workloadInformers {
+		w.workloadsSynced = append(w.workloadsSynced, informer.HasSynced)
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(interface{}) { notify() },
+			UpdateFunc: func(interface{}, interface{}) { notify() },
+			DeleteFunc: func(interface{}) { notify() },
+		})
+	}
+
+	return w
+}
+
+// sync starts the informer and waits for the initial pod list
+func (w *podWatcher) sync(ctx context.Context) error {
+	w.factory.Start(w.stopCh)
+	if w.eventFactory != nil {
+		w.eventFactory.Start(w.stopCh)
+	}
+	w.workloadFactory.Start(w.stopCh)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-w.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if !cache.WaitForCacheSync(ctx.Done(), w.informer.HasSynced) {
+		return fmt.Errorf("failed to sync pod cache")
+	}
+	return nil
+}
+
+// podErrors returns the errors of the cached pods and workloads
+func (w *podWatcher) podErrors() ([]podError, error) {
+	pods, err := w.lister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %v", err)
+	}
+	var configs detect.ConfigLookup
+	if w.configs {
+		ctx, cancel := context.WithTimeout(context.Background(), configLookupTimeout)
+		defer cancel()
+		configs = newConfigGetter(ctx, w.clientset, w.metadata)
+	}
+	return findPodErrors(pods, w.eventIndex(), w.workloadIndex(pods), configs, w.detectors), nil
+}
+
+// eventIndex indexes the cached pod warnings, or returns nil when events
+// are not watched or their informer has not synced
+func (w *podWatcher) eventIndex() *detect.EventIndex {
+	if w.eventInformer == nil || !w.eventInformer.HasSynced() {
+		return nil
+	}
+	events, err := w.events.List(labels.Everything())
+	if err != nil {
+		return nil
+	}
+	return detect.NewEventIndex(events, eventWindow, time.Now())
+}
+
+// workloadIndex collects the cached workloads and indexes pods by the
+// workload controlling them, or returns nil while their informers have not
+// synced
+func (w *podWatcher) workloadIndex(pods []*v1.Pod) *detect.Workloads {
+	for _, synced := range w.workloadsSynced {
+		if !synced() {
+			return nil
+		}
+	}
+	jobs, err := w.jobs.List(labels.Everything())
+	if err != nil {
+		return nil
+	}
+	replicaSets, err := w.replicaSets.List(labels.Everything())
+	if err != nil {
+		return nil
+	}
+
+	var (
+		cronJobs     []*batchv1.CronJob
+		deployments  []*appsv1.Deployment
+		statefulSets []*appsv1.StatefulSet
+	)
+	if w.cronJobs != nil {
+		if cronJobs, err = w.cronJobs.List(labels.Everything()); err != nil {
+			return nil
+		}
+		if deployments, err = w.deployments.List(labels.Everything()); err != nil {
+			return nil
+		}
+		if statefulSets, err = w.statefulSets.List(labels.Everything()); err != nil {
+			return nil
+		}
+	}
+	return detect.NewWorkloads(jobs, cronJobs, deployments, statefulSets, replicaSets, pods)
+}
+
+func (w *podWatcher) stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopCh)
+		w.factory.Shutdown()
+		if w.eventFactory != nil {
+			w.eventFactory.Shutdown()
+		}
+		w.workloadFactory.Shutdown()
+	})
+}
+
+// watchErrors re-renders the errors whenever a pod changes and at least
+// every interval, until ctx is cancelled. Wide adds the node, owner, image
+// and age columns.
+func watchErrors(ctx context.Context, clientset kubernetes.Interface, metadataClient metadata.Interface, kubeContext, namespace string, detectors *detect.Registry, interval time.Duration, wide bool) error {
+	watcher := newPodWatcher(clientset, metadataClient, namespace, "", detectors, watchSources{events: true, workloads: true, configs: true})
+	defer watcher.stop()
+	if err := watcher.sync(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+
+	var (
+		previous   map[string]podError
+		added      map[string]bool
+		resolved   []podError
+		lastChange = time.Now()
+	)
+
+	render := func() error {
+		allErrors, err := watcher.podErrors()
+		if err != nil {
+			return err
+		}
+
+		current := make(map[string]podError, len(allErrors))
+		for _, e := range allErrors {
+			current[e.key()] = e
+		}
+
+		// The first frame has nothing to compare against. Afterwards the
+		// highlights of the last change stay until the next one.
+		if previous != nil {
+			frameAdded := make(map[string]bool)
+			for key := range current {
+				if _, exists := previous[key]; !exists {
+					frameAdded[key] = true
+				}
+			}
+			var frameResolved []podError
+			for key, e := range previous {
+				if _, exists := current[key]; !exists {
+					frameResolved = append(frameResolved, e)
+				}
+			}
+			if len(frameAdded) > 0 || len(frameResolved) > 0 {
+				added, resolved = frameAdded, frameResolved
+				lastChange = time.Now()
+			}
+		}
+		previous = current
+		if added == nil {
+			added = make(map[string]bool)
+		}
+
+		// Clear the screen and move the cursor home
+		fmt.Print("\033[H\033[2J")
+		fmt.Println(headerStyle.Render(fmt.Sprintf("Context: %s", kubeContext)))
+		if namespace != "" {
+			fmt.Printf("Namespace: %s\n", namespace)
+		}
+		fmt.Printf("Refreshed %s, last change %s ago (+%d / -%d), every %s. Press Ctrl-C to exit.\n",
+			time.Now().Format("15:04:05"),
+			time.Since(lastChange).Truncate(time.Second),
+			len(added),
+			len(resolved),
+			interval,
+		)
+
+		printErrors(allErrors, added, wide)
+
+		if len(resolved) > 0 {
+			fmt.Println("\nResolved since the previous change:")
+			fmt.Println("-----------------------------------")
+			rows := make([]errorRow, 0, len(resolved))
+			for _, e := range resolved {
+				rows = append(rows, errorRow{err: e, resolved: true})
+			}
+			printErrorTable(rows, true, wide)
+		}
+		return nil
+	}
+
+	if err := render(); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println()
+			return nil
+		case <-watcher.changed:
+			select {
+			case <-ctx.Done():
+				fmt.Println()
+				return nil
+			case <-time.After(watchDebounce):
+			}
+		case <-ticker.C:
+		}
+
+		if err := render(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPodWatcherSources(t *testing.T) {
+	tests := []struct {
+		name          string
+		sources       watchSources
+		wantEvents    bool
+		wantWorkloads int
+	}{
+		{name: "gate", sources: watchSources{}, wantWorkloads: 2},
+		{name: "watch and TUI", sources: watchSources{events: true, workloads: true}, wantEvents: true, wantWorkloads: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newPodWatcher(fake.NewSimpleClientset(), nil, "shop", "", nil, tt.sources)
+			defer w.stop()
+			if got := w.eventFactory != nil; got != tt.wantEvents {
+				t.Errorf("watches events = %v, want %v", got, tt.wantEvents)
+			}
+			if len(w.workloadsSynced) != tt.wantWorkloads {
+				t.Errorf("starts %d workload informers, want %d", len(w.workloadsSynced), tt.wantWorkloads)
+			}
+			// The gate indexes pod owners without the other workloads
+			waitForWorkloads(t, w)
+		})
+	}
+}
+
+// waitForWorkloads syncs the watcher and waits until it indexes workloads
+func waitForWorkloads(t *testing.T, w *podWatcher) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := w.sync(ctx); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	for w.workloadIndex(nil) == nil {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("workloads did not sync")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestPodWatcherTrackedEvents(t *testing.T) {
+	tests := []struct {
+		name        string
+		reason      string
+		wantChanged bool
+	}{
+		{name: "tracked warning", reason: "BackOff", wantChanged: true},
+		{name: "untracked warning", reason: "DNSConfigForming"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			w := newPodWatcher(clientset, nil, "shop", "", nil, watchSources{events: true})
+			defer w.stop()
+			waitForWorkloads(t, w)
+			for !w.eventInformer.HasSynced() {
+				time.Sleep(10 * time.Millisecond)
+			}
+			// Drop the signals of the initial lists
+			select {
+			case <-w.changed:
+			default:
+			}
+
+			event := &v1.Event{
+				ObjectMeta:     metav1.ObjectMeta{Namespace: "shop", Name: "api-1.warning"},
+				InvolvedObject: v1.ObjectReference{Kind: "Pod", Namespace: "shop", Name: "api-1"},
+				Type:           v1.EventTypeWarning,
+				Reason:         tt.reason,
+			}
+			if _, err := clientset.CoreV1().Events("shop").Create(context.Background(), event, metav1.CreateOptions{}); err != nil {
+				t.Fatalf("creating event: %v", err)
+			}
+
+			changed := false
+			select {
+			case <-w.changed:
+				changed = true
+			case <-time.After(time.Second):
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("changed = %v, want %v", changed, tt.wantChanged)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"pod-error-monitor/detect"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	groupByPod      = "pod"
+	groupByWorkload = "workload"
+)
+
+// workloadStats aggregates the errors of the pods owned by one workload
+type workloadStats struct {
+	namespace     string
+	kind          string
+	name          string
+	desired       int32
+	pods          int
+	affectedPods  int
+	totalErrors   int
+	errorTypes    map[string]int
+	totalRestarts int32
+	score         float64
+}
+
+// workloadLists holds the workloads of a run whose own state the detectors
+// check or that own pods. A kind that cannot be listed (e.g. for missing
+// permissions) is left empty, so the others still count.
+type workloadLists struct {
+	jobs         []*batchv1.Job
+	cronJobs     []*batchv1.CronJob
+	deployments  []*appsv1.Deployment
+	statefulSets []*appsv1.StatefulSet
+	replicaSets  []*appsv1.ReplicaSet
+}
+
+// listWorkloads lists the workloads of namespace (all when empty), each kind
+// on its own
+func listWorkloads(ctx context.Context, clientset kubernetes.Interface, namespace string) workloadLists {
+	var lists workloadLists
+	if list, err := clientset.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{}); err == nil {
+		lists.jobs = itemPointers(list.Items)
+	}
+	if list, err := clientset.BatchV1().CronJobs(namespace).List(ctx, metav1.ListOptions{}); err == nil {
+		lists.cronJobs = itemPointers(list.Items)
+	}
+	if list, err := clientset.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{}); err == nil {
+		lists.deployments = itemPointers(list.Items)
+	}
+	if list, err := clientset.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{}); err == nil {
+		lists.statefulSets = itemPointers(list.Items)
+	}
+	if list, err := clientset.AppsV1().ReplicaSets(namespace).List(ctx, metav1.ListOptions{}); err == nil {
+		lists.replicaSets = itemPointers(list.Items)
+	}
+	return lists
+}
+
+// itemPointers returns pointers to the items of a list
+func itemPointers[T any](items []T) []*T {
+	pointers := make([]*T, 0, len(items))
+	for i := range items {
+		pointers = append(pointers, &items[i])
+	}
+	return pointers
+}
+
+// index indexes the listed workloads and the pods they control
+func (l workloadLists) index(pods []*v1.Pod) *detect.Workloads {
+	return detect.NewWorkloads(l.jobs, l.cronJobs, l.deployments, l.statefulSets, l.replicaSets, pods)
+}
+
+// desiredReplicas returns the replicas each listed workload asks for, by
+// namespace and "Kind/name". DaemonSets are listed here, since only grouping
+// by workload needs them.
+func (l workloadLists) desiredReplicas(ctx context.Context, clientset kubernetes.Interface, namespace string) map[string]int32 {
+	desired := make(map[string]int32)
+	for _, d := range l.deployments {
+		desired[d.Namespace+"/Deployment/"+d.Name] = detect.ReplicaCount(d.Spec.Replicas)
+	}
+	for _, s := range l.statefulSets {
+		desired[s.Namespace+"/StatefulSet/"+s.Name] = detect.ReplicaCount(s.Spec.Replicas)
+	}
+	for _, r := range l.replicaSets {
+		desired[r.Namespace+"/ReplicaSet/"+r.Name] = detect.ReplicaCount(r.Spec.Replicas)
+	}
+	for _, j := range l.jobs {
+		desired[j.Namespace+"/Job/"+j.Name] = detect.ReplicaCount(j.Spec.Parallelism)
+	}
+	if list, err := clientset.AppsV1().DaemonSets(namespace).List(ctx, metav1.ListOptions{}); err == nil {
+		for _, d := range list.Items {
+			desired[d.Namespace+"/DaemonSet/"+d.Name] = d.Status.DesiredNumberScheduled
+		}
+	}
+	return desired
+}
+
+// groupWorkloads groups errors by the workload owning their pods, which index
+// resolves, scoring each workload like a namespace. Desired replicas come
+// from desired, by namespace and owner, and fall back to the number of pods
+// for a workload that was not listed.
+func groupWorkloads(pods []*v1.Pod, index *detect.Workloads, desired map[string]int32, errors []podError) []workloadStats {
+	podsByOwner := make(map[string]int)
+	for _, pod := range pods {
+		if pod.Status.Phase != v1.PodSucceeded {
+			podsByOwner[pod.Namespace+"/"+index.PodOwner(pod)]++
+		}
+	}
+
+	byOwner := make(map[string][]podError)
+	for _, e := range errors {
+		key := e.Namespace + "/" + e.Owner
+		byOwner[key] = append(byOwner[key], e)
+	}
+
+	workloads := make([]workloadStats, 0, len(byOwner))
+	for key, ownerErrors := range byOwner {
+		first := ownerErrors[0]
+		kind, name, _ := strings.Cut(first.Owner, "/")
+		stats := workloadStats{
+			namespace:  first.Namespace,
+			kind:       kind,
+			name:       name,
+			pods:       podsByOwner[key],
+			errorTypes: make(map[string]int),
+		}
+
+		findings := make([]detect.Finding, 0, len(ownerErrors))
+		for _, e := range ownerErrors {
+			findings = append(findings, e.Finding)
+			stats.errorTypes[e.ErrorType]++
+		}
+
+		// A workload scores like a namespace holding only its errors
+		tally := detect.TallyFindings(findings, detect.ScoreOptions{})
+		stats.affectedPods = tally.Pods
+		stats.totalErrors = tally.Counts.Total
+		stats.totalRestarts = tally.Counts.Restarts
+		stats.score = tally.Score(detect.DefaultWeights)
+		if stats.pods < stats.affectedPods {
+			stats.pods = stats.affectedPods
+		}
+
+		stats.desired = int32(stats.pods)
+		if replicas, ok := desired[key]; ok {
+			stats.desired = replicas
+		}
+		workloads = append(workloads, stats)
+	}
+
+	sort.Slice(workloads, func(i, j int) bool {
+		if workloads[i].score != workloads[j].score {
+			return workloads[i].score > workloads[j].score
+		}
+		return workloads[i].kind+"/"+workloads[i].name < workloads[j].kind+"/"+workloads[j].name
+	})
+	return workloads
+}
+
+// errorTypesLabel summarizes error counts as "Type xCount", most frequent first
+func errorTypesLabel(errorTypes map[string]int) string {
+	types := make([]string, 0, len(errorTypes))
+	for errorType := range errorTypes {
+		types = append(types, errorType)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if errorTypes[types[i]] != errorTypes[types[j]] {
+			return errorTypes[types[i]] > errorTypes[types[j]]
+		}
+		return types[i] < types[j]
+	})
+
+	labels := make([]string, 0, len(types))
+	for _, errorType := range types {
+		labels = append(labels, fmt.Sprintf("%s x%d", errorType, errorTypes[errorType]))
+	}
+	return strings.Join(labels, ", ")
+}
+
+// printWorkloads prints the namespace statistics and the errors grouped by
+// workload
+func printWorkloads(allErrors []podError, workloads []workloadStats) {
+	stats := calculateNamespaceStats(allErrors)
+	printNamespaceStats(stats)
+
+	fmt.Println("\nErrors by Workload:")
+	fmt.Println("-------------------")
+
+	byNamespace := make(map[string][]workloadStats)
+	for _, workload := range workloads {
+		byNamespace[workload.namespace] = append(byNamespace[workload.namespace], workload)
+	}
+
+	for _, ns := range stats {
+		nsWorkloads := byNamespace[ns.name]
+		if len(nsWorkloads) == 0 {
+			continue
+		}
+
+		fmt.Printf("\nNamespace: %s (Score: %.1f, %d workloads)\n", ns.name, ns.score, len(nsWorkloads))
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "WORKLOAD\tAFFECTED\tSCORE\tERRORS\tRESTARTS\tTYPES\n")
+		fmt.Fprintf(w, "--------\t--------\t-----\t------\t--------\t-----\n")
+		for _, workload := range nsWorkloads {
+			fmt.Fprintf(w, "%s/%s\t%d/%d\t%.1f\t%d\t%d\t%s\n",
+				workload.kind,
+				workload.name,
+				workload.affectedPods,
+				workload.desired,
+				workload.score,
+				workload.totalErrors,
+				workload.totalRestarts,
+				errorTypesLabel(workload.errorTypes),
+			)
+		}
+		w.Flush()
+	}
+	fmt.Println()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"pod-error-monitor/detect"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func int32Ptr(i int32) *int32 { return &i }
+
+func controller(kind, name string) []metav1.OwnerReference {
+	isController := true
+	return []metav1.OwnerReference{{Kind: kind, Name: name, UID: types.UID("uid-" + name), Controller: &isController}}
+}
+
+func TestWorkloadListsSurviveDeniedKinds(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "api", UID: "uid-api"},
+		Spec:       appsv1.DeploymentSpec{Replicas: int32Ptr(4)},
+	}
+	replicaSet := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "api-6d9f", OwnerReferences: controller("Deployment", "api")},
+		Spec:       appsv1.ReplicaSetSpec{Replicas: int32Ptr(4)},
+	}
+	pod := testPod("api-6d9f-x2k4q", 0, waiting("CrashLoopBackOff"), v1.ContainerState{})
+	pod.OwnerReferences = controller("ReplicaSet", "api-6d9f")
+
+	tests := []struct {
+		name        string
+		denied      []string
+		wantOwner   string
+		wantDesired int32
+	}{
+		{name: "all kinds listed", wantOwner: "Deployment/api", wantDesired: 4},
+		{name: "CronJobs and StatefulSets denied", denied: []string{"cronjobs", "statefulsets"}, wantOwner: "Deployment/api", wantDesired: 4},
+		{name: "ReplicaSets denied", denied: []string{"replicasets"}, wantOwner: "ReplicaSet/api-6d9f", wantDesired: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(deployment, replicaSet)
+			for _, resource := range tt.denied {
+				clientset.PrependReactor("list", resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: action.GetResource().Resource}, "", errors.New("denied"))
+				})
+			}
+
+			lists := listWorkloads(context.Background(), clientset, "shop")
+			if len(lists.deployments) != 1 {
+				t.Errorf("listed %d deployments, want 1", len(lists.deployments))
+			}
+			index := lists.index([]*v1.Pod{pod})
+			if owner := index.PodOwner(pod); owner != tt.wantOwner {
+				t.Errorf("owner = %s, want %s", owner, tt.wantOwner)
+			}
+
+			errors := []podError{{Finding: detect.Finding{
+				Namespace: "shop", PodName: pod.Name, ErrorType: "CrashLoopBackOff", Owner: index.PodOwner(pod),
+			}}}
+			workloads := groupWorkloads([]*v1.Pod{pod}, index, lists.desiredReplicas(context.Background(), clientset, "shop"), errors)
+			if len(workloads) != 1 || workloads[0].desired != tt.wantDesired {
+				t.Errorf("workloads = %+v, want one desiring %d replicas", workloads, tt.wantDesired)
+			}
+		})
+	}
+}
@@ -11,6 +11,9 @@ interface NamespaceStats {
   crashLoop: number;
   imagePull: number;
   highRestarts: number;
+  pending: number;
+  rollout: number;
+  custom: number;
   totalRestarts: number;
 }
 
@@ -20,13 +23,52 @@ interface PodError {
   errorType: string;
   errorMessage: string;
   containerName: string;
+  containerKind?: string;
   restartCount: number;
+  owner?: string;
+  exitCode?: number;
+  signal?: string;
+  finishedAt?: string;
+  events?: PodEvent[];
+  replicas?: ReplicaStatus;
+  rule?: string;
+  severity?: string;
+}
+
+interface ReplicaStatus {
+  desired: number;
+  ready: number;
+  available: number;
+  updated: number;
+  revision?: string;
+  previousRevision?: string;
+}
+
+interface PodEvent {
+  reason: string;
+  message: string;
+  count: number;
+  lastSeen: string;
+}
+
+interface WorkloadStats {
+  namespace: string;
+  kind: string;
+  name: string;
+  desiredReplicas: number;
+  pods: number;
+  affectedPods: number;
+  totalErrors: number;
+  errorTypes: Record<string, number>;
+  totalRestarts: number;
+  score: number;
 }
 
 function App() {
   const [namespaces, setNamespaces] = useState<NamespaceStats[]>([]);
   const [selectedNamespace, setSelectedNamespace] = useState<string | null>(null);
   const [podErrors, setPodErrors] = useState<PodError[]>([]);
+  const [workloads, setWorkloads] = useState<WorkloadStats[]>([]);
   const [loading, setLoading] = useState(true);
   const [error, setError] = useState<string | null>(null);
   
@@ -47,12 +89,13 @@ function App() {
   useEffect(() => {
     if (selectedNamespace) {
       fetchPodErrors(selectedNamespace);
+      fetchWorkloads(selectedNamespace);
     }
   }, [selectedNamespace, currentContext]); // Refetch when context or namespace changes
 
   const fetchNamespaces = async () => {
     try {
-      const response = await fetch('http://localhost:8080/api/namespaces');
+      const response = await fetch('http://localhost:8080/api/namespaces', { credentials: 'include' });
       if (!response.ok) {
         throw new Error('Failed to fetch namespaces');
       }
@@ -68,7 +111,7 @@ function App() {
 
   const fetchPodErrors = async (namespace: string) => {
     try {
-      const response = await fetch(`http://localhost:8080/api/namespaces/${namespace}/pods`);
+      const response = await fetch(`http://localhost:8080/api/namespaces/${namespace}/pods`, { credentials: 'include' });
       if (!response.ok) {
         throw new Error('Failed to fetch pod errors');
       }
@@ -80,6 +123,20 @@ function App() {
     }
   };
 
+  const fetchWorkloads = async (namespace: string) => {
+    try {
+      const response = await fetch(`http://localhost:8080/api/namespaces/${namespace}/workloads`, { credentials: 'include' });
+      if (!response.ok) {
+        throw new Error('Failed to fetch workloads');
+      }
+      const data = await response.json();
+      setWorkloads(data);
+      setError(null);
+    } catch (err) {
+      setError('Failed to fetch workloads');
+    }
+  };
+
   const handleNamespaceClick = (namespace: string) => {
     setSelectedNamespace(selectedNamespace === namespace ? null : namespace);
   };
@@ -89,6 +146,7 @@ function App() {
     // Reset selected namespace when switching contexts
     setSelectedNamespace(null);
     setPodErrors([]);
+    setWorkloads([]);
   };
 
   if (loading) return <div className="loading">Loading...</div>;
@@ -127,6 +185,21 @@ function App() {
               <span className="inline-block bg-orange-100 text-orange-800 px-2 py-1 rounded text-xs">
                 High Restarts: {ns.highRestarts}
               </span>
+              {ns.pending > 0 && (
+                <span className="inline-block bg-purple-100 text-purple-800 px-2 py-1 rounded text-xs">
+                  Pending: {ns.pending}
+                </span>
+              )}
+              {ns.rollout > 0 && (
+                <span className="inline-block bg-blue-100 text-blue-800 px-2 py-1 rounded text-xs">
+                  Rollouts: {ns.rollout}
+                </span>
+              )}
+              {ns.custom > 0 && (
+                <span className="inline-block bg-gray-100 text-gray-800 px-2 py-1 rounded text-xs">
+                  Custom Rules: {ns.custom}
+                </span>
+              )}
             </div>
           </div>
         ))}
@@ -134,17 +207,40 @@ function App() {
 
       {selectedNamespace && (
         <div className="mt-8">
+          <h3 className="text-xl font-bold mb-4">Workloads in {selectedNamespace}</h3>
+          <div className="space-y-2 mb-8">
+            {workloads.map((workload) => (
+              <div key={`${workload.kind}/${workload.name}`} className="bg-white p-4 rounded-lg shadow flex justify-between items-start">
+                <div>
+                  <h4 className="font-semibold">{workload.kind}/{workload.name}</h4>
+                  <p className="text-sm text-gray-600">
+                    {workload.affectedPods}/{workload.desiredReplicas} replicas affected, {workload.totalErrors} errors
+                  </p>
+                  <p className="text-xs text-gray-600">
+                    {Object.entries(workload.errorTypes).map(([type, count]) => `${type} x${count}`).join(', ')}
+                  </p>
+                </div>
+                <span className="text-sm text-gray-600">Score: {workload.score.toFixed(1)}</span>
+              </div>
+            ))}
+          </div>
           <h3 className="text-xl font-bold mb-4">Pod Errors in {selectedNamespace}</h3>
           <div className="space-y-4">
             {podErrors.map((error, index) => (
               <div key={index} className="bg-white p-4 rounded-lg shadow">
                 <div className="flex justify-between items-start">
                   <div>
-                    <h4 className="font-semibold">{error.podName}</h4>
-                    <p className="text-sm text-gray-600">Container: {error.containerName}</p>
+                    <h4 className="font-semibold">{error.podName || error.owner}</h4>
+                    {error.containerName && (
+                      <p className="text-sm text-gray-600">
+                        Container: {error.containerName}
+                        {error.containerKind && error.containerKind !== 'container' && ` (${error.containerKind})`}
+                      </p>
+                    )}
                   </div>
                   <span className="text-sm bg-red-100 text-red-800 px-2 py-1 rounded">
                     {error.errorType}
+                    {error.severity && ` (${error.severity})`}
                   </span>
                 </div>
                 <p className="mt-2 text-sm text-gray-700">{error.errorMessage}</p>
@@ -153,6 +249,31 @@ function App() {
                     Restart Count: {error.restartCount}
                   </p>
                 )}
+                {error.exitCode !== undefined && (
+                  <p className="mt-1 text-sm text-gray-600">
+                    Exit Code: {error.exitCode}
+                    {error.signal && ` (${error.signal})`}
+                    {error.finishedAt && `, finished ${new Date(error.finishedAt).toLocaleString()}`}
+                  </p>
+                )}
+                {error.replicas && (
+                  <p className="mt-1 text-sm text-gray-600">
+                    Replicas: {error.replicas.available}/{error.replicas.desired} available,
+                    {' '}{error.replicas.ready} ready, {error.replicas.updated} updated
+                    {error.replicas.revision && `, revision ${error.replicas.revision}`}
+                    {error.replicas.previousRevision && ` (previous ${error.replicas.previousRevision})`}
+                  </p>
+                )}
+                {error.events && error.events.length > 0 && (
+                  <ul className="mt-2 space-y-1">
+                    {error.events.map((event, eventIndex) => (
+                      <li key={eventIndex} className="text-xs text-gray-600">
+                        <span className="font-semibold">{event.reason}</span> x{event.count}
+                        {' '}({new Date(event.lastSeen).toLocaleString()}): {event.message}
+                      </li>
+                    ))}
+                  </ul>
+                )}
               </div>
             ))}
           </div>
@@ -22,7 +22,7 @@ const useKubeContext = (): UseKubeContextResult => {
 
   const fetchContexts = async () => {
     try {
-      const response = await fetch('http://localhost:8080/api/contexts');
+      const response = await fetch('http://localhost:8080/api/contexts', { credentials: 'include' });
       if (!response.ok) {
         throw new Error('Failed to fetch contexts');
       }
@@ -41,6 +41,7 @@ const useKubeContext = (): UseKubeContextResult => {
     try {
       const response = await fetch(`http://localhost:8080/api/contexts/${context}`, {
         method: 'POST',
+        credentials: 'include',
       });
       if (!response.ok) {
         throw new Error('Failed to switch context');